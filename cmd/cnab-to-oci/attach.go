@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cnabio/cnab-to-oci/remotes"
+	"github.com/distribution/reference"
+	"github.com/spf13/cobra"
+)
+
+const defaultBlobMediaType = "application/octet-stream"
+
+type attachOptions struct {
+	targetRef          string
+	artifactType       string
+	files              []string
+	annotations        []string
+	insecureRegistries []string
+}
+
+func attachCmd() *cobra.Command {
+	var opts attachOptions
+	cmd := &cobra.Command{
+		Use:   "attach <ref> [options]",
+		Short: "Attaches an artifact (signature, SBOM, document...) to a pushed bundle",
+		Long: "The attach command pushes an artifact manifest whose subject is the bundle index, so that it can be listed with the referrers command. " +
+			"Each file is pushed as a blob of the artifact, with an optional media type: --file path[:media type].",
+		Args: cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			opts.targetRef = args[0]
+			if opts.artifactType == "" {
+				return errors.New("--artifact-type flag must be set")
+			}
+			return runAttach(opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.artifactType, "artifact-type", "", "artifact type of the attached artifact")
+	cmd.Flags().StringArrayVarP(&opts.files, "file", "f", nil, "file to attach, with an optional media type (path[:media type])")
+	cmd.Flags().StringArrayVar(&opts.annotations, "annotation", nil, "annotation of the attached artifact (key=value)")
+	cmd.Flags().StringSliceVar(&opts.insecureRegistries, "insecure-registries", nil, "Use plain HTTP for those registries")
+	return cmd
+}
+
+func runAttach(opts attachOptions) error {
+	ref, err := reference.ParseNormalizedNamed(opts.targetRef)
+	if err != nil {
+		return err
+	}
+
+	var blobs []remotes.AttachedBlob
+	for _, file := range opts.files {
+		path, mediaType := file, defaultBlobMediaType
+		if i := strings.LastIndex(file, ":"); i > 0 {
+			path, mediaType = file[:i], file[i+1:]
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		blobs = append(blobs, remotes.AttachedBlob{
+			MediaType: mediaType,
+			Title:     filepath.Base(path),
+			Data:      data,
+		})
+	}
+	var attachOptions []remotes.AttachOption
+	if len(opts.annotations) != 0 {
+		annotations := map[string]string{}
+		for _, annotation := range opts.annotations {
+			key, value, found := strings.Cut(annotation, "=")
+			if !found {
+				return fmt.Errorf("invalid annotation %q, expected key=value", annotation)
+			}
+			annotations[key] = value
+		}
+		attachOptions = append(attachOptions, remotes.WithAttachAnnotations(annotations))
+	}
+
+	resolver, err := createResolver(opts.insecureRegistries)
+	if err != nil {
+		return err
+	}
+	d, err := remotes.Attach(context.Background(), ref, resolver, opts.artifactType, blobs, attachOptions...)
+	if err != nil {
+		return err
+	}
+	return printResult(commandResult{Command: "attach", Digest: d.Digest},
+		"Attached successfully, with digest %q\n", d.Digest)
+}
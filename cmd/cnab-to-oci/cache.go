@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/cnabio/cnab-to-oci/remotes"
+	"github.com/docker/go-units"
+)
+
+// blobCacheOptions returns the resolver options using the blob cache selected by the global flags, if any
+func blobCacheOptions() ([]remotes.ResolverOption, error) {
+	if global.cacheDir == "" {
+		return nil, nil
+	}
+	maxSize, err := units.RAMInBytes(global.cacheMaxSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --cache-max-size %q: %s", global.cacheMaxSize, err)
+	}
+	cache, err := remotes.NewBlobCache(global.cacheDir, remotes.WithBlobCacheMaxSize(maxSize), remotes.WithBlobCacheTagTTL(global.cacheTagTTL))
+	if err != nil {
+		return nil, err
+	}
+	return []remotes.ResolverOption{remotes.WithBlobCache(cache)}, nil
+}
+
+// offlineOptions returns the resolver options serving everything from the blob cache, when offline
+func offlineOptions(offline bool) ([]remotes.ResolverOption, error) {
+	if !offline {
+		return nil, nil
+	}
+	if global.cacheDir == "" {
+		return nil, fmt.Errorf("--offline requires a --cache-dir")
+	}
+	return []remotes.ResolverOption{remotes.WithOffline()}, nil
+}
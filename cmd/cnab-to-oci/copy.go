@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/cnabio/cnab-to-oci/remotes"
+	"github.com/distribution/reference"
+	"github.com/spf13/cobra"
+)
+
+type copyOptions struct {
+	sourceRef          string
+	targetRef          string
+	insecureRegistries []string
+	allowFallbacks     bool
+	maxRetries         int
+}
+
+func copyCmd() *cobra.Command {
+	var opts copyOptions
+	cmd := &cobra.Command{
+		Use:   "copy <ref> [options]",
+		Short: "Copies a bundle and all its images to another repository",
+		Long: "The copy command copies a bundle already pushed to a registry, with its config and all its images, to another repository. " +
+			"The bundle index is pushed unchanged, so it keeps the same digest.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			opts.sourceRef = args[0]
+			if opts.targetRef == "" {
+				return errors.New("--target flag must be set with a namespace ")
+			}
+			return runCopy(opts)
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.targetRef, "target", "t", "", "reference where the bundle will be copied")
+	cmd.Flags().StringSliceVar(&opts.insecureRegistries, "insecure-registries", nil, "Use plain HTTP for those registries")
+	cmd.Flags().BoolVar(&opts.allowFallbacks, "allow-fallbacks", false, "Push the index as a Docker manifest list if the target registry does not support OCI indexes (changes the bundle digest)")
+	cmd.Flags().IntVar(&opts.maxRetries, "max-retries", 0, "Retry the registry operations failing with a transient error (5xx, 429, connection reset) up to this number of times")
+	return cmd
+}
+
+func runCopy(opts copyOptions) error {
+	srcRef, err := reference.ParseNormalizedNamed(opts.sourceRef)
+	if err != nil {
+		return err
+	}
+	dstRef, err := reference.ParseNormalizedNamed(opts.targetRef)
+	if err != nil {
+		return err
+	}
+
+	var fallbacks []string
+	copyOptions := []remotes.CopyOption{
+		remotes.WithCopyEventCallback(eventCallback()),
+		remotes.WithCopyFallbackCallback(func(fallback string) {
+			fallbacks = append(fallbacks, fallback)
+		}),
+		remotes.WithCopyRetryPolicy(retryPolicy(opts.maxRetries)),
+	}
+	if opts.allowFallbacks {
+		copyOptions = append(copyOptions, remotes.WithCopyFallbacks())
+	}
+	resolver, err := createResolver(opts.insecureRegistries)
+	if err != nil {
+		return err
+	}
+	d, err := remotes.Copy(context.Background(), srcRef, dstRef, resolver, copyOptions...)
+	if err != nil {
+		return err
+	}
+	return printResult(commandResult{Command: "copy", Digest: d.Digest, Fallbacks: fallbacks},
+		"Copied successfully, with digest %q\n", d.Digest)
+}
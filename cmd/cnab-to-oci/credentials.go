@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+
+	"github.com/cnabio/cnab-to-oci/remotes"
+	"github.com/docker/cli/cli/config"
+	"go.yaml.in/yaml/v2"
+)
+
+// credentialProvider returns the source of the registry credentials selected by the global flags: the default docker
+// CLI configuration, or the --registry-config file, preceded by the environment variables with --registry-auth-env
+func credentialProvider() (remotes.CredentialProvider, error) {
+	provider := remotes.DockerConfigCredentials(config.LoadDefaultConfigFile(os.Stderr))
+	if global.registryConfig != "" {
+		var err error
+		if provider, err = registryConfigCredentials(global.registryConfig); err != nil {
+			return nil, err
+		}
+	}
+	if global.registryAuthEnv {
+		provider = remotes.ChainCredentials(remotes.EnvCredentials(), provider)
+	}
+	return provider, nil
+}
+
+// registryConfigCredentials loads a Kubernetes dockerconfigjson secret manifest, or a docker CLI configuration file
+func registryConfigCredentials(file string) (remotes.CredentialProvider, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var manifest struct {
+		Kind string `yaml:"kind"`
+	}
+	if yaml.Unmarshal(data, &manifest) == nil && manifest.Kind == "Secret" {
+		return remotes.NewKubernetesSecretCredentials(file)
+	}
+	return remotes.NewDockerConfigFileCredentials(file)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/cnabio/cnab-to-oci/remotes"
+	"github.com/distribution/reference"
+	"github.com/spf13/cobra"
+)
+
+type exportOptions struct {
+	targetRef           string
+	output              string
+	invocationPlatforms []string
+	componentPlatforms  []string
+	insecureRegistries  []string
+}
+
+func exportCmd() *cobra.Command {
+	var opts exportOptions
+	cmd := &cobra.Command{
+		Use:   "export <ref> [options]",
+		Short: "Exports a bundle and all its images to an OCI image layout",
+		Long: "The export command writes a bundle, with its config and the full content of all its images, to an OCI image layout. " +
+			"The layout is written to a directory, or to a tar archive if the output ends with .tar. " +
+			"As --output is the destination, the JSON output is selected with the global --output-format flag.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			opts.targetRef = args[0]
+			if opts.output == "" {
+				return errors.New("--output flag must be set with a directory or a .tar file")
+			}
+			return runExport(opts)
+		},
+	}
+
+	// The local flag shadows the global output format flag
+	cmd.Flags().StringVarP(&opts.output, "output", "o", "", "directory or .tar archive where the OCI image layout will be written")
+	cmd.Flags().StringSliceVar(&opts.invocationPlatforms, "invocation-platforms", nil, "Platforms of the invocation images to export")
+	cmd.Flags().StringSliceVar(&opts.componentPlatforms, "component-platforms", nil, "Platforms of the component images to export")
+	cmd.Flags().StringSliceVar(&opts.insecureRegistries, "insecure-registries", nil, "Use plain HTTP for those registries")
+	return cmd
+}
+
+func runExport(opts exportOptions) error {
+	ref, err := reference.ParseNormalizedNamed(opts.targetRef)
+	if err != nil {
+		return err
+	}
+
+	dir := opts.output
+	archive := strings.HasSuffix(opts.output, ".tar")
+	if archive {
+		if dir, err = os.MkdirTemp("", "cnab-to-oci-export"); err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+	}
+
+	resolver, err := createResolver(opts.insecureRegistries)
+	if err != nil {
+		return err
+	}
+	d, err := remotes.Export(context.Background(), ref, resolver, dir,
+		remotes.WithExportInvocationImagePlatforms(opts.invocationPlatforms),
+		remotes.WithExportComponentImagePlatforms(opts.componentPlatforms))
+	if err != nil {
+		return err
+	}
+	if archive {
+		if err := writeTarArchive(dir, opts.output); err != nil {
+			return err
+		}
+	}
+	return printResult(commandResult{Command: "export", Digest: d.Digest},
+		"Exported successfully to %s, with digest %q\n", opts.output, d.Digest)
+}
@@ -3,13 +3,15 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/cnabio/cnab-go/bundle"
 	"github.com/cnabio/cnab-to-oci/remotes"
 	containerdRemotes "github.com/containerd/containerd/v2/core/remotes"
 	"github.com/distribution/reference"
-	"github.com/docker/cli/cli/config"
 	"github.com/spf13/cobra"
 )
 
@@ -20,6 +22,11 @@ type fixupOptions struct {
 	targetRef          string
 	insecureRegistries []string
 	autoUpdateBundle   bool
+	copyReferrers      bool
+	referrerTypes      []string
+	maxRetries         int
+	dryRun             bool
+	policy             string
 }
 
 func fixupCmd() *cobra.Command {
@@ -39,6 +46,11 @@ func fixupCmd() *cobra.Command {
 	cmd.Flags().StringVarP(&opts.targetRef, "target", "t", "", "reference where the bundle will be pushed")
 	cmd.Flags().StringSliceVar(&opts.insecureRegistries, "insecure-registries", nil, "Use plain HTTP for those registries")
 	cmd.Flags().BoolVar(&opts.autoUpdateBundle, "auto-update-bundle", false, "Updates the bundle image properties with the one resolved on the registry")
+	cmd.Flags().BoolVar(&opts.copyReferrers, "copy-referrers", false, "Copy the referrers of the images (signatures, attestations...) next to the relocated images")
+	cmd.Flags().StringSliceVar(&opts.referrerTypes, "referrer-artifact-types", nil, "Only copy the referrers with those artifact types")
+	cmd.Flags().IntVar(&opts.maxRetries, "max-retries", 0, "Retry the registry operations failing with a transient error (5xx, 429, connection reset) up to this number of times")
+	cmd.Flags().BoolVar(&opts.dryRun, "dry-run", false, "Print what the fixup would copy, mount or skip, without writing anything")
+	cmd.Flags().StringVar(&opts.policy, "policy", "", "YAML or JSON policy file restricting the images the fixup is allowed to relocate")
 	return cmd
 }
 
@@ -59,19 +71,47 @@ func runFixup(opts fixupOptions) error {
 	}
 
 	fixupOptions := []remotes.FixupOption{
-		remotes.WithEventCallback(displayEvent),
+		remotes.WithEventCallback(eventCallback()),
+		remotes.WithRetryPolicy(retryPolicy(opts.maxRetries)),
 	}
 	if opts.autoUpdateBundle {
 		fixupOptions = append(fixupOptions, remotes.WithAutoBundleUpdate())
 	}
-	relocationMap, err := remotes.FixupBundle(context.Background(), b, ref, createResolver(opts.insecureRegistries), fixupOptions...)
+	if opts.copyReferrers {
+		fixupOptions = append(fixupOptions, remotes.WithReferrers(opts.referrerTypes...))
+	}
+	if opts.policy != "" {
+		policy, err := remotes.LoadPolicy(opts.policy)
+		if err != nil {
+			return err
+		}
+		fixupOptions = append(fixupOptions, remotes.WithPolicy(policy))
+	}
+	resolver, err := createResolver(opts.insecureRegistries)
+	if err != nil {
+		return err
+	}
+	if opts.dryRun {
+		plan, err := remotes.PlanFixup(context.Background(), b, ref, resolver, fixupOptions...)
+		if err != nil {
+			return err
+		}
+		return printPlan("fixup", plan)
+	}
+	relocationMap, err := remotes.FixupBundle(context.Background(), b, ref, resolver, fixupOptions...)
 	if err != nil {
 		return err
 	}
 	if err := writeOutput(opts.bundle, b); err != nil {
 		return err
 	}
-	return writeOutput(opts.relocationMap, relocationMap)
+	if err := writeOutput(opts.relocationMap, relocationMap); err != nil {
+		return err
+	}
+	if jsonOutput() {
+		return printResultJSON(commandResult{Command: "fixup", Bundle: stdoutBundle(opts.bundle, b), RelocationMap: relocationMap})
+	}
+	return nil
 }
 
 func displayEvent(ev remotes.FixupEvent) {
@@ -87,6 +127,72 @@ func displayEvent(ev remotes.FixupEvent) {
 	}
 }
 
-func createResolver(insecureRegistries []string) containerdRemotes.Resolver {
-	return remotes.CreateResolver(config.LoadDefaultConfigFile(os.Stderr), insecureRegistries...)
+// printPlan prints a fixup plan as a table, or as the JSON result in JSON output mode
+func printPlan(command string, plan *remotes.FixupPlan) error {
+	if jsonOutput() {
+		return printResultJSON(commandResult{Command: command, Plan: plan})
+	}
+	return writePlanTable(os.Stdout, plan)
+}
+
+func writePlanTable(out io.Writer, plan *remotes.FixupPlan) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "IMAGE\tSOURCE\tSTRATEGY\tPLATFORMS\tCOPY\tMOUNT\tSKIP\tBYTES")
+	for _, image := range plan.Images {
+		actions := map[remotes.PlanAction]int{}
+		for _, d := range image.Descriptors {
+			actions[d.Action]++
+		}
+		strategy := string(image.Strategy)
+		if image.Action != "" {
+			strategy = fmt.Sprintf("%s (%s)", image.Strategy, image.Action)
+		}
+		platforms := strings.Join(image.Platforms, ",")
+		if platforms == "" {
+			platforms = "all"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\n", image.Name, image.SourceImage, strategy, platforms,
+			actions[remotes.PlanActionCopy], actions[remotes.PlanActionMount],
+			actions[remotes.PlanActionSkipExisting]+actions[remotes.PlanActionSkipForeignLayer], image.BytesToTransfer)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(out, "Total bytes to transfer: %d\n", plan.BytesToTransfer)
+	return err
+}
+
+// retryPolicy returns the default retry policy with the given number of retries, or no retry at all
+func retryPolicy(maxRetries int) remotes.RetryPolicy {
+	if maxRetries <= 0 {
+		return remotes.RetryPolicy{}
+	}
+	policy := remotes.DefaultRetryPolicy()
+	policy.MaxAttempts = maxRetries + 1
+	return policy
+}
+
+// createResolver creates a resolver using the registry credentials, certificates, hosts directories and blob cache
+// selected by the global flags, followed by the given options
+func createResolver(insecureRegistries []string, options ...remotes.ResolverOption) (containerdRemotes.Resolver, error) {
+	credentials, err := credentialProvider()
+	if err != nil {
+		return nil, err
+	}
+	resolverOptions := []remotes.ResolverOption{
+		remotes.WithCredentialProvider(credentials),
+		remotes.WithInsecureRegistries(insecureRegistries...),
+	}
+	if global.certsDir != "" {
+		resolverOptions = append(resolverOptions, remotes.WithCertsDir(global.certsDir))
+	}
+	if global.hostsDir != "" {
+		resolverOptions = append(resolverOptions, remotes.WithHostsDir(global.hostsDir))
+	}
+	cacheOptions, err := blobCacheOptions()
+	if err != nil {
+		return nil, err
+	}
+	resolverOptions = append(resolverOptions, cacheOptions...)
+	return remotes.CreateResolverWithOptions(nil, append(resolverOptions, options...)...)
 }
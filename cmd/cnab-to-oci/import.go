@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/cnabio/cnab-to-oci/remotes"
+	"github.com/distribution/reference"
+	"github.com/spf13/cobra"
+)
+
+type importOptions struct {
+	input              string
+	targetRef          string
+	referenceName      string
+	relocationMap      string
+	insecureRegistries []string
+}
+
+func importCmd() *cobra.Command {
+	var opts importOptions
+	cmd := &cobra.Command{
+		Use:   "import <layout dir|tar> [options]",
+		Short: "Imports a bundle and all its images from an OCI image layout",
+		Long: "The import command pushes a bundle exported to an OCI image layout, with all its images, to the target repository. " +
+			"The bundle index is pushed unchanged, so it keeps the same digest.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			opts.input = args[0]
+			if opts.targetRef == "" {
+				return errors.New("--target flag must be set with a namespace ")
+			}
+			return runImport(opts)
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.targetRef, "target", "t", "", "reference where the bundle will be pushed")
+	cmd.Flags().StringVar(&opts.referenceName, "reference-name", "", "reference name of the bundle to import, when the layout contains several bundles")
+	cmd.Flags().StringVar(&opts.relocationMap, "relocation-map", "relocation-map.json", "relocation map output file (- to print on standard output)")
+	cmd.Flags().StringSliceVar(&opts.insecureRegistries, "insecure-registries", nil, "Use plain HTTP for those registries")
+	return cmd
+}
+
+func runImport(opts importOptions) error {
+	ref, err := reference.ParseNormalizedNamed(opts.targetRef)
+	if err != nil {
+		return err
+	}
+
+	dir := opts.input
+	fi, err := os.Stat(opts.input)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		if dir, err = os.MkdirTemp("", "cnab-to-oci-import"); err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		if err := extractTarArchive(opts.input, dir); err != nil {
+			return err
+		}
+	}
+
+	resolver, err := createResolver(opts.insecureRegistries)
+	if err != nil {
+		return err
+	}
+	d, relocationMap, err := remotes.Import(context.Background(), dir, ref, resolver,
+		remotes.WithImportEventCallback(eventCallback()),
+		remotes.WithImportReferenceName(opts.referenceName))
+	if err != nil {
+		return err
+	}
+	if !jsonOutput() {
+		fmt.Fprintf(os.Stderr, "Imported successfully, with digest %q\n", d.Digest)
+	}
+	if err := writeOutput(opts.relocationMap, relocationMap); err != nil {
+		return err
+	}
+	if jsonOutput() {
+		return printResultJSON(commandResult{Command: "import", Digest: d.Digest, RelocationMap: relocationMap})
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/cnabio/cnab-to-oci/remotes"
+	"github.com/distribution/reference"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/cobra"
+)
+
+type inspectOptions struct {
+	targetRef          string
+	insecureRegistries []string
+	offline            bool
+}
+
+func inspectCmd() *cobra.Command {
+	var opts inspectOptions
+	cmd := &cobra.Command{
+		Use:   "inspect <ref> [options]",
+		Short: "Shows how a bundle is stored in a registry",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			opts.targetRef = args[0]
+			return runInspect(opts)
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&opts.insecureRegistries, "insecure-registries", nil, "Use plain HTTP for those registries")
+	cmd.Flags().BoolVar(&opts.offline, "offline", false, "Only use the content of the --cache-dir cache, without reaching the registry")
+	return cmd
+}
+
+func runInspect(opts inspectOptions) error {
+	ref, err := reference.ParseNormalizedNamed(opts.targetRef)
+	if err != nil {
+		return err
+	}
+
+	resolverOptions, err := offlineOptions(opts.offline)
+	if err != nil {
+		return err
+	}
+	resolver, err := createResolver(opts.insecureRegistries, resolverOptions...)
+	if err != nil {
+		return err
+	}
+	inspection, err := remotes.Inspect(context.Background(), ref, resolver)
+	if err != nil {
+		return err
+	}
+	if jsonOutput() {
+		return printResultJSON(commandResult{Command: "inspect", Inspection: inspection})
+	}
+	return printInspection(os.Stdout, inspection)
+}
+
+func printInspection(out io.Writer, inspection *remotes.BundleInspection) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Reference:\t%s\n", inspection.Reference)
+	printDescriptor(w, "Index:", inspection.IndexDescriptor)
+	printDescriptor(w, "Config manifest:", inspection.ConfigManifestDescriptor)
+	printDescriptor(w, "Config blob:", inspection.ConfigBlobDescriptor)
+	if inspection.ArtifactType != "" {
+		fmt.Fprintf(w, "Artifact type:\t%s\n", inspection.ArtifactType)
+	}
+	if inspection.ConfigArtifactType != "" {
+		fmt.Fprintf(w, "Config artifact type:\t%s\n", inspection.ConfigArtifactType)
+	}
+	for _, fallback := range inspection.Fallbacks {
+		fmt.Fprintf(w, "Fallback:\t%s\n", fallback)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(inspection.Annotations) != 0 {
+		fmt.Fprintln(out, "\nAnnotations:")
+		w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		for _, key := range sortedKeys(inspection.Annotations) {
+			fmt.Fprintf(w, "  %s\t%s\n", key, inspection.Annotations[key])
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintln(out, "\nImages:")
+	w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  TYPE\tNAME\tDIGEST\tMEDIA TYPE\tSIZE")
+	for _, image := range inspection.Images {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%d\n", image.Type, image.ComponentName, image.Descriptor.Digest, image.Descriptor.MediaType, image.Descriptor.Size)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out, "\nRelocation map:")
+	w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, original := range sortedKeys(inspection.RelocationMap) {
+		fmt.Fprintf(w, "  %s\t%s\n", original, inspection.RelocationMap[original])
+	}
+	return w.Flush()
+}
+
+func printDescriptor(w io.Writer, title string, d ocischemav1.Descriptor) {
+	fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", title, d.Digest, d.MediaType, d.Size)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"errors"
 	"os"
+	"time"
 
+	"github.com/cnabio/cnab-to-oci/remotes"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
 func main() {
+	global.startedAt = time.Now()
 	var logLevel string
 	cmd := &cobra.Command{
 		Use:          "cnab-to-oci <subcommand> [options]",
@@ -18,12 +22,29 @@ func main() {
 				return err
 			}
 			logrus.SetLevel(level)
-			return nil
+			// "table" is the previous name of the text output of the inspect and referrers commands
+			if global.output == "table" {
+				global.output = outputFormatText
+			}
+			return validateOutputFormat(global.output)
 		},
 	}
 	cmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", `Set the logging level ("debug"|"info"|"warn"|"error"|"fatal")`)
-	cmd.AddCommand(fixupCmd(), pushCmd(), pullCmd(), versionCmd())
-	if err := cmd.Execute(); err != nil {
+	cmd.PersistentFlags().StringVarP(&global.output, "output", "o", outputFormatText, `Output format ("text"|"json"). In json mode, events and the command result are printed as one JSON object per line`)
+	cmd.PersistentFlags().StringVar(&global.output, "output-format", outputFormatText, `Output format ("text"|"json"), like --output, for the commands whose --output flag is a destination, like export`)
+	cmd.PersistentFlags().StringVar(&global.hostsDir, "hosts-dir", "", `Directory of containerd style "<registry>/hosts.toml" files, declaring registry mirrors and endpoint overrides`)
+	cmd.PersistentFlags().StringVar(&global.certsDir, "certs-dir", "", `Directory of docker style "<registry>/" certificate directories, holding the CA bundles and client certificates of the registries`)
+	cmd.PersistentFlags().StringVar(&global.cacheDir, "cache-dir", "", "Directory of a local cache of the registry blobs and manifests, shared between the runs")
+	cmd.PersistentFlags().StringVar(&global.cacheMaxSize, "cache-max-size", "10GiB", "Size of the cache above which the least recently used blobs are evicted")
+	cmd.PersistentFlags().DurationVar(&global.cacheTagTTL, "cache-tag-ttl", remotes.DefaultBlobCacheTagTTL, "Duration during which a tag resolved through the cache is not resolved again")
+	cmd.PersistentFlags().StringVar(&global.registryConfig, "registry-config", "", "Docker configuration file, or Kubernetes dockerconfigjson secret manifest, to read the registry credentials from, instead of the docker CLI configuration")
+	cmd.PersistentFlags().BoolVar(&global.registryAuthEnv, "registry-auth-env", false, "Read the registry credentials from the CNAB_TO_OCI_USERNAME_<HOST>, CNAB_TO_OCI_PASSWORD_<HOST> and CNAB_TO_OCI_TOKEN_<HOST> environment variables first")
+	cmd.AddCommand(fixupCmd(), pushCmd(), pullCmd(), inspectCmd(), copyCmd(), exportCmd(), importCmd(), attachCmd(), referrersCmd(), signCmd(), verifyCmd(), versionCmd())
+	if executed, err := cmd.ExecuteC(); err != nil {
+		var printed printedError
+		if jsonOutput() && !errors.As(err, &printed) {
+			_ = printResultJSON(commandResult{Command: executed.Name(), Error: err.Error()})
+		}
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/cnabio/cnab-go/bundle"
+	"github.com/cnabio/cnab-to-oci/relocation"
+	"github.com/cnabio/cnab-to-oci/remotes"
+	"github.com/opencontainers/go-digest"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	outputFormatText = "text"
+	outputFormatJSON = "json"
+)
+
+// globalOptions are the options shared by all the commands
+type globalOptions struct {
+	output          string
+	hostsDir        string
+	certsDir        string
+	cacheDir        string
+	cacheMaxSize    string
+	cacheTagTTL     time.Duration
+	registryConfig  string
+	registryAuthEnv bool
+	startedAt       time.Time
+}
+
+var global globalOptions
+
+func validateOutputFormat(format string) error {
+	switch format {
+	case outputFormatText, outputFormatJSON:
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q", format)
+}
+
+func jsonOutput() bool {
+	return global.output == outputFormatJSON
+}
+
+// eventCallback returns the callback displaying the fixup events, as text on the standard error, or as one JSON
+// object per line on the standard output
+func eventCallback() func(remotes.FixupEvent) {
+	if jsonOutput() {
+		return printEventJSON
+	}
+	return displayEvent
+}
+
+// commandResult is printed as the last JSON line of a command in JSON output mode
+type commandResult struct {
+	Type           string                        `json:"type"`
+	Command        string                        `json:"command"`
+	Digest         digest.Digest                 `json:"digest,omitempty"`
+	Bundle         *bundle.Bundle                `json:"bundle,omitempty"`
+	RelocationMap  relocation.ImageRelocationMap `json:"relocationMap,omitempty"`
+	Fallbacks      []string                      `json:"fallbacks,omitempty"`
+	Plan           *remotes.FixupPlan            `json:"plan,omitempty"`
+	Inspection     *remotes.BundleInspection     `json:"inspection,omitempty"`
+	Referrers      []ocischemav1.Descriptor      `json:"referrers,omitempty"`
+	Verification   *remotes.VerifyReport         `json:"verification,omitempty"`
+	ElapsedSeconds float64                       `json:"elapsedSeconds"`
+	Error          string                        `json:"error,omitempty"`
+}
+
+// printResult prints the result of a command in JSON output mode, or the text message otherwise
+func printResult(result commandResult, format string, args ...interface{}) error {
+	if !jsonOutput() {
+		fmt.Printf(format, args...)
+		return nil
+	}
+	return printResultJSON(result)
+}
+
+// stdoutBundle returns the bundle to print with the command result, if it is written to the standard output
+func stdoutBundle(file string, b *bundle.Bundle) *bundle.Bundle {
+	if file != "-" {
+		return nil
+	}
+	return b
+}
+
+// printedError is the error of a command which already printed it in its JSON result
+type printedError struct {
+	error
+}
+
+func printResultJSON(result commandResult) error {
+	result.Type = "result"
+	result.ElapsedSeconds = time.Since(global.startedAt).Seconds()
+	return json.NewEncoder(os.Stdout).Encode(result)
+}
+
+type jsonEvent struct {
+	Type           string                 `json:"type"`
+	EventType      remotes.FixupEventType `json:"eventType"`
+	SourceImage    string                 `json:"sourceImage"`
+	DestinationRef string                 `json:"destinationRef,omitempty"`
+	Message        string                 `json:"message,omitempty"`
+	Error          string                 `json:"error,omitempty"`
+	Progress       jsonProgress           `json:"progress"`
+}
+
+type jsonProgress struct {
+	BytesTransferred int64                    `json:"bytesTransferred"`
+	BytesSkipped     int64                    `json:"bytesSkipped"`
+	TotalBytes       int64                    `json:"totalBytes"`
+	Rate             float64                  `json:"rate"`
+	Roots            []jsonDescriptorProgress `json:"roots,omitempty"`
+}
+
+type jsonDescriptorProgress struct {
+	ocischemav1.Descriptor
+	Done             bool                     `json:"done"`
+	Action           string                   `json:"action,omitempty"`
+	Error            string                   `json:"error,omitempty"`
+	BytesTransferred int64                    `json:"bytesTransferred"`
+	BytesSkipped     int64                    `json:"bytesSkipped"`
+	TotalBytes       int64                    `json:"totalBytes"`
+	StartedAt        *time.Time               `json:"startedAt,omitempty"`
+	CompletedAt      *time.Time               `json:"completedAt,omitempty"`
+	Rate             float64                  `json:"rate"`
+	Children         []jsonDescriptorProgress `json:"children,omitempty"`
+}
+
+func printEventJSON(ev remotes.FixupEvent) {
+	event := jsonEvent{
+		Type:        "event",
+		EventType:   ev.EventType,
+		SourceImage: ev.SourceImage,
+		Message:     ev.Message,
+		Error:       errorString(ev.Error),
+		Progress: jsonProgress{
+			BytesTransferred: ev.Progress.BytesTransferred,
+			BytesSkipped:     ev.Progress.BytesSkipped,
+			TotalBytes:       ev.Progress.TotalBytes,
+			Rate:             ev.Progress.Rate,
+			Roots:            toJSONDescriptorProgresses(ev.Progress.Roots),
+		},
+	}
+	if ev.DestinationRef != nil {
+		event.DestinationRef = ev.DestinationRef.String()
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(event); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to print event: %s\n", err)
+	}
+}
+
+func toJSONDescriptorProgresses(snapshots []remotes.DescriptorProgressSnapshot) []jsonDescriptorProgress {
+	if len(snapshots) == 0 {
+		return nil
+	}
+	result := make([]jsonDescriptorProgress, len(snapshots))
+	for ix, s := range snapshots {
+		result[ix] = jsonDescriptorProgress{
+			Descriptor:       s.Descriptor,
+			Done:             s.Done,
+			Action:           s.Action,
+			Error:            errorString(s.Error),
+			BytesTransferred: s.BytesTransferred,
+			BytesSkipped:     s.BytesSkipped,
+			TotalBytes:       s.TotalBytes,
+			StartedAt:        timeOrNil(s.StartedAt),
+			CompletedAt:      timeOrNil(s.CompletedAt),
+			Rate:             s.Rate,
+			Children:         toJSONDescriptorProgresses(s.Children),
+		}
+	}
+	return result
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -17,6 +18,10 @@ type pullOptions struct {
 	relocationMap      string
 	targetRef          string
 	insecureRegistries []string
+	offline            bool
+	maxRetries         int
+	strict             bool
+	verifyKeys         []string
 }
 
 func pullCmd() *cobra.Command {
@@ -34,6 +39,10 @@ func pullCmd() *cobra.Command {
 	cmd.Flags().StringVar(&opts.bundle, "bundle", "pulled.json", "bundle output file (- to print on standard output)")
 	cmd.Flags().StringVar(&opts.relocationMap, "relocation-map", "relocation-map.json", "relocation map output file (- to print on standard output)")
 	cmd.Flags().StringSliceVar(&opts.insecureRegistries, "insecure-registries", nil, "Use plain HTTP for those registries")
+	cmd.Flags().BoolVar(&opts.offline, "offline", false, "Only use the content of the --cache-dir cache, without reaching the registry")
+	cmd.Flags().IntVar(&opts.maxRetries, "max-retries", 0, "Retry the registry operations failing with a transient error (5xx, 429, connection reset) up to this number of times")
+	cmd.Flags().BoolVar(&opts.strict, "strict", false, "Fail if the bundle.json does not match the bundle index (image digests, sizes, media types and runtime version)")
+	cmd.Flags().StringSliceVar(&opts.verifyKeys, "verify-key", nil, "Verify that the bundle is signed by one of those PEM encoded ed25519 or ECDSA public keys")
 	return cmd
 }
 
@@ -43,17 +52,57 @@ func runPull(opts pullOptions) error {
 		return err
 	}
 
-	b, relocationMap, _, err := remotes.Pull(context.Background(), ref, createResolver(opts.insecureRegistries))
+	pullOptions := []remotes.PullOption{
+		remotes.WithPullRetryPolicy(retryPolicy(opts.maxRetries)),
+	}
+	if opts.strict {
+		pullOptions = append(pullOptions, remotes.WithStrictPull())
+	}
+	if len(opts.verifyKeys) > 0 {
+		var keys []crypto.PublicKey
+		for _, file := range opts.verifyKeys {
+			keyPEM, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			key, err := remotes.ParsePublicKeyPEM(keyPEM)
+			if err != nil {
+				return fmt.Errorf("invalid public key %q: %s", file, err)
+			}
+			keys = append(keys, key)
+		}
+		pullOptions = append(pullOptions, remotes.WithSignatureVerification(keys...))
+	}
+	resolverOptions, err := offlineOptions(opts.offline)
+	if err != nil {
+		return err
+	}
+	resolver, err := createResolver(opts.insecureRegistries, resolverOptions...)
+	if err != nil {
+		return err
+	}
+	b, relocationMap, d, err := remotes.Pull(context.Background(), ref, resolver, pullOptions...)
 	if err != nil {
 		return err
 	}
 	if err := writeOutput(opts.bundle, b); err != nil {
 		return err
 	}
-	return writeOutput(opts.relocationMap, relocationMap)
+	if err := writeOutput(opts.relocationMap, relocationMap); err != nil {
+		return err
+	}
+	if jsonOutput() {
+		return printResultJSON(commandResult{Command: "pull", Digest: d, Bundle: stdoutBundle(opts.bundle, b), RelocationMap: relocationMap})
+	}
+	return nil
 }
 
+// writeOutput writes the data to the file, or to the standard output for "-". In JSON output mode, the standard output
+// only holds JSON lines: the data is then printed with the command result instead.
 func writeOutput(file string, data interface{}) error {
+	if file == "-" && jsonOutput() {
+		return nil
+	}
 	plainJSON, err := json.Marshal(data)
 	if err != nil {
 		return err
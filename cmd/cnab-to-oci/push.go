@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"os"
 
 	"github.com/cnabio/cnab-go/bundle"
@@ -23,6 +22,13 @@ type pushOptions struct {
 	componentPlatforms  []string
 	autoUpdateBundle    bool
 	pushImages          bool
+	exportImages        bool
+	ociArtifacts        bool
+	copyReferrers       bool
+	referrerTypes       []string
+	maxRetries          int
+	dryRun              bool
+	policy              string
 }
 
 func pushCmd() *cobra.Command {
@@ -47,6 +53,13 @@ func pushCmd() *cobra.Command {
 	cmd.Flags().StringSliceVar(&opts.componentPlatforms, "component-platforms", nil, "Platforms to push (for multi-arch component images)")
 	cmd.Flags().BoolVar(&opts.autoUpdateBundle, "auto-update-bundle", false, "Updates the bundle image properties with the one resolved on the registry")
 	cmd.Flags().BoolVar(&opts.pushImages, "push-images", true, "Allow to push missing images in the registry that are available in the local docker daemon image store")
+	cmd.Flags().BoolVar(&opts.exportImages, "export-images", false, "Export the missing images from the local docker daemon and upload them by digest, instead of tagging and pushing them through the daemon")
+	cmd.Flags().BoolVar(&opts.copyReferrers, "copy-referrers", false, "Copy the referrers of the images (signatures, attestations...) next to the relocated images")
+	cmd.Flags().StringSliceVar(&opts.referrerTypes, "referrer-artifact-types", nil, "Only copy the referrers with those artifact types")
+	cmd.Flags().BoolVar(&opts.ociArtifacts, "oci-artifact-manifests", false, "Push the bundle using the OCI 1.1 artifactType fields and empty config descriptor")
+	cmd.Flags().IntVar(&opts.maxRetries, "max-retries", 0, "Retry the registry operations failing with a transient error (5xx, 429, connection reset) up to this number of times")
+	cmd.Flags().BoolVar(&opts.dryRun, "dry-run", false, "Print what the fixup would copy, mount or skip, without pushing anything")
+	cmd.Flags().StringVar(&opts.policy, "policy", "", "YAML or JSON policy file restricting the images the fixup is allowed to relocate")
 
 	return cmd
 }
@@ -60,35 +73,80 @@ func runPush(opts pushOptions) error {
 	if err := json.Unmarshal(bundleJSON, &b); err != nil {
 		return err
 	}
-	resolver := createResolver(opts.insecureRegistries)
+	resolver, err := createResolver(opts.insecureRegistries)
+	if err != nil {
+		return err
+	}
 	ref, err := reference.ParseNormalizedNamed(opts.targetRef)
 	if err != nil {
 		return err
 	}
 
 	fixupOptions := []remotes.FixupOption{
-		remotes.WithEventCallback(displayEvent),
+		remotes.WithEventCallback(eventCallback()),
 		remotes.WithInvocationImagePlatforms(opts.invocationPlatforms),
 		remotes.WithComponentImagePlatforms(opts.componentPlatforms),
+		remotes.WithRetryPolicy(retryPolicy(opts.maxRetries)),
 	}
 	if opts.autoUpdateBundle {
 		fixupOptions = append(fixupOptions, remotes.WithAutoBundleUpdate())
 	}
+	if opts.copyReferrers {
+		fixupOptions = append(fixupOptions, remotes.WithReferrers(opts.referrerTypes...))
+	}
+	if opts.policy != "" {
+		policy, err := remotes.LoadPolicy(opts.policy)
+		if err != nil {
+			return err
+		}
+		fixupOptions = append(fixupOptions, remotes.WithPolicy(policy))
+	}
 	if opts.pushImages {
 		cli, err := client.New(client.FromEnv)
 		if err != nil {
 			return err
 		}
-		fixupOptions = append(fixupOptions, remotes.WithPushImages(cli, os.Stdout))
+		if opts.exportImages {
+			exportDir, err := os.MkdirTemp("", "cnab-to-oci-images-")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(exportDir)
+			fixupOptions = append(fixupOptions, remotes.WithLocalImageSource(remotes.NewDockerImageSource(cli, exportDir)))
+		} else {
+			// The standard output is reserved to the JSON lines in JSON output mode
+			pushOut := os.Stdout
+			if jsonOutput() {
+				pushOut = os.Stderr
+			}
+			fixupOptions = append(fixupOptions, remotes.WithPushImages(cli, pushOut))
+		}
+	}
+	if opts.dryRun {
+		plan, err := remotes.PlanFixup(context.Background(), &b, ref, resolver, fixupOptions...)
+		if err != nil {
+			return err
+		}
+		return printPlan("push", plan)
 	}
 	relocationMap, err := remotes.FixupBundle(context.Background(), &b, ref, resolver, fixupOptions...)
 	if err != nil {
 		return err
 	}
-	d, err := remotes.Push(context.Background(), &b, relocationMap, ref, resolver, opts.allowFallbacks)
+	var fallbacks []string
+	pushOptions := []remotes.PushOption{
+		remotes.WithPushRetryPolicy(retryPolicy(opts.maxRetries)),
+		remotes.WithPushFallbackCallback(func(fallback string) {
+			fallbacks = append(fallbacks, fallback)
+		}),
+	}
+	if opts.ociArtifacts {
+		pushOptions = append(pushOptions, remotes.WithOCIArtifactManifests())
+	}
+	d, err := remotes.PushWithOptions(context.Background(), &b, relocationMap, ref, resolver, opts.allowFallbacks, pushOptions...)
 	if err != nil {
 		return err
 	}
-	fmt.Printf("Pushed successfully, with digest %q\n", d.Digest)
-	return nil
+	return printResult(commandResult{Command: "push", Digest: d.Digest, RelocationMap: relocationMap, Fallbacks: fallbacks},
+		"Pushed successfully, with digest %q\n", d.Digest)
 }
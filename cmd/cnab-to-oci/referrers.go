@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/cnabio/cnab-to-oci/remotes"
+	"github.com/distribution/reference"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/cobra"
+)
+
+type referrersOptions struct {
+	targetRef          string
+	artifactTypes      []string
+	insecureRegistries []string
+}
+
+func referrersCmd() *cobra.Command {
+	var opts referrersOptions
+	cmd := &cobra.Command{
+		Use:   "referrers <ref> [options]",
+		Short: "Lists the artifacts attached to a pushed bundle",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			opts.targetRef = args[0]
+			return runReferrers(opts)
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&opts.artifactTypes, "artifact-type", nil, "only list the artifacts with those artifact types")
+	cmd.Flags().StringSliceVar(&opts.insecureRegistries, "insecure-registries", nil, "Use plain HTTP for those registries")
+	return cmd
+}
+
+func runReferrers(opts referrersOptions) error {
+	ref, err := reference.ParseNormalizedNamed(opts.targetRef)
+	if err != nil {
+		return err
+	}
+
+	resolver, err := createResolver(opts.insecureRegistries)
+	if err != nil {
+		return err
+	}
+	referrers, err := remotes.ListReferrers(context.Background(), ref, resolver, opts.artifactTypes...)
+	if err != nil {
+		return err
+	}
+	if jsonOutput() {
+		return printResultJSON(commandResult{Command: "referrers", Referrers: referrers})
+	}
+	return printReferrers(os.Stdout, referrers)
+}
+
+func printReferrers(out io.Writer, referrers []ocischemav1.Descriptor) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ARTIFACT TYPE\tDIGEST\tSIZE")
+	for _, d := range referrers {
+		fmt.Fprintf(w, "%s\t%s\t%d\n", d.ArtifactType, d.Digest, d.Size)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/cnabio/cnab-to-oci/remotes"
+	"github.com/distribution/reference"
+	"github.com/spf13/cobra"
+)
+
+type signOptions struct {
+	targetRef          string
+	key                string
+	insecureRegistries []string
+}
+
+func signCmd() *cobra.Command {
+	var opts signOptions
+	cmd := &cobra.Command{
+		Use:   "sign <ref> [options]",
+		Short: "Signs a pushed bundle with a local key",
+		Long: "The sign command signs the bundle index digest and the canonical bundle.json digest with an ed25519 or ECDSA private key (PEM), " +
+			"and attaches the signature to the bundle index. It can then be verified offline with pull --verify-key.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			opts.targetRef = args[0]
+			if opts.key == "" {
+				return errors.New("--key flag must be set")
+			}
+			return runSign(opts)
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.key, "key", "k", "", "PEM encoded ed25519 or ECDSA private key")
+	cmd.Flags().StringSliceVar(&opts.insecureRegistries, "insecure-registries", nil, "Use plain HTTP for those registries")
+	return cmd
+}
+
+func runSign(opts signOptions) error {
+	ref, err := reference.ParseNormalizedNamed(opts.targetRef)
+	if err != nil {
+		return err
+	}
+	keyPEM, err := os.ReadFile(opts.key)
+	if err != nil {
+		return err
+	}
+	key, err := remotes.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return err
+	}
+
+	resolver, err := createResolver(opts.insecureRegistries)
+	if err != nil {
+		return err
+	}
+	d, err := remotes.Sign(context.Background(), ref, resolver, key)
+	if err != nil {
+		return err
+	}
+	return printResult(commandResult{Command: "sign", Digest: d.Digest},
+		"Signed successfully, signature attached with digest %q\n", d.Digest)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cnabio/cnab-to-oci/remotes"
+	"github.com/distribution/reference"
+	"github.com/spf13/cobra"
+)
+
+type verifyOptions struct {
+	targetRef          string
+	insecureRegistries []string
+	deep               bool
+	maxRetries         int
+}
+
+func verifyCmd() *cobra.Command {
+	var opts verifyOptions
+	cmd := &cobra.Command{
+		Use:   "verify <ref> [options]",
+		Short: "Checks that all the content of a pushed bundle is present in the registry",
+		Long: "The verify command resolves every descriptor of the bundle index, and recursively checks that every manifest and blob is " +
+			"present in the repository with the expected size. It fails if any content is missing or invalid.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			opts.targetRef = args[0]
+			return runVerify(opts)
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&opts.insecureRegistries, "insecure-registries", nil, "Use plain HTTP for those registries")
+	cmd.Flags().BoolVar(&opts.deep, "deep", false, "Download and hash every blob, instead of only checking its presence and size")
+	cmd.Flags().IntVar(&opts.maxRetries, "max-retries", 0, "Retry the registry operations failing with a transient error (5xx, 429, connection reset) up to this number of times")
+	return cmd
+}
+
+func runVerify(opts verifyOptions) error {
+	ref, err := reference.ParseNormalizedNamed(opts.targetRef)
+	if err != nil {
+		return err
+	}
+
+	verifyOptions := []remotes.VerifyOption{
+		remotes.WithVerifyRetryPolicy(retryPolicy(opts.maxRetries)),
+	}
+	if opts.deep {
+		verifyOptions = append(verifyOptions, remotes.WithDeepVerification())
+	}
+	resolver, err := createResolver(opts.insecureRegistries)
+	if err != nil {
+		return err
+	}
+	report, err := remotes.Verify(context.Background(), ref, resolver, verifyOptions...)
+	if err != nil {
+		return err
+	}
+	var verifyErr error
+	if failures := report.Failures(); len(failures) > 0 {
+		verifyErr = fmt.Errorf("bundle %s is incomplete: %d missing or invalid descriptors", ref, len(failures))
+	}
+	if jsonOutput() {
+		result := commandResult{Command: "verify", Verification: report}
+		if verifyErr != nil {
+			result.Error = verifyErr.Error()
+			verifyErr = printedError{verifyErr}
+		}
+		if err := printResultJSON(result); err != nil {
+			return err
+		}
+		return verifyErr
+	}
+	if err := printVerifyReport(os.Stdout, report); err != nil {
+		return err
+	}
+	return verifyErr
+}
+
+func printVerifyReport(out io.Writer, report *remotes.VerifyReport) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STATUS\tDIGEST\tMEDIA TYPE\tSIZE\tMESSAGE")
+	fmt.Fprintf(w, "%s\t%s\t%s\t%d\t\n", remotes.VerifyStatusOK, report.IndexDescriptor.Digest, report.IndexDescriptor.MediaType, report.IndexDescriptor.Size)
+	printDescriptorVerifications(w, report.Descriptors, 1)
+	return w.Flush()
+}
+
+func printDescriptorVerifications(w io.Writer, verifications []remotes.DescriptorVerification, depth int) {
+	for _, v := range verifications {
+		fmt.Fprintf(w, "%s\t%s%s\t%s\t%d\t%s\n", v.Status, strings.Repeat("  ", depth), v.Digest, v.MediaType, v.Size, v.Message)
+		printDescriptorVerifications(w, v.Children, depth+1)
+	}
+}
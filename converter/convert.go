@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+	"strconv"
 
 	"github.com/cnabio/cnab-go/bundle"
 	"github.com/cnabio/cnab-to-oci/relocation"
@@ -48,6 +49,10 @@ const ( // Descriptor level annotations and values
 
 	// CNABDescriptorComponentNameAnnotation is a decriptor-level annotation specifying the component name
 	CNABDescriptorComponentNameAnnotation = "io.cnab.component.name"
+	// CNABDescriptorInvocationIndexAnnotation is a descriptor-level annotation specifying the position of an invocation image
+	// in the bundle invocation images. It is only set when the bundle has several invocation images, a missing annotation
+	// means the first one.
+	CNABDescriptorInvocationIndexAnnotation = "io.cnab.invocation.index"
 )
 
 // GetBundleConfigManifestDescriptor returns the CNAB runtime config manifest descriptor from a OCI index
@@ -112,10 +117,14 @@ func GenerateRelocationMap(ix *ocischemav1.Index, b *bundle.Bundle, originRepo r
 		switch descriptorType {
 		// The current descriptor is an invocation image
 		case CNABDescriptorTypeInvocation:
-			if len(b.InvocationImages) == 0 {
+			invocationIndex, err := GetInvocationImageIndex(d)
+			if err != nil {
+				return nil, err
+			}
+			if invocationIndex >= len(b.InvocationImages) {
 				return nil, fmt.Errorf("unknown invocation image: %q", d.Digest)
 			}
-			relocationMap[b.InvocationImages[0].Image] = refFamiliar
+			relocationMap[b.InvocationImages[invocationIndex].Image] = refFamiliar
 
 		// The current descriptor is a component image
 		case CNABDescriptorTypeComponent:
@@ -136,6 +145,19 @@ func GenerateRelocationMap(ix *ocischemav1.Index, b *bundle.Bundle, originRepo r
 	return relocationMap, nil
 }
 
+// GetInvocationImageIndex returns the position of an invocation image descriptor in the bundle invocation images
+func GetInvocationImageIndex(d ocischemav1.Descriptor) (int, error) {
+	value, ok := d.Annotations[CNABDescriptorInvocationIndexAnnotation]
+	if !ok {
+		return 0, nil
+	}
+	index, err := strconv.Atoi(value)
+	if err != nil || index < 0 {
+		return 0, fmt.Errorf("invalid invocation image index %q in descriptor %q", value, d.Digest)
+	}
+	return index, nil
+}
+
 func makeAnnotations(b *bundle.Bundle) (map[string]string, error) {
 	result := map[string]string{
 		CNABRuntimeVersionAnnotation:      string(b.SchemaVersion),
@@ -163,22 +185,28 @@ func makeAnnotations(b *bundle.Bundle) (map[string]string, error) {
 
 func makeManifests(b *bundle.Bundle, targetReference reference.Named,
 	bundleConfigManifestReference ocischemav1.Descriptor, relocationMap relocation.ImageRelocationMap) ([]ocischemav1.Descriptor, error) {
-	if len(b.InvocationImages) != 1 {
-		return nil, errors.New("only one invocation image supported")
+	if len(b.InvocationImages) == 0 {
+		return nil, errors.New("at least one invocation image is required")
 	}
 	if bundleConfigManifestReference.Annotations == nil {
 		bundleConfigManifestReference.Annotations = map[string]string{}
 	}
 	bundleConfigManifestReference.Annotations[CNABDescriptorTypeAnnotation] = CNABDescriptorTypeConfig
 	manifests := []ocischemav1.Descriptor{bundleConfigManifestReference}
-	invocationImage, err := makeDescriptor(b.InvocationImages[0].BaseImage, targetReference, relocationMap)
-	if err != nil {
-		return nil, fmt.Errorf("invalid invocation image: %s", err)
-	}
-	invocationImage.Annotations = map[string]string{
-		CNABDescriptorTypeAnnotation: CNABDescriptorTypeInvocation,
+	for i, img := range b.InvocationImages {
+		invocationImage, err := makeDescriptor(img.BaseImage, targetReference, relocationMap)
+		if err != nil {
+			return nil, fmt.Errorf("invalid invocation image: %s", err)
+		}
+		invocationImage.Annotations = map[string]string{
+			CNABDescriptorTypeAnnotation: CNABDescriptorTypeInvocation,
+		}
+		// Bundles with a single invocation image keep the same index as before multiple invocation images were supported
+		if len(b.InvocationImages) > 1 {
+			invocationImage.Annotations[CNABDescriptorInvocationIndexAnnotation] = strconv.Itoa(i)
+		}
+		manifests = append(manifests, invocationImage)
 	}
-	manifests = append(manifests, invocationImage)
 	images := makeSortedImages(b.Images)
 	for _, name := range images {
 		img := b.Images[name]
@@ -46,11 +46,28 @@ func TestConvertFromFixedUpBundleToOCI(t *testing.T) {
 	_, hasKeywords := actual.Annotations[CNABKeywordsAnnotation]
 	assert.Assert(t, !hasKeywords)
 
-	// Multiple invocation images is not supported
+	// A single invocation image is not annotated with its index
+	_, hasInvocationIndex := actual.Manifests[1].Annotations[CNABDescriptorInvocationIndexAnnotation]
+	assert.Assert(t, !hasInvocationIndex)
+
+	// Multiple invocation images are annotated with their index
 	src = tests.MakeTestBundle()
 	src.InvocationImages = append(src.InvocationImages, src.InvocationImages[0])
+	src.InvocationImages[1].Image = "my.registry/namespace/my-app-invoc-arm64"
+	multiRelocationMap := tests.MakeRelocationMap()
+	multiRelocationMap["my.registry/namespace/my-app-invoc-arm64"] = "my.registry/namespace/my-app@sha256:d59a1aa7866258751a261bae525a1842c7ff0662d4f34a355d5f36826abc0344"
+	actual, err = ConvertBundleToOCIIndex(src, named, bundleConfigDescriptor, multiRelocationMap)
+	assert.NilError(t, err)
+	assert.Equal(t, len(actual.Manifests), 5)
+	assert.Equal(t, actual.Manifests[1].Annotations[CNABDescriptorInvocationIndexAnnotation], "0")
+	assert.Equal(t, actual.Manifests[2].Annotations[CNABDescriptorInvocationIndexAnnotation], "1")
+	assert.Equal(t, actual.Manifests[2].Digest.String(), "sha256:d59a1aa7866258751a261bae525a1842c7ff0662d4f34a355d5f36826abc0344")
+
+	// At least one invocation image is required
+	src = tests.MakeTestBundle()
+	src.InvocationImages = nil
 	_, err = ConvertBundleToOCIIndex(src, named, bundleConfigDescriptor, relocationMap)
-	assert.ErrorContains(t, err, "only one invocation image supported")
+	assert.ErrorContains(t, err, "at least one invocation image is required")
 
 	// Invalid media type
 	src = tests.MakeTestBundle()
@@ -162,3 +179,37 @@ func TestGenerateRelocationMap(t *testing.T) {
 	assert.NilError(t, err)
 	assert.DeepEqual(t, relocationMap, expected)
 }
+
+func TestGenerateRelocationMapMultipleInvocationImages(t *testing.T) {
+	named, err := reference.ParseNormalizedNamed("my.registry/namespace/my-app:0.1.0")
+	assert.NilError(t, err)
+
+	b := tests.MakeTestBundle()
+	b.InvocationImages = append(b.InvocationImages, b.InvocationImages[0])
+	b.InvocationImages[1].Image = "my.registry/namespace/my-app-invoc-arm64"
+	ix := tests.MakeTestOCIIndex()
+	ix.Manifests[1].Annotations[CNABDescriptorInvocationIndexAnnotation] = "0"
+	arm64 := ix.Manifests[1]
+	arm64.Digest = "sha256:d59a1aa7866258751a261bae525a1842c7ff0662d4f34a355d5f36826abc0344"
+	arm64.Annotations = map[string]string{
+		CNABDescriptorTypeAnnotation:            CNABDescriptorTypeInvocation,
+		CNABDescriptorInvocationIndexAnnotation: "1",
+	}
+	ix.Manifests = append(ix.Manifests, arm64)
+
+	expected := tests.MakeRelocationMap()
+	expected["my.registry/namespace/my-app-invoc-arm64"] = "my.registry/namespace/my-app@sha256:d59a1aa7866258751a261bae525a1842c7ff0662d4f34a355d5f36826abc0344"
+	relocationMap, err := GenerateRelocationMap(ix, b, named)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, relocationMap, expected)
+
+	// Out of range index
+	arm64.Annotations[CNABDescriptorInvocationIndexAnnotation] = "2"
+	_, err = GenerateRelocationMap(ix, b, named)
+	assert.ErrorContains(t, err, "unknown invocation image")
+
+	// Invalid index
+	arm64.Annotations[CNABDescriptorInvocationIndexAnnotation] = "-1"
+	_, err = GenerateRelocationMap(ix, b, named)
+	assert.ErrorContains(t, err, "invalid invocation image index")
+}
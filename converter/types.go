@@ -2,6 +2,7 @@ package converter
 
 import (
 	"encoding/json"
+	"fmt"
 
 	"github.com/cnabio/cnab-go/bundle"
 	"github.com/distribution/distribution/manifest/schema2"
@@ -22,7 +23,10 @@ type PreparedBundleConfig struct {
 	ConfigBlobDescriptor ocischemav1.Descriptor
 	Manifest             []byte
 	ManifestDescriptor   ocischemav1.Descriptor
-	Fallback             *PreparedBundleConfig
+	// EmptyConfig is set when the config blob is stored as the manifest single layer, the manifest config being
+	// the OCI empty descriptor, which must be pushed too
+	EmptyConfig bool
+	Fallback    *PreparedBundleConfig
 }
 
 // PrepareForPush serializes a bundle config, generates its image manifest, and its manifest descriptor
@@ -52,6 +56,37 @@ func PrepareForPush(b *bundle.Bundle) (*PreparedBundleConfig, error) {
 	return first, nil
 }
 
+// PrepareArtifactForPush serializes a bundle config as an OCI 1.1 artifact: the manifest has a CNAB artifact type,
+// an empty config and the bundle config as its single layer. The fallback chain is the one of PrepareForPush.
+func PrepareArtifactForPush(b *bundle.Bundle) (*PreparedBundleConfig, error) {
+	blob, err := b.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	result, err := prepareOCIArtifactBundleConfig(blob)
+	if err != nil {
+		return nil, err
+	}
+	if result.Fallback, err = PrepareForPush(b); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetBundleConfigDescriptor returns the descriptor of the bundle config blob from a config manifest, either stored
+// as the manifest config or, for OCI 1.1 artifacts, as its single layer
+func GetBundleConfigDescriptor(manifest ocischemav1.Manifest) (ocischemav1.Descriptor, error) {
+	if manifest.Config.MediaType != ocischemav1.MediaTypeEmptyJSON {
+		return manifest.Config, nil
+	}
+	for _, l := range manifest.Layers {
+		if l.MediaType == CNABConfigMediaType {
+			return l, nil
+		}
+	}
+	return ocischemav1.Descriptor{}, fmt.Errorf("bundle config not found in artifact manifest with type %q", manifest.ArtifactType)
+}
+
 func descriptorOf(payload []byte, mediaType string) ocischemav1.Descriptor {
 	return ocischemav1.Descriptor{
 		MediaType: mediaType,
@@ -83,6 +118,29 @@ func prepareOCIBundleConfig(mediaType string) bundleConfigPreparer {
 	}
 }
 
+func prepareOCIArtifactBundleConfig(blob []byte) (*PreparedBundleConfig, error) {
+	manifest := ocischemav1.Manifest{
+		Versioned: ocischema.Versioned{
+			SchemaVersion: OCIIndexSchemaVersion,
+		},
+		MediaType:    ocischemav1.MediaTypeImageManifest,
+		ArtifactType: CNABConfigMediaType,
+		Config:       ocischemav1.DescriptorEmptyJSON,
+		Layers:       []ocischemav1.Descriptor{descriptorOf(blob, CNABConfigMediaType)},
+	}
+	manifestBytes, err := json.Marshal(&manifest)
+	if err != nil {
+		return nil, err
+	}
+	return &PreparedBundleConfig{
+		ConfigBlob:           blob,
+		ConfigBlobDescriptor: manifest.Layers[0],
+		Manifest:             manifestBytes,
+		ManifestDescriptor:   descriptorOf(manifestBytes, ocischemav1.MediaTypeImageManifest),
+		EmptyConfig:          true,
+	}, nil
+}
+
 func nonOCIDescriptorOf(blob []byte) distribution.Descriptor {
 	return distribution.Descriptor{
 		MediaType: schema2.MediaTypeImageConfig,
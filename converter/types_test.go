@@ -1,9 +1,11 @@
 package converter
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/cnabio/cnab-go/bundle"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"gotest.tools/v3/assert"
 )
 
@@ -26,3 +28,27 @@ func TestPrepareForPush(t *testing.T) {
 	assert.Equal(t, lastFallback.ManifestDescriptor.MediaType, "application/vnd.docker.distribution.manifest.v2+json")
 	assert.Equal(t, lastFallback.ConfigBlobDescriptor.MediaType, "application/vnd.docker.container.image.v1+json")
 }
+
+func TestPrepareArtifactForPush(t *testing.T) {
+	b := &bundle.Bundle{}
+	prepared, err := PrepareArtifactForPush(b)
+	assert.NilError(t, err)
+
+	// First try with an OCI 1.1 artifact, with an empty config and the bundle config as a layer
+	assert.Assert(t, prepared.EmptyConfig)
+	assert.Equal(t, prepared.ManifestDescriptor.MediaType, "application/vnd.oci.image.manifest.v1+json")
+	assert.Equal(t, prepared.ConfigBlobDescriptor.MediaType, "application/vnd.cnab.config.v1+json")
+	var manifest ocischemav1.Manifest
+	assert.NilError(t, json.Unmarshal(prepared.Manifest, &manifest))
+	assert.Equal(t, manifest.ArtifactType, CNABConfigMediaType)
+	assert.DeepEqual(t, manifest.Config, ocischemav1.DescriptorEmptyJSON)
+	configDescriptor, err := GetBundleConfigDescriptor(manifest)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, configDescriptor, prepared.ConfigBlobDescriptor)
+
+	// Then the same fallbacks as PrepareForPush
+	assert.Assert(t, prepared.Fallback != nil)
+	assert.Assert(t, !prepared.Fallback.EmptyConfig)
+	assert.Equal(t, prepared.Fallback.ConfigBlobDescriptor.MediaType, "application/vnd.cnab.config.v1+json")
+	assert.Equal(t, prepared.Fallback.Fallback.Fallback.ManifestDescriptor.MediaType, "application/vnd.docker.distribution.manifest.v2+json")
+}
@@ -11,3 +11,8 @@ type ImageClient interface {
 	ImagePush(ctx context.Context, ref string, options client.ImagePushOptions) (client.ImagePushResponse, error)
 	ImageTag(ctx context.Context, options client.ImageTagOptions) (client.ImageTagResult, error)
 }
+
+// ImageSaver is the subset of Docker's ImageAPIClient interface exporting the local images.
+type ImageSaver interface {
+	ImageSave(ctx context.Context, imageIDs []string, options ...client.ImageSaveOption) (client.ImageSaveResult, error)
+}
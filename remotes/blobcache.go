@@ -0,0 +1,452 @@
+package remotes
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/log"
+	"github.com/opencontainers/go-digest"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// DefaultBlobCacheMaxSize is the default size of a blob cache, above which the least recently used blobs are
+	// evicted
+	DefaultBlobCacheMaxSize = 10 << 30
+	// DefaultBlobCacheTagTTL is the default duration during which a tag resolved through a blob cache is not resolved
+	// again
+	DefaultBlobCacheTagTTL = 5 * time.Minute
+)
+
+// BlobCache is a local cache of the registry blobs and manifests, keyed by digest and shared by the runs using the
+// same directory. The blobs are stored under "blobs/<algorithm>/<hex>", their descriptors under
+// "descriptors/<algorithm>/<hex>.json", and the resolved tags under "tags".
+type BlobCache struct {
+	dir     string
+	maxSize int64
+	tagTTL  time.Duration
+	// evictions serializes the evictions
+	evictions sync.Mutex
+	now       func() time.Time
+}
+
+// BlobCacheOption is a helper for configuring a BlobCache
+type BlobCacheOption func(*BlobCache) error
+
+// NewBlobCache creates a blob cache in the directory, creating it if needed
+func NewBlobCache(dir string, options ...BlobCacheOption) (*BlobCache, error) {
+	cache := &BlobCache{
+		dir:     dir,
+		maxSize: DefaultBlobCacheMaxSize,
+		tagTTL:  DefaultBlobCacheTagTTL,
+		now:     time.Now,
+	}
+	for _, opt := range options {
+		if err := opt(cache); err != nil {
+			return nil, err
+		}
+	}
+	for _, sub := range []string{"blobs", "descriptors", "tags"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create blob cache %q: %s", dir, err)
+		}
+	}
+	return cache, nil
+}
+
+// WithBlobCacheMaxSize sets the size of the cached blobs above which the least recently used ones are evicted
+func WithBlobCacheMaxSize(size int64) BlobCacheOption {
+	return func(cache *BlobCache) error {
+		if size <= 0 {
+			return fmt.Errorf("invalid blob cache size %d, it must be positive", size)
+		}
+		cache.maxSize = size
+		return nil
+	}
+}
+
+// WithBlobCacheTagTTL sets the duration during which a resolved tag is served from the cache. With a zero duration,
+// the tags are always resolved, and only recorded for the offline mode.
+func WithBlobCacheTagTTL(ttl time.Duration) BlobCacheOption {
+	return func(cache *BlobCache) error {
+		if ttl < 0 {
+			return fmt.Errorf("invalid blob cache tag TTL %s", ttl)
+		}
+		cache.tagTTL = ttl
+		return nil
+	}
+}
+
+func (c *BlobCache) blobPath(dgst digest.Digest) string {
+	return filepath.Join(c.dir, "blobs", dgst.Algorithm().String(), dgst.Encoded())
+}
+
+func (c *BlobCache) descriptorPath(dgst digest.Digest) string {
+	return filepath.Join(c.dir, "descriptors", dgst.Algorithm().String(), dgst.Encoded()+".json")
+}
+
+func (c *BlobCache) tagPath(ref string) string {
+	sum := sha256.Sum256([]byte(ref))
+	return filepath.Join(c.dir, "tags", hex.EncodeToString(sum[:])+".json")
+}
+
+// open returns the cached blob, marking it as recently used
+func (c *BlobCache) open(dgst digest.Digest) (io.ReadCloser, bool) {
+	if dgst.Validate() != nil {
+		return nil, false
+	}
+	file, err := os.Open(c.blobPath(dgst))
+	if err != nil {
+		return nil, false
+	}
+	now := c.now()
+	_ = os.Chtimes(file.Name(), now, now)
+	return file, true
+}
+
+// descriptor returns the descriptor of a cached blob
+func (c *BlobCache) descriptor(dgst digest.Digest) (ocischemav1.Descriptor, bool) {
+	if dgst.Validate() != nil {
+		return ocischemav1.Descriptor{}, false
+	}
+	if _, err := os.Stat(c.blobPath(dgst)); err != nil {
+		return ocischemav1.Descriptor{}, false
+	}
+	var desc ocischemav1.Descriptor
+	if err := readJSONFile(c.descriptorPath(dgst), &desc); err != nil {
+		return ocischemav1.Descriptor{}, false
+	}
+	return desc, true
+}
+
+func (c *BlobCache) putDescriptor(desc ocischemav1.Descriptor) error {
+	if desc.Digest.Validate() != nil {
+		return nil
+	}
+	return writeJSONFile(c.descriptorPath(desc.Digest), ocischemav1.Descriptor{
+		MediaType: desc.MediaType,
+		Digest:    desc.Digest,
+		Size:      desc.Size,
+	})
+}
+
+// cachedTag is a tag resolved through the cache
+type cachedTag struct {
+	Reference  string                 `json:"reference"`
+	Name       string                 `json:"name"`
+	Descriptor ocischemav1.Descriptor `json:"descriptor"`
+	ResolvedAt time.Time              `json:"resolvedAt"`
+}
+
+// tag returns the cached resolution of a tag. Unless offline, it must be more recent than the TTL.
+func (c *BlobCache) tag(ref string, offline bool) (cachedTag, bool) {
+	var tag cachedTag
+	if err := readJSONFile(c.tagPath(ref), &tag); err != nil || tag.Reference != ref {
+		return cachedTag{}, false
+	}
+	if !offline && c.now().Sub(tag.ResolvedAt) >= c.tagTTL {
+		return cachedTag{}, false
+	}
+	return tag, true
+}
+
+func (c *BlobCache) putTag(ref string, name string, desc ocischemav1.Descriptor) error {
+	return writeJSONFile(c.tagPath(ref), cachedTag{Reference: ref, Name: name, Descriptor: desc, ResolvedAt: c.now()})
+}
+
+// forgetTag removes the cached resolution of a tag which has been pushed
+func (c *BlobCache) forgetTag(ref string) error {
+	if err := os.Remove(c.tagPath(ref)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// commit moves a downloaded blob into the cache, then evicts the least recently used blobs if the cache is too large
+func (c *BlobCache) commit(tempFile string, desc ocischemav1.Descriptor) error {
+	path := c.blobPath(desc.Digest)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(tempFile, path); err != nil {
+		return err
+	}
+	now := c.now()
+	_ = os.Chtimes(path, now, now)
+	if err := c.putDescriptor(desc); err != nil {
+		return err
+	}
+	return c.evict()
+}
+
+func (c *BlobCache) evict() error {
+	c.evictions.Lock()
+	defer c.evictions.Unlock()
+	type blob struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var (
+		blobs []blob
+		total int64
+	)
+	err := filepath.WalkDir(filepath.Join(c.dir, "blobs"), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || filepath.Ext(path) == ".tmp" {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		blobs = append(blobs, blob{path: path, size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].modTime.Before(blobs[j].modTime) })
+	for _, b := range blobs {
+		if total <= c.maxSize {
+			break
+		}
+		dgst := digest.NewDigestFromEncoded(digest.Algorithm(filepath.Base(filepath.Dir(b.path))), filepath.Base(b.path))
+		if err := os.Remove(b.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		_ = os.Remove(c.descriptorPath(dgst))
+		total -= b.size
+	}
+	return nil
+}
+
+func readJSONFile(file string, v interface{}) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeJSONFile writes the file atomically, so concurrent runs never read a partial file
+func writeJSONFile(file string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), file)
+}
+
+// blobCacheResolver serves the blobs, manifests and tags from a blob cache, filling it with the content fetched from
+// the registries. Offline, nothing is sent to the registries.
+type blobCacheResolver struct {
+	remotes.Resolver
+	cache   *BlobCache
+	offline bool
+}
+
+func (r *blobCacheResolver) Resolve(ctx context.Context, ref string) (string, ocischemav1.Descriptor, error) {
+	if dgst, ok := referenceDigest(ref); ok {
+		if r.offline {
+			desc, ok := r.cache.descriptor(dgst)
+			if !ok {
+				return "", ocischemav1.Descriptor{}, fmt.Errorf("%s is not in the cache, it cannot be resolved offline: %w", ref, errdefs.ErrNotFound)
+			}
+			return ref, desc, nil
+		}
+		return r.Resolver.Resolve(ctx, ref)
+	}
+
+	if tag, ok := r.cache.tag(ref, r.offline); ok {
+		return tag.Name, tag.Descriptor, nil
+	}
+	if r.offline {
+		return "", ocischemav1.Descriptor{}, fmt.Errorf("%s is not in the cache, it cannot be resolved offline: %w", ref, errdefs.ErrNotFound)
+	}
+	name, desc, err := r.Resolver.Resolve(ctx, ref)
+	if err != nil {
+		return "", ocischemav1.Descriptor{}, err
+	}
+	if err := r.cache.putTag(ref, name, desc); err != nil {
+		log.G(ctx).Warnf("Failed to cache the resolution of %s: %s", ref, err)
+	}
+	return name, desc, nil
+}
+
+func (r *blobCacheResolver) Fetcher(ctx context.Context, ref string) (remotes.Fetcher, error) {
+	if r.offline {
+		return blobCacheFetcher{cache: r.cache}, nil
+	}
+	fetcher, err := r.Resolver.Fetcher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	result := blobCacheFetcher{Fetcher: fetcher, cache: r.cache}
+	if referrersFetcher, ok := fetcher.(remotes.ReferrersFetcher); ok {
+		return blobCacheReferrersFetcher{blobCacheFetcher: result, ReferrersFetcher: referrersFetcher}, nil
+	}
+	return result, nil
+}
+
+func (r *blobCacheResolver) Pusher(ctx context.Context, ref string) (remotes.Pusher, error) {
+	if r.offline {
+		return nil, fmt.Errorf("cannot push %s offline", ref)
+	}
+	pusher, err := r.Resolver.Pusher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := referenceDigest(ref); ok {
+		return pusher, nil
+	}
+	// The manifests pushed to a tag move it
+	forget := func() { r.forgetTag(ctx, ref) }
+	forget()
+	return blobCachePusher{Pusher: pusher, forget: forget}, nil
+}
+
+// forgetTag resolves the tag again the next time, once it has been pushed
+func (r *blobCacheResolver) forgetTag(ctx context.Context, ref string) {
+	if err := r.cache.forgetTag(ref); err != nil {
+		log.G(ctx).Warnf("Failed to remove the cached resolution of %s: %s", ref, err)
+	}
+}
+
+// credentialProvider keeps the credentials of the wrapped resolver for the images pushed from the docker daemon
+func (r *blobCacheResolver) credentialProvider() CredentialProvider {
+	return defaultCredentials(r.Resolver)
+}
+
+// blobCachePusher forgets the cached resolution of the tag it pushes to, even when the manifest already exists
+type blobCachePusher struct {
+	remotes.Pusher
+	forget func()
+}
+
+func (p blobCachePusher) Push(ctx context.Context, desc ocischemav1.Descriptor) (content.Writer, error) {
+	writer, err := p.Pusher.Push(ctx, desc)
+	p.forget()
+	if err != nil {
+		return nil, err
+	}
+	return cachingWriter{Writer: writer, forget: p.forget}, nil
+}
+
+// tagForgetter is implemented by the resolvers caching the tags
+type tagForgetter interface {
+	forgetTag(ctx context.Context, ref string)
+}
+
+// forgetPushedTag makes the resolver resolve a tag again, after it has been pushed without going through the
+// resolver, like the images pushed from the docker daemon
+func forgetPushedTag(ctx context.Context, resolver interface{}, ref string) {
+	if forgetter, ok := resolver.(tagForgetter); ok {
+		forgetter.forgetTag(ctx, ref)
+	}
+}
+
+// blobCacheFetcher serves the cached blobs, and caches the ones it fetches. Offline, it has no wrapped fetcher.
+type blobCacheFetcher struct {
+	remotes.Fetcher
+	cache *BlobCache
+}
+
+func (f blobCacheFetcher) Fetch(ctx context.Context, desc ocischemav1.Descriptor) (io.ReadCloser, error) {
+	if rc, ok := f.cache.open(desc.Digest); ok {
+		return rc, nil
+	}
+	if f.Fetcher == nil {
+		return nil, fmt.Errorf("%s is not in the cache, it cannot be fetched offline: %w", desc.Digest, errdefs.ErrNotFound)
+	}
+	rc, err := f.Fetcher.Fetch(ctx, desc)
+	if err != nil || desc.Digest.Validate() != nil {
+		return rc, err
+	}
+	temp, err := os.CreateTemp(filepath.Join(f.cache.dir, "blobs"), "blob.*.tmp")
+	if err != nil {
+		log.G(ctx).Warnf("Failed to cache %s: %s", desc.Digest, err)
+		return rc, nil
+	}
+	return &blobCacheWriter{ctx: ctx, ReadCloser: rc, cache: f.cache, desc: desc, temp: temp, digester: desc.Digest.Algorithm().Digester()}, nil
+}
+
+// blobCacheReferrersFetcher keeps the referrers API of the wrapped fetcher
+type blobCacheReferrersFetcher struct {
+	blobCacheFetcher
+	remotes.ReferrersFetcher
+}
+
+// blobCacheWriter copies the content read from a registry into a temporary file, which is moved into the cache once
+// the whole content is read and verified
+type blobCacheWriter struct {
+	io.ReadCloser
+	ctx      context.Context
+	cache    *BlobCache
+	desc     ocischemav1.Descriptor
+	temp     *os.File
+	digester digest.Digester
+	size     int64
+	failed   bool
+}
+
+func (w *blobCacheWriter) Read(p []byte) (int, error) {
+	n, err := w.ReadCloser.Read(p)
+	if n > 0 && !w.failed {
+		if _, werr := w.temp.Write(p[:n]); werr != nil {
+			w.failed = true
+		}
+		_, _ = w.digester.Hash().Write(p[:n])
+		w.size += int64(n)
+	}
+	if err != nil && err != io.EOF {
+		w.failed = true
+	}
+	return n, err
+}
+
+func (w *blobCacheWriter) Close() error {
+	err := w.ReadCloser.Close()
+	complete := !w.failed && w.size == w.desc.Size && w.digester.Digest() == w.desc.Digest
+	if cerr := w.temp.Close(); cerr != nil {
+		complete = false
+	}
+	if !complete {
+		_ = os.Remove(w.temp.Name())
+		return err
+	}
+	if cerr := w.cache.commit(w.temp.Name(), w.desc); cerr != nil {
+		_ = os.Remove(w.temp.Name())
+		log.G(w.ctx).Warnf("Failed to cache %s: %s", w.desc.Digest, cerr)
+	}
+	return err
+}
@@ -0,0 +1,202 @@
+package remotes
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cnabio/cnab-go/bundle"
+	"github.com/containerd/errdefs"
+	"github.com/distribution/reference"
+	"github.com/moby/moby/client"
+	"github.com/opencontainers/go-digest"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"gotest.tools/v3/assert"
+)
+
+func TestBlobCachePullOffline(t *testing.T) {
+	reg := newMemoryRegistry()
+	ref := mustParseNamed(t, "my.registry/namespace/my-app:0.1.0")
+	b, indexDesc := pushTestBundle(t, reg, ref)
+	cache, err := NewBlobCache(t.TempDir())
+	assert.NilError(t, err)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	counting := newCountingResolver(reg)
+	resolver := &blobCacheResolver{Resolver: counting, cache: cache}
+	pulled, _, dgst, err := Pull(context.Background(), ref, resolver)
+	assert.NilError(t, err)
+	assert.Equal(t, dgst, indexDesc.Digest)
+	assert.DeepEqual(t, pulled, b)
+	fetches := len(counting.fetches)
+	assert.Assert(t, fetches > 0)
+
+	// The tag is not resolved again during its TTL, and the content comes from the cache
+	_, _, _, err = Pull(context.Background(), ref, resolver)
+	assert.NilError(t, err)
+	assert.Equal(t, counting.resolves[ref.String()], 1)
+	assert.Equal(t, len(counting.fetches), fetches)
+	for _, n := range counting.fetches {
+		assert.Equal(t, n, 1)
+	}
+	now = now.Add(DefaultBlobCacheTagTTL)
+	_, _, _, err = Pull(context.Background(), ref, resolver)
+	assert.NilError(t, err)
+	assert.Equal(t, counting.resolves[ref.String()], 2)
+
+	// Offline, nothing is sent to the registry, whatever the age of the tag
+	now = now.Add(24 * time.Hour)
+	offline := newCountingResolver(newMemoryRegistry())
+	resolver = &blobCacheResolver{Resolver: offline, cache: cache, offline: true}
+	pulled, _, dgst, err = Pull(context.Background(), ref, resolver)
+	assert.NilError(t, err)
+	assert.Equal(t, dgst, indexDesc.Digest)
+	assert.DeepEqual(t, pulled, b)
+	inspection, err := Inspect(context.Background(), ref, resolver)
+	assert.NilError(t, err)
+	assert.Equal(t, inspection.IndexDescriptor.Digest, indexDesc.Digest)
+	assert.Equal(t, len(offline.resolves), 0)
+	assert.Equal(t, len(offline.fetches), 0)
+
+	_, _, _, err = Pull(context.Background(), mustParseNamed(t, "my.registry/namespace/my-app:0.2.0"), resolver)
+	assert.ErrorContains(t, err, "my.registry/namespace/my-app:0.2.0 is not in the cache, it cannot be resolved offline")
+	fetcher, err := resolver.Fetcher(context.Background(), ref.Name())
+	assert.NilError(t, err)
+	_, err = fetcher.Fetch(context.Background(), ocischemav1.Descriptor{Digest: digest.FromString("missing")})
+	assert.Assert(t, errdefs.IsNotFound(err))
+	assert.ErrorContains(t, err, "it cannot be fetched offline")
+	_, err = resolver.Pusher(context.Background(), ref.String())
+	assert.ErrorContains(t, err, "cannot push my.registry/namespace/my-app:0.1.0 offline")
+}
+
+func TestBlobCacheEviction(t *testing.T) {
+	reg := newMemoryRegistry()
+	var descs []ocischemav1.Descriptor
+	for _, content := range []string{"first blob", "second blob", "third blob"} {
+		desc := ocischemav1.Descriptor{MediaType: ocischemav1.MediaTypeImageLayer, Digest: digest.FromString(content), Size: int64(len(content))}
+		reg.put("docker.io/library/app", desc, []byte(content), "")
+		descs = append(descs, desc)
+	}
+	dir := t.TempDir()
+	cache, err := NewBlobCache(dir, WithBlobCacheMaxSize(25))
+	assert.NilError(t, err)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	resolver := &blobCacheResolver{Resolver: reg, cache: cache}
+	fetcher, err := resolver.Fetcher(context.Background(), "docker.io/library/app")
+	assert.NilError(t, err)
+	fetch := func(desc ocischemav1.Descriptor) {
+		t.Helper()
+		rc, err := fetcher.Fetch(context.Background(), desc)
+		assert.NilError(t, err)
+		_, err = io.ReadAll(rc)
+		assert.NilError(t, err)
+		assert.NilError(t, rc.Close())
+		now = now.Add(time.Second)
+	}
+	fetch(descs[0])
+	fetch(descs[1])
+	fetch(descs[0])
+	// The second blob is the least recently used one
+	fetch(descs[2])
+	_, err = os.Stat(cache.blobPath(descs[1].Digest))
+	assert.Assert(t, os.IsNotExist(err))
+	_, err = os.Stat(cache.descriptorPath(descs[1].Digest))
+	assert.Assert(t, os.IsNotExist(err))
+	for _, desc := range []ocischemav1.Descriptor{descs[0], descs[2]} {
+		_, ok := cache.descriptor(desc.Digest)
+		assert.Assert(t, ok, desc.Digest)
+	}
+
+	// A blob not matching its descriptor is not cached
+	corrupted := ocischemav1.Descriptor{MediaType: ocischemav1.MediaTypeImageLayer, Digest: digest.FromString("expected"), Size: 8}
+	reg.put("docker.io/library/app", corrupted, []byte("received"), "")
+	fetch(corrupted)
+	_, err = os.Stat(cache.blobPath(corrupted.Digest))
+	assert.Assert(t, os.IsNotExist(err))
+	temps, err := filepath.Glob(filepath.Join(dir, "blobs", "*.tmp"))
+	assert.NilError(t, err)
+	assert.Equal(t, len(temps), 0)
+
+	_, err = NewBlobCache(dir, WithBlobCacheMaxSize(0))
+	assert.ErrorContains(t, err, "invalid blob cache size 0")
+}
+
+func TestBlobCacheForgetsPushedTags(t *testing.T) {
+	reg := newMemoryRegistry()
+	cache, err := NewBlobCache(t.TempDir())
+	assert.NilError(t, err)
+	resolver := newCachingResolver(&blobCacheResolver{Resolver: reg, cache: cache})
+	ctx := context.Background()
+	ref := "my.registry/namespace/my-app:latest"
+
+	for _, manifest := range [][]byte{[]byte(`{"schemaVersion":2,"layers":[]}`), []byte(`{"schemaVersion":2,"annotations":{"v":"2"}}`)} {
+		desc := ocischemav1.Descriptor{MediaType: ocischemav1.MediaTypeImageManifest, Digest: digest.FromBytes(manifest), Size: int64(len(manifest))}
+		assert.NilError(t, pushPayload(ctx, resolver, ref, desc, manifest))
+		_, resolved, err := resolver.Resolve(ctx, ref)
+		assert.NilError(t, err)
+		assert.Equal(t, resolved.Digest, desc.Digest)
+	}
+}
+
+// daemonImageClient pushes the images tagged in the mock daemon to the registry
+type daemonImageClient struct {
+	*mockImageClient
+	reg    *memoryRegistry
+	images map[string]ocischemav1.Descriptor
+	tagged ocischemav1.Descriptor
+}
+
+func (c *daemonImageClient) ImageTag(ctx context.Context, options client.ImageTagOptions) (client.ImageTagResult, error) {
+	c.tagged = c.images[options.Source]
+	return c.mockImageClient.ImageTag(ctx, options)
+}
+
+func (c *daemonImageClient) ImagePush(ctx context.Context, ref string, options client.ImagePushOptions) (client.ImagePushResponse, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return nil, err
+	}
+	c.reg.mut.Lock()
+	c.reg.repository(named.Name()).tags[reference.TagNameOnly(named).(reference.Tagged).Tag()] = c.tagged.Digest
+	c.reg.mut.Unlock()
+	return c.mockImageClient.ImagePush(ctx, ref, options)
+}
+
+func TestBlobCacheFixupPushesImagesFromDaemon(t *testing.T) {
+	reg := newMemoryRegistry()
+	invocation := pushTestImage(t, reg, "my.registry/namespace/my-app", "my-app-invoc")
+	service := pushTestImage(t, reg, "my.registry/namespace/my-app", "my-service")
+	cache, err := NewBlobCache(t.TempDir())
+	assert.NilError(t, err)
+	resolver := &blobCacheResolver{Resolver: reg, cache: cache}
+	imageClient := &daemonImageClient{
+		mockImageClient: newMockImageClient(),
+		reg:             reg,
+		images:          map[string]ocischemav1.Descriptor{"my-app-invoc:local": invocation, "my-service:local": service},
+	}
+	b := &bundle.Bundle{
+		SchemaVersion: "v1.0.0",
+		Name:          "my-app",
+		Version:       "0.1.0",
+		InvocationImages: []bundle.InvocationImage{
+			{BaseImage: bundle.BaseImage{Image: "my-app-invoc:local", ImageType: "docker"}},
+		},
+		Images: map[string]bundle.Image{
+			"my-service": {BaseImage: bundle.BaseImage{Image: "my-service:local", ImageType: "docker"}},
+		},
+	}
+
+	// Both images are pushed through the same tag, which is resolved again after each push
+	relocationMap, err := FixupBundle(context.Background(), b, mustParseNamed(t, "my.registry/namespace/my-app"), resolver,
+		WithAutoBundleUpdate(), WithPushImages(imageClient, nil))
+	assert.NilError(t, err)
+	assert.Equal(t, imageClient.pushedImages, 2)
+	assert.Equal(t, b.InvocationImages[0].Digest, invocation.Digest.String())
+	assert.Equal(t, b.Images["my-service"].Digest, service.Digest.String())
+	assert.Equal(t, relocationMap["my-service:local"], "my.registry/namespace/my-app@"+service.Digest.String())
+}
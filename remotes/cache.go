@@ -0,0 +1,204 @@
+package remotes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/containerd/errdefs"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// maxCachedManifestSize is the size of the largest manifest body kept in the cache
+const maxCachedManifestSize = 1 << 20
+
+// cachingResolver remembers, for a single push, fixup or pull, the descriptors resolved by digest, the digests not
+// found, and the small manifest bodies, as the same manifests are resolved and fetched several times while walking
+// the images. The tags are always resolved, as they are moved by the pushes.
+type cachingResolver struct {
+	remotes.Resolver
+	mu        sync.Mutex
+	resolved  map[string]resolvedDescriptor
+	notFound  map[string]digest.Digest
+	manifests map[digest.Digest][]byte
+}
+
+type resolvedDescriptor struct {
+	name       string
+	descriptor ocischemav1.Descriptor
+}
+
+// newCachingResolver wraps the resolver with a new cache, unless it already is a caching resolver
+func newCachingResolver(resolver remotes.Resolver) remotes.Resolver {
+	if _, ok := resolver.(*cachingResolver); ok {
+		return resolver
+	}
+	return &cachingResolver{
+		Resolver:  resolver,
+		resolved:  map[string]resolvedDescriptor{},
+		notFound:  map[string]digest.Digest{},
+		manifests: map[digest.Digest][]byte{},
+	}
+}
+
+func (r *cachingResolver) Resolve(ctx context.Context, ref string) (string, ocischemav1.Descriptor, error) {
+	dgst, ok := referenceDigest(ref)
+	if !ok {
+		return r.Resolver.Resolve(ctx, ref)
+	}
+	r.mu.Lock()
+	resolved, isResolved := r.resolved[ref]
+	_, isNotFound := r.notFound[ref]
+	r.mu.Unlock()
+	switch {
+	case isResolved:
+		return resolved.name, resolved.descriptor, nil
+	case isNotFound:
+		return "", ocischemav1.Descriptor{}, errdefs.ErrNotFound.WithMessage(ref + " not found")
+	}
+
+	name, desc, err := r.Resolver.Resolve(ctx, ref)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case err == nil:
+		r.resolved[ref] = resolvedDescriptor{name: name, descriptor: desc}
+	case errors.Is(err, errdefs.ErrNotFound):
+		r.notFound[ref] = dgst
+	}
+	return name, desc, err
+}
+
+func (r *cachingResolver) Fetcher(ctx context.Context, ref string) (remotes.Fetcher, error) {
+	fetcher, err := r.Resolver.Fetcher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	result := cachingFetcher{Fetcher: fetcher, resolver: r}
+	if referrersFetcher, ok := fetcher.(remotes.ReferrersFetcher); ok {
+		return cachingReferrersFetcher{cachingFetcher: result, ReferrersFetcher: referrersFetcher}, nil
+	}
+	return result, nil
+}
+
+func (r *cachingResolver) Pusher(ctx context.Context, ref string) (remotes.Pusher, error) {
+	pusher, err := r.Resolver.Pusher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return cachingPusher{Pusher: pusher, resolver: r}, nil
+}
+
+// credentialProvider keeps the credentials of the wrapped resolver for the images pushed from the docker daemon
+func (r *cachingResolver) credentialProvider() CredentialProvider {
+	return defaultCredentials(r.Resolver)
+}
+
+// forgetTag forgets the tags cached by the wrapped resolver
+func (r *cachingResolver) forgetTag(ctx context.Context, ref string) {
+	forgetPushedTag(ctx, r.Resolver, ref)
+}
+
+// forgetNotFound forgets the lookups of a digest which is being pushed, through the cache or from the docker daemon
+func (r *cachingResolver) forgetNotFound(dgst digest.Digest) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for ref, d := range r.notFound {
+		if d == dgst {
+			delete(r.notFound, ref)
+		}
+	}
+}
+
+func (r *cachingResolver) manifest(dgst digest.Digest) ([]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, ok := r.manifests[dgst]
+	return data, ok
+}
+
+func (r *cachingResolver) addManifest(dgst digest.Digest, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.manifests[dgst] = data
+}
+
+// referenceDigest returns the digest of a digested reference
+func referenceDigest(ref string) (digest.Digest, bool) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", false
+	}
+	digested, ok := named.(reference.Digested)
+	if !ok {
+		return "", false
+	}
+	return digested.Digest(), true
+}
+
+// cachingFetcher serves the small manifests from the cache of the resolver
+type cachingFetcher struct {
+	remotes.Fetcher
+	resolver *cachingResolver
+}
+
+func (f cachingFetcher) Fetch(ctx context.Context, desc ocischemav1.Descriptor) (io.ReadCloser, error) {
+	if !isManifest(desc.MediaType) || desc.Size > maxCachedManifestSize {
+		return f.Fetcher.Fetch(ctx, desc)
+	}
+	if data, ok := f.resolver.manifest(desc.Digest); ok {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	rc, err := f.Fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxCachedManifestSize+1))
+	if err != nil {
+		return nil, err
+	}
+	// Only the verified bodies are cached, the other ones are left to the verification of the caller
+	if int64(len(data)) == desc.Size && desc.Digest.Validate() == nil && desc.Digest.Algorithm().FromBytes(data) == desc.Digest {
+		f.resolver.addManifest(desc.Digest, data)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// cachingReferrersFetcher keeps the referrers API of the wrapped fetcher
+type cachingReferrersFetcher struct {
+	cachingFetcher
+	remotes.ReferrersFetcher
+}
+
+// cachingPusher forgets the digests not found once they are pushed
+type cachingPusher struct {
+	remotes.Pusher
+	resolver *cachingResolver
+}
+
+func (p cachingPusher) Push(ctx context.Context, desc ocischemav1.Descriptor) (content.Writer, error) {
+	p.resolver.forgetNotFound(desc.Digest)
+	writer, err := p.Pusher.Push(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	return cachingWriter{Writer: writer, forget: func() { p.resolver.forgetNotFound(desc.Digest) }}, nil
+}
+
+type cachingWriter struct {
+	content.Writer
+	forget func()
+}
+
+func (w cachingWriter) Commit(ctx context.Context, size int64, expected digest.Digest, opts ...content.Opt) error {
+	err := w.Writer.Commit(ctx, size, expected, opts...)
+	w.forget()
+	return err
+}
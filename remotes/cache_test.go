@@ -0,0 +1,118 @@
+package remotes
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"gotest.tools/v3/assert"
+)
+
+// countingResolver counts the resolves and the fetches sent to the wrapped resolver
+type countingResolver struct {
+	remotes.Resolver
+	mu       sync.Mutex
+	resolves map[string]int
+	fetches  map[digest.Digest]int
+}
+
+func newCountingResolver(resolver remotes.Resolver) *countingResolver {
+	return &countingResolver{Resolver: resolver, resolves: map[string]int{}, fetches: map[digest.Digest]int{}}
+}
+
+func (r *countingResolver) Resolve(ctx context.Context, ref string) (string, ocischemav1.Descriptor, error) {
+	r.mu.Lock()
+	r.resolves[ref]++
+	r.mu.Unlock()
+	return r.Resolver.Resolve(ctx, ref)
+}
+
+func (r *countingResolver) Fetcher(ctx context.Context, ref string) (remotes.Fetcher, error) {
+	fetcher, err := r.Resolver.Fetcher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return remotes.FetcherFunc(func(ctx context.Context, desc ocischemav1.Descriptor) (io.ReadCloser, error) {
+		r.mu.Lock()
+		r.fetches[desc.Digest]++
+		r.mu.Unlock()
+		return fetcher.Fetch(ctx, desc)
+	}), nil
+}
+
+func TestCachingResolver(t *testing.T) {
+	registry := newMemoryRegistry()
+	manifest := []byte(`{"schemaVersion":2}`)
+	manifestDesc := ocischemav1.Descriptor{MediaType: ocischemav1.MediaTypeImageManifest, Digest: digest.FromBytes(manifest), Size: int64(len(manifest))}
+	registry.put("docker.io/library/app", manifestDesc, manifest, "latest")
+	layer := []byte("layer")
+	layerDesc := ocischemav1.Descriptor{MediaType: ocischemav1.MediaTypeImageLayer, Digest: digest.FromBytes(layer), Size: int64(len(layer))}
+	registry.put("docker.io/library/app", layerDesc, layer, "")
+	counting := newCountingResolver(registry)
+	resolver := newCachingResolver(counting)
+	assert.Equal(t, newCachingResolver(resolver), resolver)
+	ctx := context.Background()
+
+	// The digests are resolved once, the tags each time
+	digested := "docker.io/library/app@" + manifestDesc.Digest.String()
+	for i := 0; i < 2; i++ {
+		_, desc, err := resolver.Resolve(ctx, digested)
+		assert.NilError(t, err)
+		assert.Equal(t, desc.Digest, manifestDesc.Digest)
+		_, _, err = resolver.Resolve(ctx, "docker.io/library/app:latest")
+		assert.NilError(t, err)
+	}
+	assert.Equal(t, counting.resolves[digested], 1)
+	assert.Equal(t, counting.resolves["docker.io/library/app:latest"], 2)
+
+	// The manifests are fetched once, the layers each time
+	for i := 0; i < 2; i++ {
+		fetcher, err := resolver.Fetcher(ctx, "docker.io/library/app")
+		assert.NilError(t, err)
+		for _, desc := range []ocischemav1.Descriptor{manifestDesc, layerDesc} {
+			rc, err := fetcher.Fetch(ctx, desc)
+			assert.NilError(t, err)
+			data, err := io.ReadAll(rc)
+			assert.NilError(t, err)
+			assert.NilError(t, rc.Close())
+			assert.Equal(t, digest.FromBytes(data), desc.Digest)
+		}
+	}
+	assert.Equal(t, counting.fetches[manifestDesc.Digest], 1)
+	assert.Equal(t, counting.fetches[layerDesc.Digest], 2)
+
+	// The digests not found are remembered until they are pushed
+	other := []byte(`{"schemaVersion":2,"layers":[]}`)
+	otherDesc := ocischemav1.Descriptor{MediaType: ocischemav1.MediaTypeImageManifest, Digest: digest.FromBytes(other), Size: int64(len(other))}
+	otherRef := "docker.io/library/app@" + otherDesc.Digest.String()
+	for i := 0; i < 2; i++ {
+		_, _, err := resolver.Resolve(ctx, otherRef)
+		assert.Assert(t, errdefs.IsNotFound(err), "unexpected error %v", err)
+	}
+	assert.Equal(t, counting.resolves[otherRef], 1)
+	assert.NilError(t, pushPayload(ctx, resolver, "docker.io/library/app", otherDesc, other))
+	_, desc, err := resolver.Resolve(ctx, otherRef)
+	assert.NilError(t, err)
+	assert.Equal(t, desc.Digest, otherDesc.Digest)
+	assert.Equal(t, counting.resolves[otherRef], 2)
+}
+
+func TestCachingResolverReferrersFetcher(t *testing.T) {
+	registry := newMemoryRegistry()
+	resolver := newCachingResolver(registry)
+	fetcher, err := resolver.Fetcher(context.Background(), "docker.io/library/app")
+	assert.NilError(t, err)
+	_, ok := fetcher.(remotes.ReferrersFetcher)
+	assert.Assert(t, !ok)
+
+	registry.referrersAPI = true
+	fetcher, err = resolver.Fetcher(context.Background(), "docker.io/library/app")
+	assert.NilError(t, err)
+	_, ok = fetcher.(remotes.ReferrersFetcher)
+	assert.Assert(t, ok)
+}
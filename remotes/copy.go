@@ -0,0 +1,171 @@
+package remotes
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/containerd/log"
+	"github.com/distribution/reference"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// copyConfig defines the input required for a Copy operation
+type copyConfig struct {
+	eventCallback     func(FixupEvent)
+	maxConcurrentJobs int
+	allowFallbacks    bool
+	retryPolicy       RetryPolicy
+	fallbackCallback  func(fallback string)
+}
+
+// CopyOption is a helper for configuring a Copy
+type CopyOption func(*copyConfig) error
+
+func newCopyConfig(options ...CopyOption) (copyConfig, error) {
+	cfg := copyConfig{
+		eventCallback:     noopEventCallback,
+		maxConcurrentJobs: defaultMaxConcurrentJobs,
+		fallbackCallback:  func(string) {},
+	}
+	for _, opt := range options {
+		if err := opt(&cfg); err != nil {
+			return copyConfig{}, err
+		}
+	}
+	return cfg, nil
+}
+
+// WithCopyEventCallback specifies a callback to execute for each Copy event
+func WithCopyEventCallback(callback func(FixupEvent)) CopyOption {
+	return func(cfg *copyConfig) error {
+		cfg.eventCallback = callback
+		return nil
+	}
+}
+
+// WithCopyParallelism changes the max concurrent jobs used to copy the content of each manifest
+func WithCopyParallelism(maxConcurrentJobs int) CopyOption {
+	return func(cfg *copyConfig) error {
+		cfg.maxConcurrentJobs = maxConcurrentJobs
+		return nil
+	}
+}
+
+// WithCopyFallbacks allows the bundle index to be pushed as a Docker manifest list if the destination registry
+// rejects the OCI index. The index digest changes in that case.
+func WithCopyFallbacks() CopyOption {
+	return func(cfg *copyConfig) error {
+		cfg.allowFallbacks = true
+		return nil
+	}
+}
+
+// WithCopyFallbackCallback specifies a callback called with a description of the compatibility fallback used, if any,
+// while pushing the bundle index
+func WithCopyFallbackCallback(callback func(fallback string)) CopyOption {
+	return func(cfg *copyConfig) error {
+		cfg.fallbackCallback = callback
+		return nil
+	}
+}
+
+// WithCopyRetryPolicy retries the registry operations failing with a transient error, like a 502, a 429 or a
+// connection reset, according to the given policy
+func WithCopyRetryPolicy(policy RetryPolicy) CopyOption {
+	return func(cfg *copyConfig) error {
+		cfg.retryPolicy = policy
+		return nil
+	}
+}
+
+// Copy copies a bundle already pushed to a registry to another repository, possibly in another registry.
+// The config manifest, the config blob and all the invocation and component images are copied, or mounted when
+// the source and destination repositories are on the same registry. The bundle index is then pushed unchanged, so
+// the returned descriptor has the same digest as the source one.
+func Copy(ctx context.Context, srcRef, dstRef reference.Named, resolver remotes.Resolver, opts ...CopyOption) (ocischemav1.Descriptor, error) {
+	logger := log.G(ctx)
+	logger.Debugf("Copying CNAB Bundle %s to %s", srcRef, dstRef)
+
+	cfg, err := newCopyConfig(opts...)
+	if err != nil {
+		return ocischemav1.Descriptor{}, err
+	}
+	ctx = withRetryPolicy(ctx, cfg.retryPolicy)
+	index, indexDescriptor, indexPayload, err := getIndexWithPayload(ctx, srcRef, resolver)
+	if err != nil {
+		return ocischemav1.Descriptor{}, err
+	}
+	srcRepo, err := reference.ParseNormalizedNamed(srcRef.Name())
+	if err != nil {
+		return ocischemav1.Descriptor{}, err
+	}
+	dstRepo, err := reference.ParseNormalizedNamed(dstRef.Name())
+	if err != nil {
+		return ocischemav1.Descriptor{}, err
+	}
+
+	events, stopEventLoop := startEventLoop(cfg.eventCallback)
+	defer stopEventLoop()
+
+	sourceFetcher, err := resolver.Fetcher(ctx, srcRepo.Name())
+	if err != nil {
+		return ocischemav1.Descriptor{}, err
+	}
+	for _, d := range index.Manifests {
+		if err := copyDescriptorTree(ctx, resolver, sourceFetcher, srcRepo, dstRepo, d, cfg, events); err != nil {
+			return ocischemav1.Descriptor{}, err
+		}
+	}
+
+	logger.Debug("Pushing CNAB Index")
+	if err := pushPayload(ctx, resolver, dstRef.String(), indexDescriptor, indexPayload); err != nil {
+		if !cfg.allowFallbacks || indexDescriptor.MediaType != ocischemav1.MediaTypeImageIndex {
+			return ocischemav1.Descriptor{}, err
+		}
+		logger.Debugf("Unable to push OCI Index: %v", err)
+		cfg.fallbackCallback(fmt.Sprintf("index pushed as %s instead of %s", images.MediaTypeDockerSchema2ManifestList, ocischemav1.MediaTypeImageIndex))
+		return pushCopiedIndexAsManifestList(ctx, resolver, dstRef, index)
+	}
+
+	logger.Debug("CNAB Bundle copied")
+	return indexDescriptor, nil
+}
+
+func copyDescriptorTree(ctx context.Context, resolver remotes.Resolver, sourceFetcher remotes.Fetcher,
+	srcRepo, dstRepo reference.Named, desc ocischemav1.Descriptor, cfg copyConfig, events chan<- FixupEvent) error {
+	sourceImage := fmt.Sprintf("%s@%s", srcRepo.Name(), desc.Digest)
+	notifyEvent, progress := makeEventNotifier(events, sourceImage, dstRepo)
+	notifyEvent(FixupEventTypeCopyImageStart, "", nil)
+
+	copier, err := newDescriptorCopier(ctx, resolver, sourceFetcher, dstRepo.String(), notifyEvent, srcRepo)
+	if err != nil {
+		return notifyError(notifyEvent, err)
+	}
+	descriptorContentHandler := &descriptorContentHandler{
+		descriptorCopier: copier,
+		targetRepo:       dstRepo.String(),
+	}
+	walker := newManifestWalker(notifyEvent, progress, descriptorContentHandler, cfg.maxConcurrentJobs)
+	if err := walker.walk(withMutedContext(ctx), desc); err != nil {
+		return notifyError(notifyEvent, err)
+	}
+
+	notifyEvent(FixupEventTypeCopyImageEnd, "", nil)
+	return nil
+}
+
+func pushCopiedIndexAsManifestList(ctx context.Context, resolver remotes.Resolver, dstRef reference.Named, index ocischemav1.Index) (ocischemav1.Descriptor, error) {
+	logger := log.G(ctx)
+	indexDescriptor, indexPayload, err := marshalNonOCIIndex(&index)
+	if err != nil {
+		return ocischemav1.Descriptor{}, fmt.Errorf("invalid bundle manifest %q: %s", dstRef, err)
+	}
+	logger.Debug("Trying to push Index with Manifest list as fallback")
+	logger.Debug(string(indexPayload))
+	if err := pushPayload(ctx, resolver, dstRef.String(), indexDescriptor, indexPayload); err != nil {
+		return ocischemav1.Descriptor{}, err
+	}
+	return indexDescriptor, nil
+}
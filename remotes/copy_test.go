@@ -0,0 +1,154 @@
+package remotes
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/cnabio/cnab-go/bundle"
+	"github.com/cnabio/cnab-to-oci/relocation"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocischema "github.com/opencontainers/image-spec/specs-go"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"gotest.tools/v3/assert"
+)
+
+func TestCopy(t *testing.T) {
+	reg := newMemoryRegistry()
+	srcRef := mustParseNamed(t, "my.registry/build/my-app:0.1.0")
+	b, pushedDescriptor := pushTestBundle(t, reg, srcRef)
+
+	dstRef := mustParseNamed(t, "my.registry/production/my-app:0.1.0")
+	var events []FixupEvent
+	copiedDescriptor, err := Copy(context.Background(), srcRef, dstRef, reg, WithCopyEventCallback(func(ev FixupEvent) {
+		events = append(events, ev)
+	}))
+	assert.NilError(t, err)
+
+	// The index is pushed unchanged
+	assert.DeepEqual(t, copiedDescriptor, pushedDescriptor)
+
+	// The copied bundle can be pulled from the destination, with images relocated to the destination repository
+	pulled, relocationMap, pulledDigest, err := Pull(context.Background(), dstRef, reg)
+	assert.NilError(t, err)
+	assert.Equal(t, pulledDigest, pushedDescriptor.Digest)
+	assert.DeepEqual(t, pulled, b)
+	assert.DeepEqual(t, relocationMap, relocation.ImageRelocationMap{
+		"my.registry/build/my-app-invoc": "my.registry/production/my-app@" + b.InvocationImages[0].Digest,
+		"my.registry/build/my-service":   "my.registry/production/my-app@" + b.Images["my-service"].Digest,
+	})
+
+	// Blobs have been mounted from the source repository, manifests are copied
+	layer := digest.FromString("my-service layer")
+	_, mounted := reg.repository("my.registry/production/my-app").mounted[layer]
+	assert.Assert(t, mounted)
+
+	// One start and one end event per index descriptor
+	var starts, ends int
+	for _, ev := range events {
+		switch ev.EventType {
+		case FixupEventTypeCopyImageStart:
+			starts++
+		case FixupEventTypeCopyImageEnd:
+			assert.NilError(t, ev.Error)
+			ends++
+		}
+	}
+	assert.Equal(t, starts, 3)
+	assert.Equal(t, ends, 3)
+
+	// Copying again is a no-op
+	copiedDescriptor, err = Copy(context.Background(), srcRef, dstRef, reg)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, copiedDescriptor, pushedDescriptor)
+}
+
+func TestCopyMissingSource(t *testing.T) {
+	reg := newMemoryRegistry()
+	_, err := Copy(context.Background(), mustParseNamed(t, "my.registry/build/my-app:0.1.0"), mustParseNamed(t, "my.registry/production/my-app:0.1.0"), reg)
+	assert.ErrorContains(t, err, "not found")
+}
+
+func mustParseNamed(t *testing.T, ref string) reference.Named {
+	t.Helper()
+	named, err := reference.ParseNormalizedNamed(ref)
+	assert.NilError(t, err)
+	return named
+}
+
+// pushTestImage stores a single layer image manifest in the registry and returns its descriptor
+func pushTestImage(t *testing.T, reg *memoryRegistry, repo string, name string) ocischemav1.Descriptor {
+	t.Helper()
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+	layer := []byte(name + " layer")
+	manifest := ocischemav1.Manifest{
+		Versioned: ocischema.Versioned{SchemaVersion: 2},
+		MediaType: ocischemav1.MediaTypeImageManifest,
+		Config: ocischemav1.Descriptor{
+			MediaType: ocischemav1.MediaTypeImageConfig,
+			Digest:    digest.FromBytes(config),
+			Size:      int64(len(config)),
+		},
+		Layers: []ocischemav1.Descriptor{
+			{
+				MediaType: ocischemav1.MediaTypeImageLayerGzip,
+				Digest:    digest.FromBytes(layer),
+				Size:      int64(len(layer)),
+			},
+		},
+	}
+	manifestBytes, err := json.Marshal(manifest)
+	assert.NilError(t, err)
+	desc := ocischemav1.Descriptor{
+		MediaType: ocischemav1.MediaTypeImageManifest,
+		Digest:    digest.FromBytes(manifestBytes),
+		Size:      int64(len(manifestBytes)),
+	}
+	reg.put(repo, manifest.Config, config, "")
+	reg.put(repo, manifest.Layers[0], layer, "")
+	reg.put(repo, desc, manifestBytes, "")
+	return desc
+}
+
+// pushTestBundle stores a bundle with one invocation image and one component image in the registry
+func pushTestBundle(t *testing.T, reg *memoryRegistry, ref reference.Named) (*bundle.Bundle, ocischemav1.Descriptor) {
+	t.Helper()
+	invocation := pushTestImage(t, reg, ref.Name(), "my-app-invoc")
+	service := pushTestImage(t, reg, ref.Name(), "my-service")
+	domainAndNamespace := ref.Name()[:len(ref.Name())-len("my-app")]
+	b := &bundle.Bundle{
+		SchemaVersion: "v1.0.0",
+		Name:          "my-app",
+		Version:       "0.1.0",
+		InvocationImages: []bundle.InvocationImage{
+			{
+				BaseImage: bundle.BaseImage{
+					Image:     domainAndNamespace + "my-app-invoc",
+					ImageType: "oci",
+					MediaType: invocation.MediaType,
+					Digest:    invocation.Digest.String(),
+					Size:      uint64(invocation.Size),
+				},
+			},
+		},
+		Images: map[string]bundle.Image{
+			"my-service": {
+				BaseImage: bundle.BaseImage{
+					Image:     domainAndNamespace + "my-service",
+					ImageType: "oci",
+					MediaType: service.MediaType,
+					Digest:    service.Digest.String(),
+					Size:      uint64(service.Size),
+				},
+			},
+		},
+	}
+	relocationMap := relocation.ImageRelocationMap{
+		b.InvocationImages[0].Image:  ref.Name() + "@" + invocation.Digest.String(),
+		b.Images["my-service"].Image: ref.Name() + "@" + service.Digest.String(),
+	}
+	desc, err := Push(context.Background(), b, relocationMap, ref, reg, false)
+	assert.NilError(t, err)
+	return b, desc
+}
@@ -0,0 +1,190 @@
+package remotes
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/configfile"
+	"go.yaml.in/yaml/v2"
+)
+
+const (
+	// EnvUsernamePrefix prefixes the environment variables holding the registry user names, like
+	// CNAB_TO_OCI_USERNAME_MY_REGISTRY_COM for the my.registry.com registry
+	EnvUsernamePrefix = "CNAB_TO_OCI_USERNAME_"
+	// EnvPasswordPrefix prefixes the environment variables holding the registry passwords
+	EnvPasswordPrefix = "CNAB_TO_OCI_PASSWORD_"
+	// EnvTokenPrefix prefixes the environment variables holding the registry identity tokens
+	EnvTokenPrefix = "CNAB_TO_OCI_TOKEN_"
+
+	kubernetesDockerConfigJSONKey  = ".dockerconfigjson"
+	kubernetesDockerConfigJSONType = "kubernetes.io/dockerconfigjson"
+)
+
+// Credentials are the credentials of a registry. Empty credentials give an anonymous access.
+type Credentials struct {
+	Username string
+	Password string
+	// IdentityToken is exchanged for the registry access tokens, instead of the user name and password
+	IdentityToken string
+}
+
+func (c Credentials) empty() bool {
+	return c == Credentials{}
+}
+
+// CredentialProvider provides the credentials of the registries, for the resolver and the images pushed from the
+// docker daemon
+type CredentialProvider interface {
+	// Credentials returns the credentials of a registry host name, like "my.registry:5000". Docker Hub is always
+	// named "docker.io".
+	Credentials(ctx context.Context, host string) (Credentials, error)
+}
+
+// CredentialProviderFunc is a function used as a CredentialProvider
+type CredentialProviderFunc func(ctx context.Context, host string) (Credentials, error)
+
+// Credentials calls the function
+func (f CredentialProviderFunc) Credentials(ctx context.Context, host string) (Credentials, error) {
+	return f(ctx, host)
+}
+
+// DockerConfigCredentials provides the credentials of a docker CLI configuration, including its credential helpers
+func DockerConfigCredentials(cfg *configfile.ConfigFile) CredentialProvider {
+	return CredentialProviderFunc(func(_ context.Context, host string) (Credentials, error) {
+		a, err := cfg.GetAuthConfig(host)
+		if err != nil {
+			return Credentials{}, err
+		}
+		return Credentials{Username: a.Username, Password: a.Password, IdentityToken: a.IdentityToken}, nil
+	})
+}
+
+// NewDockerConfigFileCredentials provides the credentials of the docker CLI configuration file at the given path,
+// instead of the default one. A mounted Kubernetes dockerconfigjson secret key is such a file.
+func NewDockerConfigFileCredentials(file string) (CredentialProvider, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := config.LoadFromReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid docker configuration file %q: %s", file, err)
+	}
+	return DockerConfigCredentials(cfg), nil
+}
+
+// kubernetesSecret is the part of a Kubernetes secret manifest holding a docker configuration
+type kubernetesSecret struct {
+	Kind string            `yaml:"kind"`
+	Type string            `yaml:"type"`
+	Data map[string]string `yaml:"data"`
+}
+
+// NewKubernetesSecretCredentials provides the credentials of a kubernetes.io/dockerconfigjson secret manifest, in
+// YAML or JSON
+func NewKubernetesSecretCredentials(file string) (CredentialProvider, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var secret kubernetesSecret
+	if err := yaml.Unmarshal(data, &secret); err != nil {
+		return nil, fmt.Errorf("invalid Kubernetes secret %q: %s", file, err)
+	}
+	encoded, ok := secret.Data[kubernetesDockerConfigJSONKey]
+	if secret.Kind != "Secret" || !ok {
+		return nil, fmt.Errorf("%q is not a Kubernetes secret with a %s key", file, kubernetesDockerConfigJSONKey)
+	}
+	if secret.Type != "" && secret.Type != kubernetesDockerConfigJSONType {
+		return nil, fmt.Errorf("invalid Kubernetes secret %q: type %q instead of %q", file, secret.Type, kubernetesDockerConfigJSONType)
+	}
+	dockerConfig, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s key of Kubernetes secret %q: %s", kubernetesDockerConfigJSONKey, file, err)
+	}
+	cfg, err := config.LoadFromReader(bytes.NewReader(dockerConfig))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s key of Kubernetes secret %q: %s", kubernetesDockerConfigJSONKey, file, err)
+	}
+	return DockerConfigCredentials(cfg), nil
+}
+
+// EnvCredentials provides the credentials of the CNAB_TO_OCI_USERNAME_<HOST>, CNAB_TO_OCI_PASSWORD_<HOST> and
+// CNAB_TO_OCI_TOKEN_<HOST> environment variables. <HOST> is the registry host name in upper case, with all the
+// characters other than letters and digits replaced by underscores, like DOCKER_IO or MY_REGISTRY_5000.
+func EnvCredentials() CredentialProvider {
+	return CredentialProviderFunc(func(_ context.Context, host string) (Credentials, error) {
+		suffix := envCredentialsSuffix(host)
+		return Credentials{
+			Username:      os.Getenv(EnvUsernamePrefix + suffix),
+			Password:      os.Getenv(EnvPasswordPrefix + suffix),
+			IdentityToken: os.Getenv(EnvTokenPrefix + suffix),
+		}, nil
+	})
+}
+
+func envCredentialsSuffix(host string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, host)
+}
+
+// StaticCredentials provides fixed credentials, keyed by registry host name
+func StaticCredentials(credentials map[string]Credentials) CredentialProvider {
+	return CredentialProviderFunc(func(_ context.Context, host string) (Credentials, error) {
+		return credentials[host], nil
+	})
+}
+
+// ChainCredentials returns the first non empty credentials given by the providers
+func ChainCredentials(providers ...CredentialProvider) CredentialProvider {
+	return CredentialProviderFunc(func(ctx context.Context, host string) (Credentials, error) {
+		for _, provider := range providers {
+			credentials, err := provider.Credentials(ctx, host)
+			if err != nil {
+				return Credentials{}, err
+			}
+			if !credentials.empty() {
+				return credentials, nil
+			}
+		}
+		return Credentials{}, nil
+	})
+}
+
+// registryCredentials returns the credentials of a registry host, Docker Hub hosts being all named "docker.io"
+func registryCredentials(ctx context.Context, provider CredentialProvider, host string) (Credentials, error) {
+	if host == legacyDefaultDomain || host == defaultRegistryHost {
+		host = defaultDomain
+	}
+	return provider.Credentials(ctx, host)
+}
+
+// credentialSource is implemented by the resolvers created by CreateResolver, so the images pushed from the docker
+// daemon use the same credentials as the resolver
+type credentialSource interface {
+	credentialProvider() CredentialProvider
+}
+
+// defaultCredentials returns the credential provider of the resolver, or the default docker CLI configuration
+func defaultCredentials(resolver interface{}) CredentialProvider {
+	if source, ok := resolver.(credentialSource); ok {
+		return source.credentialProvider()
+	}
+	return CredentialProviderFunc(func(ctx context.Context, host string) (Credentials, error) {
+		return DockerConfigCredentials(config.LoadDefaultConfigFile(os.Stderr)).Credentials(ctx, host)
+	})
+}
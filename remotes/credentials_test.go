@@ -0,0 +1,76 @@
+package remotes
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/moby/moby/api/pkg/authconfig"
+	"gotest.tools/v3/assert"
+)
+
+func TestCredentialProviders(t *testing.T) {
+	dir := t.TempDir()
+	dockerConfig := `{"auths": {"https://index.docker.io/v1/": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("hub-user:hub-password")) + `"},
+		"my.registry:5000": {"identitytoken": "my-token"}}}`
+	dockerConfigFile := filepath.Join(dir, "config.json")
+	assert.NilError(t, os.WriteFile(dockerConfigFile, []byte(dockerConfig), 0600))
+	secretFile := filepath.Join(dir, "secret.yaml")
+	assert.NilError(t, os.WriteFile(secretFile, []byte(`apiVersion: v1
+kind: Secret
+type: kubernetes.io/dockerconfigjson
+metadata:
+  name: registry
+data:
+  .dockerconfigjson: `+base64.StdEncoding.EncodeToString([]byte(dockerConfig))+"\n"), 0600))
+
+	fromFile, err := NewDockerConfigFileCredentials(dockerConfigFile)
+	assert.NilError(t, err)
+	fromSecret, err := NewKubernetesSecretCredentials(secretFile)
+	assert.NilError(t, err)
+	for _, provider := range []CredentialProvider{fromFile, fromSecret} {
+		credentials, err := registryCredentials(context.Background(), provider, "registry-1.docker.io")
+		assert.NilError(t, err)
+		assert.Equal(t, credentials, Credentials{Username: "hub-user", Password: "hub-password"})
+		credentials, err = provider.Credentials(context.Background(), "my.registry:5000")
+		assert.NilError(t, err)
+		assert.Equal(t, credentials, Credentials{IdentityToken: "my-token"})
+	}
+	_, err = NewKubernetesSecretCredentials(dockerConfigFile)
+	assert.ErrorContains(t, err, "is not a Kubernetes secret with a .dockerconfigjson key")
+
+	t.Setenv("CNAB_TO_OCI_USERNAME_MY_REGISTRY_5000", "env-user")
+	t.Setenv("CNAB_TO_OCI_PASSWORD_MY_REGISTRY_5000", "env-password")
+	chain := ChainCredentials(EnvCredentials(), StaticCredentials(map[string]Credentials{"other.registry": {Username: "static-user"}}), fromFile)
+	for host, expected := range map[string]Credentials{
+		"my.registry:5000": {Username: "env-user", Password: "env-password"},
+		"other.registry":   {Username: "static-user"},
+		"docker.io":        {Username: "hub-user", Password: "hub-password"},
+		"unknown.registry": {},
+	} {
+		credentials, err := chain.Credentials(context.Background(), host)
+		assert.NilError(t, err)
+		assert.Equal(t, credentials, expected, host)
+	}
+}
+
+func TestResolverCredentialsSharedWithImagePushes(t *testing.T) {
+	provider := StaticCredentials(map[string]Credentials{"docker.io": {Username: "user", Password: "password"}})
+	resolver, err := CreateResolverWithOptions(configfile.New(""), WithCredentialProvider(provider))
+	assert.NilError(t, err)
+
+	imageClient := newMockImageClient()
+	cfg, err := newFixupConfig(nil, mustParseNamed(t, "docker.io/user/app:0.1.0"), resolver, WithPushImages(imageClient, io.Discard))
+	assert.NilError(t, err)
+	assert.NilError(t, pushTaggedImage(context.Background(), imageClient, cfg.targetRef, cfg.pushCredentials, cfg.pushOut))
+
+	auth, err := authconfig.Decode(imageClient.registryAuths[0])
+	assert.NilError(t, err)
+	assert.Equal(t, auth.Username, "user")
+	assert.Equal(t, auth.Password, "password")
+	assert.Equal(t, auth.ServerAddress, "docker.io")
+}
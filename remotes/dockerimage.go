@@ -0,0 +1,108 @@
+package remotes
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+
+	"github.com/cnabio/cnab-to-oci/internal"
+	"github.com/opencontainers/go-digest"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// dockerImageSource exports the images of the docker daemon, and extracts their blobs to an OCI image layout
+// directory, from which they are fetched. Each image is only exported once, as the directory lives for the whole run.
+type dockerImageSource struct {
+	layoutStore
+	imageSaver internal.ImageSaver
+	mu         sync.Mutex
+	resolved   map[string]ocischemav1.Descriptor
+}
+
+// NewDockerImageSource exports the local images of the docker daemon, like "docker save", instead of tagging and
+// pushing them through the daemon. The blobs of the exported images are extracted to the directory, which the caller
+// removes once the fixup is done. The daemon must export the images as an OCI image layout, as docker 25 and later do.
+func NewDockerImageSource(imageSaver internal.ImageSaver, dir string) LocalImageSource {
+	return &dockerImageSource{layoutStore: layoutStore{root: dir}, imageSaver: imageSaver, resolved: map[string]ocischemav1.Descriptor{}}
+}
+
+// Resolve exports the image and returns the descriptor of its manifest, or of its index, from the OCI image layout.
+// The images already exported are not exported again.
+func (s *dockerImageSource) Resolve(ctx context.Context, ref string) (ocischemav1.Descriptor, error) {
+	s.mu.Lock()
+	desc, ok := s.resolved[ref]
+	s.mu.Unlock()
+	if ok {
+		return desc, nil
+	}
+	desc, err := s.export(ctx, ref)
+	if err != nil {
+		return ocischemav1.Descriptor{}, err
+	}
+	s.mu.Lock()
+	s.resolved[ref] = desc
+	s.mu.Unlock()
+	return desc, nil
+}
+
+// export exports the image and extracts its blobs to the directory
+func (s *dockerImageSource) export(ctx context.Context, ref string) (ocischemav1.Descriptor, error) {
+	archive, err := s.imageSaver.ImageSave(ctx, []string{ref})
+	if err != nil {
+		return ocischemav1.Descriptor{}, fmt.Errorf("failed to export image %s from the docker daemon: %w", ref, err)
+	}
+	defer archive.Close()
+
+	var index *ocischemav1.Index
+	reader := tar.NewReader(archive)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return ocischemav1.Descriptor{}, fmt.Errorf("failed to read the export of image %s: %s", ref, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(header.Name)
+		switch {
+		case name == ociLayoutIndexFile:
+			index = &ocischemav1.Index{}
+			if err := json.NewDecoder(reader).Decode(index); err != nil {
+				return ocischemav1.Descriptor{}, fmt.Errorf("invalid index in the export of image %s: %s", ref, err)
+			}
+		case path.Dir(path.Dir(name)) == ociLayoutBlobsDir:
+			// The blobs already extracted by a previous export are skipped
+			desc := ocischemav1.Descriptor{
+				Digest: digest.NewDigestFromEncoded(digest.Algorithm(path.Base(path.Dir(name))), path.Base(name)),
+				Size:   header.Size,
+			}
+			if s.exists(desc) {
+				continue
+			}
+			if err := s.writeBlob(desc, reader); err != nil {
+				return ocischemav1.Descriptor{}, fmt.Errorf("failed to extract blob %s of image %s: %s", name, ref, err)
+			}
+		}
+	}
+
+	if index == nil {
+		return ocischemav1.Descriptor{}, fmt.Errorf("the docker daemon did not export image %s as an OCI image layout, docker 25 or later is required", ref)
+	}
+	if len(index.Manifests) != 1 {
+		return ocischemav1.Descriptor{}, fmt.Errorf("expected a single image in the export of %s, got %d", ref, len(index.Manifests))
+	}
+	// The annotations only name the image in the export
+	desc := index.Manifests[0]
+	return ocischemav1.Descriptor{
+		MediaType: desc.MediaType,
+		Digest:    desc.Digest,
+		Size:      desc.Size,
+	}, nil
+}
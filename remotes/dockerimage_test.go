@@ -0,0 +1,139 @@
+package remotes
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/cnabio/cnab-go/bundle"
+	"github.com/containerd/errdefs"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocischema "github.com/opencontainers/image-spec/specs-go"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"gotest.tools/v3/assert"
+)
+
+// newTestImageExport returns an archive exported by the docker daemon, as an OCI image layout, and the descriptor of
+// the image manifest
+func newTestImageExport(t *testing.T, name string) ([]byte, ocischemav1.Descriptor) {
+	t.Helper()
+	reg := newMemoryRegistry()
+	desc := pushTestImage(t, reg, "docker.io/library/"+name, name)
+	manifestBytes, _ := reg.get("docker.io/library/"+name, desc.Digest)
+	var manifest ocischemav1.Manifest
+	assert.NilError(t, json.Unmarshal(manifestBytes, &manifest))
+	annotated := desc
+	annotated.Annotations = map[string]string{ocischemav1.AnnotationRefName: name + ":latest"}
+	index, err := json.Marshal(ocischemav1.Index{
+		Versioned: ocischema.Versioned{SchemaVersion: 2},
+		MediaType: ocischemav1.MediaTypeImageIndex,
+		Manifests: []ocischemav1.Descriptor{annotated},
+	})
+	assert.NilError(t, err)
+
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	writeFile := func(name string, data []byte) {
+		assert.NilError(t, w.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(data))}))
+		_, err := w.Write(data)
+		assert.NilError(t, err)
+	}
+	assert.NilError(t, w.WriteHeader(&tar.Header{Name: "blobs/", Typeflag: tar.TypeDir, Mode: 0755}))
+	for _, blob := range append([]ocischemav1.Descriptor{desc, manifest.Config}, manifest.Layers...) {
+		data, _ := reg.get("docker.io/library/"+name, blob.Digest)
+		writeFile("blobs/sha256/"+blob.Digest.Encoded(), data)
+	}
+	writeFile("oci-layout", []byte(`{"imageLayoutVersion":"1.0.0"}`))
+	writeFile("index.json", index)
+	writeFile("manifest.json", []byte(`[]`))
+	assert.NilError(t, w.Close())
+	return buf.Bytes(), desc
+}
+
+func TestFixupBundleWithDockerImageSource(t *testing.T) {
+	invocationArchive, invocation := newTestImageExport(t, "my-app-invoc")
+	serviceArchive, service := newTestImageExport(t, "my-service")
+	imageClient := newMockImageClient()
+	imageClient.savedImages["my.registry/namespace/my-app-invoc"] = invocationArchive
+	imageClient.savedImages[service.Digest.String()] = serviceArchive
+	b := &bundle.Bundle{
+		SchemaVersion: "v1.0.0",
+		InvocationImages: []bundle.InvocationImage{
+			{BaseImage: bundle.BaseImage{Image: "my.registry/namespace/my-app-invoc", ImageType: "docker"}},
+		},
+		Images: map[string]bundle.Image{
+			"my-service": {BaseImage: bundle.BaseImage{Digest: service.Digest.String(), ImageType: "docker"}},
+		},
+		Name:    "my-app",
+		Version: "0.1.0",
+	}
+	registry := newMemoryRegistry()
+	ref, err := reference.ParseNamed("my.registry/namespace/my-app:0.1.0")
+	assert.NilError(t, err)
+
+	// The images are exported once, for the plan and then for the fixup
+	source := NewDockerImageSource(imageClient, t.TempDir())
+	_, err = PlanFixup(context.Background(), b, ref, registry, WithAutoBundleUpdate(), WithLocalImageSource(source))
+	assert.NilError(t, err)
+	_, err = FixupBundle(context.Background(), b, ref, registry, WithAutoBundleUpdate(), WithLocalImageSource(source))
+	assert.NilError(t, err)
+	assert.Equal(t, imageClient.imageSaves, 2)
+	assert.Equal(t, b.InvocationImages[0].Digest, invocation.Digest.String())
+	assert.Equal(t, b.InvocationImages[0].MediaType, ocischemav1.MediaTypeImageManifest)
+	assert.Equal(t, b.Images["my-service"].Digest, service.Digest.String())
+
+	// The images have been uploaded by digest, without tagging them
+	assert.Equal(t, len(imageClient.taggedImages), 0)
+	assert.Equal(t, imageClient.pushedImages, 0)
+	repo := registry.repository("my.registry/namespace/my-app")
+	assert.Equal(t, len(repo.tags), 0)
+	for _, desc := range []ocischemav1.Descriptor{invocation, service} {
+		manifestBytes, ok := registry.get("my.registry/namespace/my-app", desc.Digest)
+		assert.Assert(t, ok, "missing manifest %s", desc.Digest)
+		var manifest ocischemav1.Manifest
+		assert.NilError(t, json.Unmarshal(manifestBytes, &manifest))
+		for _, blob := range append(manifest.Layers, manifest.Config) {
+			_, ok := registry.get("my.registry/namespace/my-app", blob.Digest)
+			assert.Assert(t, ok, "missing blob %s", blob.Digest)
+		}
+	}
+}
+
+func TestDockerImageSourceErrors(t *testing.T) {
+	ctx := context.Background()
+	imageClient := newMockImageClient()
+	source := NewDockerImageSource(imageClient, t.TempDir())
+
+	_, err := source.Resolve(ctx, "missing")
+	assert.ErrorContains(t, err, "failed to export image missing from the docker daemon")
+
+	// The daemons before docker 25 only export the legacy layout
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	assert.NilError(t, w.WriteHeader(&tar.Header{Name: "manifest.json", Typeflag: tar.TypeReg, Mode: 0644, Size: 2}))
+	_, err = w.Write([]byte(`[]`))
+	assert.NilError(t, err)
+	assert.NilError(t, w.Close())
+	imageClient.savedImages["legacy"] = buf.Bytes()
+	_, err = source.Resolve(ctx, "legacy")
+	assert.ErrorContains(t, err, "did not export image legacy as an OCI image layout")
+
+	// The extracted blobs are checked against their digest
+	buf.Reset()
+	w = tar.NewWriter(&buf)
+	assert.NilError(t, w.WriteHeader(&tar.Header{Name: "blobs/sha256/" + digest.FromString("blob").Encoded(), Typeflag: tar.TypeReg, Mode: 0644, Size: 7}))
+	_, err = w.Write([]byte("corrupt"))
+	assert.NilError(t, err)
+	assert.NilError(t, w.Close())
+	imageClient.savedImages["corrupt"] = buf.Bytes()
+	_, err = source.Resolve(ctx, "corrupt")
+	assert.ErrorContains(t, err, "content does not match digest")
+	_, err = source.Fetch(ctx, ocischemav1.Descriptor{Digest: digest.FromString("blob"), Size: 7})
+	assert.Assert(t, errdefs.IsNotFound(err))
+
+	_, err = source.Fetch(ctx, ocischemav1.Descriptor{Digest: digest.FromString("missing")})
+	assert.Assert(t, errdefs.IsNotFound(err))
+}
@@ -0,0 +1,156 @@
+package remotes
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/cnabio/cnab-to-oci/converter"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/containerd/log"
+	"github.com/containerd/platforms"
+	"github.com/distribution/reference"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/sync/semaphore"
+)
+
+// exportConfig defines the input required for an Export operation
+type exportConfig struct {
+	invocationImagePlatformFilter platforms.Matcher
+	componentImagePlatformFilter  platforms.Matcher
+	maxConcurrentJobs             int64
+}
+
+// ExportOption is a helper for configuring an Export
+type ExportOption func(*exportConfig) error
+
+func newExportConfig(options ...ExportOption) (exportConfig, error) {
+	cfg := exportConfig{
+		maxConcurrentJobs: defaultMaxConcurrentJobs,
+	}
+	for _, opt := range options {
+		if err := opt(&cfg); err != nil {
+			return exportConfig{}, err
+		}
+	}
+	return cfg, nil
+}
+
+// WithExportInvocationImagePlatforms only exports the given platforms of multi-arch invocation images
+func WithExportInvocationImagePlatforms(supportedPlatforms []string) ExportOption {
+	return func(cfg *exportConfig) error {
+		filter, err := platformFilter(supportedPlatforms)
+		cfg.invocationImagePlatformFilter = filter
+		return err
+	}
+}
+
+// WithExportComponentImagePlatforms only exports the given platforms of multi-arch component images
+func WithExportComponentImagePlatforms(supportedPlatforms []string) ExportOption {
+	return func(cfg *exportConfig) error {
+		filter, err := platformFilter(supportedPlatforms)
+		cfg.componentImagePlatformFilter = filter
+		return err
+	}
+}
+
+// Export writes a bundle and the full content of all its images into an OCI image layout directory (a "thick" bundle).
+// The bundle index is referenced from the layout index.json, annotated with the bundle reference. Exporting several
+// bundles to the same directory adds them to the same layout.
+// Platform filters only skip the content of the filtered out platforms: the index of a multi-arch image is exported
+// unchanged, as its digest is referenced by the bundle index.
+func Export(ctx context.Context, ref reference.Named, resolver remotes.Resolver, dir string, opts ...ExportOption) (ocischemav1.Descriptor, error) {
+	logger := log.G(ctx)
+	logger.Debugf("Exporting CNAB Bundle %s to %s", ref, dir)
+
+	cfg, err := newExportConfig(opts...)
+	if err != nil {
+		return ocischemav1.Descriptor{}, err
+	}
+	index, indexDescriptor, indexPayload, err := getIndexWithPayload(ctx, ref, resolver)
+	if err != nil {
+		return ocischemav1.Descriptor{}, err
+	}
+	repoOnly, err := reference.ParseNormalizedNamed(ref.Name())
+	if err != nil {
+		return ocischemav1.Descriptor{}, err
+	}
+	fetcher, err := resolver.Fetcher(ctx, repoOnly.Name())
+	if err != nil {
+		return ocischemav1.Descriptor{}, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return ocischemav1.Descriptor{}, err
+	}
+	store := layoutStore{root: dir}
+
+	limiter := semaphore.NewWeighted(cfg.maxConcurrentJobs)
+	for _, d := range index.Manifests {
+		var filter platforms.Matcher
+		switch d.Annotations[converter.CNABDescriptorTypeAnnotation] {
+		case converter.CNABDescriptorTypeInvocation:
+			filter = cfg.invocationImagePlatformFilter
+		case converter.CNABDescriptorTypeComponent:
+			filter = cfg.componentImagePlatformFilter
+		}
+		logger.Debugf("Exporting descriptor %s", d.Digest)
+		if err := images.Dispatch(withMutedContext(ctx), exportHandler(fetcher, store, filter), limiter, d); err != nil {
+			return ocischemav1.Descriptor{}, fmt.Errorf("failed to export %s@%s: %s", repoOnly.Name(), d.Digest, err)
+		}
+	}
+
+	if err := store.writeBytes(indexDescriptor, indexPayload); err != nil {
+		return ocischemav1.Descriptor{}, err
+	}
+	layoutDescriptor := ocischemav1.Descriptor{
+		MediaType: indexDescriptor.MediaType,
+		Digest:    indexDescriptor.Digest,
+		Size:      indexDescriptor.Size,
+		Annotations: map[string]string{
+			ocischemav1.AnnotationRefName: ref.String(),
+		},
+	}
+	if err := store.addManifest(layoutDescriptor); err != nil {
+		return ocischemav1.Descriptor{}, err
+	}
+
+	logger.Debug("CNAB Bundle exported")
+	return indexDescriptor, nil
+}
+
+// exportHandler copies each descriptor from the fetcher to the layout, then walks its children the same way
+// the manifest walker does
+func exportHandler(fetcher remotes.Fetcher, store layoutStore, filter platforms.Matcher) images.HandlerFunc {
+	copyHandler := images.HandlerFunc(func(ctx context.Context, desc ocischemav1.Descriptor) ([]ocischemav1.Descriptor, error) {
+		if len(desc.URLs) > 0 {
+			// foreign layers are not distributed by the registry
+			return nil, images.ErrSkipDesc
+		}
+		if store.exists(desc) {
+			return nil, nil
+		}
+		reader, err := fetcher.Fetch(ctx, desc)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return nil, store.writeBlob(desc, reader)
+	})
+	getChildren := images.ChildrenHandler(&imageContentProvider{store})
+	if filter != nil {
+		getChildren = images.FilterPlatforms(getChildren, filter)
+	}
+	return images.Handlers(copyHandler, getChildren)
+}
+
+func platformFilter(supportedPlatforms []string) (platforms.Matcher, error) {
+	if len(supportedPlatforms) == 0 {
+		return nil, nil
+	}
+	plats, err := toPlatforms(supportedPlatforms)
+	if err != nil {
+		return nil, err
+	}
+	return platforms.Any(plats...), nil
+}
@@ -0,0 +1,61 @@
+package remotes
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"gotest.tools/v3/assert"
+)
+
+func TestExport(t *testing.T) {
+	reg := newMemoryRegistry()
+	ref := mustParseNamed(t, "my.registry/build/my-app:0.1.0")
+	_, pushedDescriptor := pushTestBundle(t, reg, ref)
+	dir := t.TempDir()
+
+	exportedDescriptor, err := Export(context.Background(), ref, reg, dir)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, exportedDescriptor, pushedDescriptor)
+
+	layout, err := os.ReadFile(filepath.Join(dir, ocischemav1.ImageLayoutFile))
+	assert.NilError(t, err)
+	assert.Equal(t, string(layout), `{"imageLayoutVersion":"1.0.0"}`)
+
+	store := layoutStore{root: dir}
+	index, err := store.readIndex()
+	assert.NilError(t, err)
+	assert.Equal(t, len(index.Manifests), 1)
+	assert.Equal(t, index.Manifests[0].Digest, pushedDescriptor.Digest)
+	assert.Equal(t, index.Manifests[0].Annotations[ocischemav1.AnnotationRefName], "my.registry/build/my-app:0.1.0")
+
+	// Every blob of the registry repository is in the layout
+	for dgst, data := range reg.repository("my.registry/build/my-app").content {
+		stored, err := os.ReadFile(store.blobPath(dgst))
+		assert.NilError(t, err, dgst)
+		assert.DeepEqual(t, stored, data)
+	}
+	_, err = os.Stat(store.blobPath(digest.FromString("my-service layer")))
+	assert.NilError(t, err)
+
+	// Exporting again to the same layout does not duplicate the index entry
+	_, err = Export(context.Background(), ref, reg, dir)
+	assert.NilError(t, err)
+	index, err = store.readIndex()
+	assert.NilError(t, err)
+	assert.Equal(t, len(index.Manifests), 1)
+	indexBytes, err := os.ReadFile(filepath.Join(dir, ociLayoutIndexFile))
+	assert.NilError(t, err)
+	assert.Assert(t, json.Valid(indexBytes))
+}
+
+func TestExportInvalidPlatform(t *testing.T) {
+	reg := newMemoryRegistry()
+	_, err := Export(context.Background(), mustParseNamed(t, "my.registry/build/my-app:0.1.0"), reg, t.TempDir(),
+		WithExportInvocationImagePlatforms([]string{"not/a/valid/platform/at/all"}))
+	assert.ErrorContains(t, err, "platform")
+}
@@ -15,7 +15,9 @@ import (
 	"github.com/distribution/reference"
 	"github.com/hashicorp/go-multierror"
 	"github.com/moby/moby/client"
+	"github.com/opencontainers/go-digest"
 	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/sync/errgroup"
 )
 
 // FixupBundle checks that all the references are present in the referenced repository, otherwise it will mount all
@@ -29,42 +31,78 @@ func FixupBundle(ctx context.Context, b *bundle.Bundle, ref reference.Named, res
 	if err != nil {
 		return nil, err
 	}
+	ctx = withRetryPolicy(ctx, cfg.retryPolicy)
 
-	events := make(chan FixupEvent)
-	eventLoopDone := make(chan struct{})
-	defer func() {
-		close(events)
-		// wait for all queued events to be treated
-		<-eventLoopDone
-	}()
-	go func() {
-		defer close(eventLoopDone)
-		for ev := range events {
-			cfg.eventCallback(ev)
-		}
-	}()
+	events, stopEventLoop := startEventLoop(cfg.eventCallback)
+	defer stopEventLoop()
 
 	// Fixup invocation images
-	if len(b.InvocationImages) != 1 {
-		return nil, fmt.Errorf("only one invocation image supported for bundle %q", ref)
+	if len(b.InvocationImages) == 0 {
+		return nil, fmt.Errorf("no invocation image in bundle %q", ref)
+	}
+
+	// Each image is fixed up on its own copy of the base image. The bundle and the relocation map are only updated
+	// once all the images are fixed up, in the bundle order, so the result does not depend on the scheduling.
+	fixups := bundleImageFixups(b, cfg)
+	if err := checkPolicy(ctx, cfg, fixups); err != nil {
+		return nil, err
 	}
 
 	relocationMap := cfg.relocationMap
-	if err := fixupImage(ctx, "InvocationImage", &b.InvocationImages[0].BaseImage, relocationMap, cfg, events, cfg.invocationImagePlatformFilter); err != nil {
+	workGroup, fixupCtx := errgroup.WithContext(ctx)
+	workGroup.SetLimit(cfg.imageParallelism)
+	for _, f := range fixups {
+		workGroup.Go(func() error {
+			var err error
+			f.relocatedRef, err = fixupImage(fixupCtx, f.name, &f.baseImage, relocationMap, cfg, events, f.platformFilter)
+			return err
+		})
+	}
+	if err := workGroup.Wait(); err != nil {
 		return nil, err
 	}
-	// Fixup images
-	for name, original := range b.Images {
-		if err := fixupImage(ctx, name, &original.BaseImage, relocationMap, cfg, events, cfg.componentImagePlatformFilter); err != nil {
-			return nil, err
+
+	for i, f := range fixups {
+		relocationMap[f.baseImage.Image] = f.relocatedRef
+		if i < len(b.InvocationImages) {
+			b.InvocationImages[i].BaseImage = f.baseImage
+			continue
 		}
-		b.Images[name] = original
+		image := b.Images[f.name]
+		image.BaseImage = f.baseImage
+		b.Images[f.name] = image
 	}
 
 	logger.Debug("Bundle fixed")
 	return relocationMap, nil
 }
 
+// imageFixup is an image of the bundle to fix up, and the digested reference of the image once relocated
+type imageFixup struct {
+	name           string
+	baseImage      bundle.BaseImage
+	platformFilter platforms.Matcher
+	relocatedRef   string
+}
+
+// bundleImageFixups lists the invocation images, then the component images sorted by name
+func bundleImageFixups(b *bundle.Bundle, cfg fixupConfig) []*imageFixup {
+	var fixups []*imageFixup
+	for i, invocationImage := range b.InvocationImages {
+		name := "InvocationImage"
+		if len(b.InvocationImages) > 1 {
+			name = fmt.Sprintf("InvocationImage[%d]", i)
+		}
+		fixups = append(fixups, &imageFixup{name: name, baseImage: invocationImage.BaseImage, platformFilter: cfg.invocationImagePlatformFilter})
+	}
+	for _, name := range sortedImageNames(b.Images) {
+		fixups = append(fixups, &imageFixup{name: name, baseImage: b.Images[name].BaseImage, platformFilter: cfg.componentImagePlatformFilter})
+	}
+	return fixups
+}
+
+// fixupImage fixes up an image and returns its digested reference inside the target repository. The relocation map is
+// only read, as it is shared with the images fixed up concurrently.
 func fixupImage(
 	ctx context.Context,
 	name string,
@@ -72,7 +110,7 @@ func fixupImage(
 	relocationMap relocation.ImageRelocationMap,
 	cfg fixupConfig,
 	events chan<- FixupEvent,
-	platformFilter platforms.Matcher) error {
+	platformFilter platforms.Matcher) (string, error) {
 
 	// Fixup the base image, using the relocated base image if available
 	sourceImage := *baseImage
@@ -87,61 +125,78 @@ func fixupImage(
 	notifyEvent(FixupEventTypeCopyImageStart, "", nil)
 	fixupInfo, pushed, err := fixupBaseImage(ctx, name, &sourceImage, cfg)
 	if err != nil {
-		return notifyError(notifyEvent, err)
+		return "", notifyError(notifyEvent, err)
 	}
-	// Update the relocation map with the original image name and the digested reference of the image pushed inside the bundle repository
+	// The relocation map maps the original image name to the digested reference of the image pushed inside the bundle repository
 	newRef, err := reference.WithDigest(fixupInfo.targetRepo, fixupInfo.resolvedDescriptor.Digest)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	relocationMap[baseImage.Image] = newRef.String()
-
 	// if the autoUpdateBundle flag is passed, mutate the bundle with the resolved digest, mediaType, and size
 	if cfg.autoBundleUpdate {
 		baseImage.Digest = fixupInfo.resolvedDescriptor.Digest.String()
 		baseImage.Size = uint64(fixupInfo.resolvedDescriptor.Size)
 		baseImage.MediaType = fixupInfo.resolvedDescriptor.MediaType
-	} else {
-		if baseImage.Digest != fixupInfo.resolvedDescriptor.Digest.String() {
-			return fmt.Errorf("image %q digest differs %q after fixup: %q", baseImage.Image, baseImage.Digest, fixupInfo.resolvedDescriptor.Digest.String())
-		}
-		if baseImage.Size != uint64(fixupInfo.resolvedDescriptor.Size) {
-			return fmt.Errorf("image %q size differs %d after fixup: %d", baseImage.Image, baseImage.Size, fixupInfo.resolvedDescriptor.Size)
-		}
-		if baseImage.MediaType != fixupInfo.resolvedDescriptor.MediaType {
-			return fmt.Errorf("image %q media type differs %q after fixup: %q", baseImage.Image, baseImage.MediaType, fixupInfo.resolvedDescriptor.MediaType)
-		}
+	} else if err := checkFixedUpDescriptor(baseImage, fixupInfo.resolvedDescriptor); err != nil {
+		return "", err
 	}
 
 	if pushed {
 		notifyEvent(FixupEventTypeCopyImageEnd, "Image has been pushed for service "+name, nil)
-		return nil
+		return newRef.String(), nil
 	}
 
-	if fixupInfo.sourceRef.Name() == fixupInfo.targetRepo.Name() {
-		notifyEvent(FixupEventTypeCopyImageEnd, "Nothing to do: image reference is already present in repository"+fixupInfo.targetRepo.String(), nil)
-		return nil
-	}
-
-	sourceFetcher, err := makeSourceFetcher(ctx, cfg.resolver, fixupInfo.sourceRef.Name())
-	if err != nil {
-		return notifyError(notifyEvent, err)
+	var sourceFetcher *sourceFetcherWithLocalData
+	if fixupInfo.localSource != nil {
+		sourceFetcher = newSourceFetcherWithLocalData(fixupInfo.localSource)
+	} else {
+		if fixupInfo.sourceRef.Name() == fixupInfo.targetRepo.Name() {
+			notifyEvent(FixupEventTypeCopyImageEnd, "Nothing to do: image reference is already present in repository"+fixupInfo.targetRepo.String(), nil)
+			return newRef.String(), nil
+		}
+		if sourceFetcher, err = makeSourceFetcher(ctx, cfg.resolver, fixupInfo.sourceRef.Name()); err != nil {
+			return "", notifyError(notifyEvent, err)
+		}
 	}
 
 	// Fixup platforms
+	sourceDescriptor := fixupInfo.resolvedDescriptor
 	if err := fixupPlatforms(ctx, baseImage, relocationMap, &fixupInfo, sourceFetcher, platformFilter); err != nil {
-		return notifyError(notifyEvent, err)
+		return "", notifyError(notifyEvent, err)
 	}
 
 	// Prepare and run the copier
 	cleaner, err := makeManifestWalker(ctx, sourceFetcher, notifyEvent, cfg, fixupInfo, progress)
 	if err != nil {
-		return notifyError(notifyEvent, err)
+		return "", notifyError(notifyEvent, err)
 	}
 	defer cleaner()
 
+	if cfg.copyReferrers && fixupInfo.localSource == nil {
+		if sourceDescriptor.Digest != fixupInfo.resolvedDescriptor.Digest {
+			// The referrers of the source image do not refer to the filtered image
+			log.G(ctx).Debugf("Not copying referrers of %s, its platforms have been filtered", fixupInfo.sourceRef)
+		} else if err := copyReferrers(ctx, sourceFetcher, notifyEvent, cfg, fixupInfo, progress); err != nil {
+			return "", notifyError(notifyEvent, err)
+		}
+	}
+
 	notifyEvent(FixupEventTypeCopyImageEnd, "", nil)
+	return newRef.String(), nil
+}
+
+// checkFixedUpDescriptor checks that the image resolved by the fixup is the one described in the bundle
+func checkFixedUpDescriptor(baseImage *bundle.BaseImage, descriptor ocischemav1.Descriptor) error {
+	if baseImage.Digest != descriptor.Digest.String() {
+		return fmt.Errorf("image %q digest differs %q after fixup: %q", baseImage.Image, baseImage.Digest, descriptor.Digest.String())
+	}
+	if baseImage.Size != uint64(descriptor.Size) {
+		return fmt.Errorf("image %q size differs %d after fixup: %d", baseImage.Image, baseImage.Size, descriptor.Size)
+	}
+	if baseImage.MediaType != descriptor.MediaType {
+		return fmt.Errorf("image %q media type differs %q after fixup: %q", baseImage.Image, baseImage.MediaType, descriptor.MediaType)
+	}
 	return nil
 }
 
@@ -208,22 +263,26 @@ func fixupBaseImage(ctx context.Context, name string, baseImage *bundle.BaseImag
 		return imageFixupInfo{}, false, err
 	}
 
-	fixups := []func(context.Context, reference.Named, *bundle.BaseImage, fixupConfig) (imageFixupInfo, bool, bool, error){
-		pushByDigest,
-		resolveImageInRelocationMap,
-		resolveImage,
-		pushLocalImage,
+	fixups := []struct {
+		strategy FixupStrategy
+		fixup    func(context.Context, reference.Named, *bundle.BaseImage, fixupConfig) (imageFixupInfo, bool, bool, error)
+	}{
+		{FixupStrategyPushByDigest, pushByDigest},
+		{FixupStrategyRelocationMap, resolveImageInRelocationMap},
+		{FixupStrategyResolve, resolveImage},
+		{FixupStrategyPushLocalImage, pushLocalImage},
 	}
 
 	var bigErr *multierror.Error
 	for _, f := range fixups {
-		info, pushed, ok, err := f(ctx, targetRepoOnly, baseImage, cfg)
+		info, pushed, ok, err := f.fixup(ctx, targetRepoOnly, baseImage, cfg)
 		if err != nil {
 			log.G(ctx).Debug(err)
 			// do not stop trying fixups after the first error. Only report the errors if all fixups were unable to push the image.
 			bigErr = multierror.Append(bigErr, fmt.Errorf("failed to fixup the image %s for service %q: %v", baseImage.Image, name, err))
 		}
 		if ok {
+			info.strategy = f.strategy
 			return info, pushed, nil
 		}
 	}
@@ -235,6 +294,15 @@ func pushByDigest(ctx context.Context, target reference.Named, baseImage *bundle
 	if baseImage.Image != "" || !cfg.pushImages {
 		return imageFixupInfo{}, false, false, nil
 	}
+	if cfg.localImages != nil {
+		return resolveLocalImage(ctx, target, nil, baseImage.Digest, cfg)
+	}
+	if cfg.dryRun {
+		return imageFixupInfo{
+			targetRepo:         target,
+			resolvedDescriptor: baseImageDescriptor(baseImage),
+		}, true, true, nil
+	}
 	descriptor, err := pushImageToTarget(ctx, baseImage.Digest, cfg)
 	if err != nil {
 		return imageFixupInfo{}, false, false, fmt.Errorf("failed to push digested image %s@%s to target %s: %v", baseImage.Image, baseImage.Digest, target, err)
@@ -251,7 +319,7 @@ func resolveImage(ctx context.Context, target reference.Named, baseImage *bundle
 	if err != nil {
 		return imageFixupInfo{}, false, false, fmt.Errorf("failed to resolve image: invalid source ref %s: %w", baseImage.Image, err)
 	}
-	_, descriptor, err := cfg.resolver.Resolve(ctx, sourceImageRef.String())
+	_, descriptor, err := resolve(ctx, cfg.resolver, sourceImageRef.String())
 	if err != nil {
 		return imageFixupInfo{}, false, false, fmt.Errorf("failed to resolve image %s: %w", sourceImageRef.String(), err)
 	}
@@ -275,7 +343,7 @@ func resolveImageInRelocationMap(ctx context.Context, target reference.Named, ba
 	if err != nil {
 		return imageFixupInfo{}, false, false, fmt.Errorf("failed to resolve image in relocation map: invalid target ref %s: %v", relocatedRef, err)
 	}
-	_, descriptor, err := cfg.resolver.Resolve(ctx, relocatedImageRef.String())
+	_, descriptor, err := resolve(ctx, cfg.resolver, relocatedImageRef.String())
 	if err != nil {
 		return imageFixupInfo{}, false, false, err
 	}
@@ -294,6 +362,17 @@ func pushLocalImage(ctx context.Context, target reference.Named, baseImage *bund
 	if err != nil {
 		return imageFixupInfo{}, false, false, fmt.Errorf("failed to push local image: invalid source ref %s: %v", baseImage.Image, err)
 	}
+	if cfg.localImages != nil {
+		return resolveLocalImage(ctx, target, sourceImageRef, baseImage.Image, cfg)
+	}
+	if cfg.dryRun {
+		// The image of the docker daemon is not inspected, it is expected to match the bundle
+		return imageFixupInfo{
+			targetRepo:         target,
+			sourceRef:          sourceImageRef,
+			resolvedDescriptor: baseImageDescriptor(baseImage),
+		}, true, true, nil
+	}
 	descriptor, err := pushImageToTarget(ctx, baseImage.Image, cfg)
 	if err != nil {
 		return imageFixupInfo{}, false, false, fmt.Errorf("failed to push local image %s: %v", baseImage.Image, err)
@@ -305,6 +384,30 @@ func pushLocalImage(ctx context.Context, target reference.Named, baseImage *bund
 	}, true, true, nil
 }
 
+// resolveLocalImage resolves an image of the local image source. It is not pushed yet: its content is then copied to
+// the target repository like the content of a registry.
+func resolveLocalImage(ctx context.Context, target reference.Named, sourceImageRef reference.Named, src string, cfg fixupConfig) (imageFixupInfo, bool, bool, error) {
+	descriptor, err := cfg.localImages.Resolve(ctx, src)
+	if err != nil {
+		return imageFixupInfo{}, false, false, fmt.Errorf("failed to resolve local image %s: %v", src, err)
+	}
+	return imageFixupInfo{
+		targetRepo:         target,
+		sourceRef:          sourceImageRef,
+		resolvedDescriptor: descriptor,
+		localSource:        cfg.localImages,
+	}, false, true, nil
+}
+
+// baseImageDescriptor returns the descriptor of the image described in the bundle
+func baseImageDescriptor(baseImage *bundle.BaseImage) ocischemav1.Descriptor {
+	return ocischemav1.Descriptor{
+		MediaType: baseImage.MediaType,
+		Digest:    digest.Digest(baseImage.Digest),
+		Size:      int64(baseImage.Size),
+	}
+}
+
 func ref(str string) (reference.Named, error) {
 	r, err := reference.ParseNormalizedNamed(str)
 	if err != nil {
@@ -324,7 +427,11 @@ func ref(str string) (reference.Named, error) {
 //   - tag the image to push with targeted reference
 //   - push the image using a docker `ImageAPIClient`
 //   - resolve the pushed image to grab its digest
+//
+// As all the images go through the same tag, they are pushed one at a time.
 func pushImageToTarget(ctx context.Context, src string, cfg fixupConfig) (ocischemav1.Descriptor, error) {
+	cfg.localPushes.Lock()
+	defer cfg.localPushes.Unlock()
 	taggedRef := reference.TagNameOnly(cfg.targetRef)
 
 	if _, err := cfg.imageClient.ImageTag(ctx, client.ImageTagOptions{
@@ -334,14 +441,19 @@ func pushImageToTarget(ctx context.Context, src string, cfg fixupConfig) (ocisch
 		return ocischemav1.Descriptor{}, fmt.Errorf("failed to push image %q, make sure the image exists locally: %s", src, err)
 	}
 
-	if err := pushTaggedImage(ctx, cfg.imageClient, cfg.targetRef, cfg.pushOut); err != nil {
+	if err := pushTaggedImage(ctx, cfg.imageClient, cfg.targetRef, cfg.pushCredentials, cfg.pushOut); err != nil {
 		return ocischemav1.Descriptor{}, fmt.Errorf("failed to push image %q: %s", src, err)
 	}
+	forgetPushedTag(ctx, cfg.resolver, taggedRef.String())
 
-	_, descriptor, err := cfg.resolver.Resolve(ctx, taggedRef.String())
+	_, descriptor, err := resolve(ctx, cfg.resolver, taggedRef.String())
 	if err != nil {
 		return ocischemav1.Descriptor{}, fmt.Errorf("failed to resolve %q after pushing it: %s", taggedRef, err)
 	}
+	// The daemon pushes without going through the resolver, so its cache must forget the image was not found
+	if cache, ok := cfg.resolver.(*cachingResolver); ok {
+		cache.forgetNotFound(descriptor.Digest)
+	}
 
 	return descriptor, nil
 }
@@ -4,13 +4,19 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/cnabio/cnab-go/bundle"
 	"github.com/cnabio/cnab-to-oci/relocation"
+	"github.com/containerd/containerd/v2/core/content"
 	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/containerd/errdefs"
 	"github.com/containerd/platforms"
 	"github.com/distribution/reference"
 	"github.com/opencontainers/go-digest"
@@ -427,11 +433,78 @@ func TestFixupBundleCheckResolveOrder(t *testing.T) {
 	imageClient := newMockImageClient()
 	ref, err := reference.ParseNamed("my.registry/namespace/my-app")
 	assert.NilError(t, err)
-	_, err = FixupBundle(context.TODO(), b, ref, resolver, WithAutoBundleUpdate(), WithPushImages(imageClient, os.Stdout), WithRelocationMap(relocationMap))
+	// The mock answers the resolves in call order, resolving the same digested reference to different results, which
+	// a registry never does. The resolves cached for the whole fixup are covered by
+	// TestFixupBundleResolvesSharedImagesOnce.
+	_, err = FixupBundle(context.TODO(), b, ref, resolver, WithAutoBundleUpdate(), WithPushImages(imageClient, os.Stdout), WithRelocationMap(relocationMap), WithoutResolverCache())
 	assert.NilError(t, err)
 	assert.Equal(t, imageClient.pushedImages, nbImagePushed)
 }
 
+func TestFixupBundleResolvesSharedImagesOnce(t *testing.T) {
+	reg := newMemoryRegistry()
+	shared := pushTestImage(t, reg, "my.registry/build/shared", "shared")
+	sharedRef := "my.registry/build/shared@" + shared.Digest.String()
+	b := &bundle.Bundle{
+		SchemaVersion: "v1.0.0",
+		Name:          "my-app",
+		Version:       "0.1.0",
+		InvocationImages: []bundle.InvocationImage{
+			{BaseImage: bundle.BaseImage{Image: "my-app-invoc", ImageType: "oci"}},
+		},
+		Images: map[string]bundle.Image{
+			"a-service": {BaseImage: bundle.BaseImage{Image: "a-service", ImageType: "oci"}},
+			"b-service": {BaseImage: bundle.BaseImage{Image: "b-service", ImageType: "oci"}},
+		},
+	}
+	relocationMap := relocation.ImageRelocationMap{"my-app-invoc": sharedRef, "a-service": sharedRef, "b-service": sharedRef}
+	ref := mustParseNamed(t, "my.registry/namespace/my-app:0.1.0")
+	counting := newCountingResolver(reg)
+
+	_, err := FixupBundle(context.Background(), b, ref, counting, WithAutoBundleUpdate(), WithRelocationMap(relocationMap))
+	assert.NilError(t, err)
+	// The relocated image shared by the three images is resolved and fetched once for the whole fixup
+	assert.Equal(t, counting.resolves[sharedRef], 1)
+	assert.Equal(t, counting.fetches[shared.Digest], 1)
+	for _, image := range b.Images {
+		assert.Equal(t, image.Digest, shared.Digest.String())
+	}
+	_, ok := reg.get("my.registry/namespace/my-app", shared.Digest)
+	assert.Assert(t, ok)
+}
+
+func TestFixupBundleForgetsImagesPushedFromDaemon(t *testing.T) {
+	reg := newMemoryRegistry()
+	resolver := newCachingResolver(reg)
+	ctx := context.Background()
+	local := pushTestImage(t, newMemoryRegistry(), "docker.io/library/my-app-invoc", "my-app-invoc")
+	targetRef := "my.registry/namespace/my-app@" + local.Digest.String()
+	_, _, err := resolver.Resolve(ctx, targetRef)
+	assert.Assert(t, errdefs.IsNotFound(err))
+
+	// The mock daemon does not write to the registry, the image it pushes is stored beforehand
+	pushTestImage(t, reg, "my.registry/namespace/my-app", "my-app-invoc")
+	reg.repository("my.registry/namespace/my-app").tags["latest"] = local.Digest
+	b := &bundle.Bundle{
+		SchemaVersion: "v1.0.0",
+		Name:          "my-app",
+		Version:       "0.1.0",
+		InvocationImages: []bundle.InvocationImage{
+			{BaseImage: bundle.BaseImage{Image: "my-app-invoc:local", ImageType: "docker"}},
+		},
+	}
+	imageClient := newMockImageClient()
+	_, err = FixupBundle(ctx, b, mustParseNamed(t, "my.registry/namespace/my-app"), resolver, WithAutoBundleUpdate(), WithPushImages(imageClient, nil))
+	assert.NilError(t, err)
+	assert.Equal(t, imageClient.pushedImages, 1)
+	assert.Equal(t, b.InvocationImages[0].Digest, local.Digest.String())
+
+	// The image pushed from the daemon is not reported as missing by the cache anymore
+	_, desc, err := resolver.Resolve(ctx, targetRef)
+	assert.NilError(t, err)
+	assert.Equal(t, desc.Digest, local.Digest)
+}
+
 func TestFixupBundleFailsWithDifferentDigests(t *testing.T) {
 	index := ocischemav1.Manifest{}
 	bufManifest, err := json.Marshal(index)
@@ -726,3 +799,261 @@ func (f bytesFetcher) Fetch(_ context.Context, _ ocischemav1.Descriptor) (io.Rea
 	reader := bytes.NewReader(f)
 	return io.NopCloser(reader), nil
 }
+
+func TestFixupBundleMultipleInvocationImages(t *testing.T) {
+	reg := newMemoryRegistry()
+	var invocationImages []bundle.InvocationImage
+	for _, name := range []string{"my-app-invoc", "my-app-invoc-arm64"} {
+		repo := "my.registry/build/" + name
+		desc := pushTestImage(t, reg, repo, name)
+		data, _ := reg.get(repo, desc.Digest)
+		reg.put(repo, desc, data, "latest")
+		invocationImages = append(invocationImages, bundle.InvocationImage{
+			BaseImage: bundle.BaseImage{Image: repo, ImageType: "oci"},
+		})
+	}
+	b := &bundle.Bundle{
+		SchemaVersion:    "v1.0.0",
+		Name:             "my-app",
+		Version:          "0.1.0",
+		InvocationImages: invocationImages,
+	}
+	ref := mustParseNamed(t, "my.registry/production/my-app:0.1.0")
+
+	relocationMap, err := FixupBundle(context.Background(), b, ref, reg, WithAutoBundleUpdate())
+	assert.NilError(t, err)
+	assert.Equal(t, len(relocationMap), 2)
+	for _, img := range b.InvocationImages {
+		assert.Assert(t, img.Digest != "")
+		assert.Equal(t, relocationMap[img.Image], "my.registry/production/my-app@"+img.Digest)
+	}
+
+	_, err = Push(context.Background(), b, relocationMap, ref, reg, false)
+	assert.NilError(t, err)
+	pulled, pulledRelocationMap, _, err := Pull(context.Background(), ref, reg)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, pulled, b)
+	assert.DeepEqual(t, pulledRelocationMap, relocationMap)
+}
+
+func TestFixupBundleWithReferrers(t *testing.T) {
+	for _, referrersAPI := range []bool{false, true} {
+		reg := newMemoryRegistry()
+		reg.referrersAPI = referrersAPI
+		var images []ocischemav1.Descriptor
+		for _, name := range []string{"my-app-invoc", "my-service"} {
+			repo := "my.registry/build/" + name
+			desc := pushTestImage(t, reg, repo, name)
+			data, _ := reg.get(repo, desc.Digest)
+			reg.put(repo, desc, data, "latest")
+			images = append(images, desc)
+		}
+		serviceRef := mustParseNamed(t, "my.registry/build/my-service:latest")
+		signature, err := Attach(context.Background(), serviceRef, reg, "application/vnd.example.signature", []AttachedBlob{
+			{MediaType: "application/vnd.example.signature", Data: []byte("signature")},
+		})
+		assert.NilError(t, err)
+		_, err = Attach(context.Background(), serviceRef, reg, "application/spdx+json", []AttachedBlob{
+			{MediaType: "application/spdx+json", Data: []byte("{}")},
+		})
+		assert.NilError(t, err)
+
+		b := &bundle.Bundle{
+			SchemaVersion: "v1.0.0",
+			Name:          "my-app",
+			Version:       "0.1.0",
+			InvocationImages: []bundle.InvocationImage{
+				{BaseImage: bundle.BaseImage{Image: "my.registry/build/my-app-invoc", ImageType: "oci"}},
+			},
+			Images: map[string]bundle.Image{
+				"my-service": {BaseImage: bundle.BaseImage{Image: "my.registry/build/my-service", ImageType: "oci"}},
+			},
+		}
+		ref := mustParseNamed(t, "my.registry/production/my-app:0.1.0")
+		_, err = FixupBundle(context.Background(), b, ref, reg, WithAutoBundleUpdate(), WithReferrers("application/vnd.example.signature"))
+		assert.NilError(t, err)
+
+		// Only the signature is copied next to the relocated image
+		relocatedService := mustParseNamed(t, "my.registry/production/my-app@"+images[1].Digest.String())
+		referrers, err := ListReferrers(context.Background(), relocatedService, reg)
+		assert.NilError(t, err)
+		assert.Equal(t, len(referrers), 1)
+		assert.Equal(t, referrers[0].Digest, signature.Digest)
+		_, ok := reg.get("my.registry/production/my-app", digest.FromString("signature"))
+		assert.Assert(t, ok)
+
+		relocatedInvocation := mustParseNamed(t, "my.registry/production/my-app@"+images[0].Digest.String())
+		referrers, err = ListReferrers(context.Background(), relocatedInvocation, reg)
+		assert.NilError(t, err)
+		assert.Equal(t, len(referrers), 0)
+	}
+}
+
+// slowResolver slows down the resolutions, and records how many run concurrently
+type slowResolver struct {
+	*memoryRegistry
+	mut         sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (r *slowResolver) Resolve(ctx context.Context, ref string) (string, ocischemav1.Descriptor, error) {
+	r.mut.Lock()
+	r.inFlight++
+	r.maxInFlight = max(r.maxInFlight, r.inFlight)
+	r.mut.Unlock()
+	defer func() {
+		r.mut.Lock()
+		r.inFlight--
+		r.mut.Unlock()
+	}()
+	time.Sleep(10 * time.Millisecond)
+	return r.memoryRegistry.Resolve(ctx, ref)
+}
+
+func TestFixupBundleImageParallelism(t *testing.T) {
+	newBundle := func(reg *memoryRegistry) *bundle.Bundle {
+		b := &bundle.Bundle{
+			SchemaVersion: "v1.0.0",
+			Name:          "my-app",
+			Version:       "0.1.0",
+			Images:        map[string]bundle.Image{},
+		}
+		for i := 0; i < 7; i++ {
+			name := fmt.Sprintf("my-service-%d", i)
+			repo := "my.registry/build/" + name
+			desc := pushTestImage(t, reg, repo, name)
+			data, _ := reg.get(repo, desc.Digest)
+			reg.put(repo, desc, data, "latest")
+			baseImage := bundle.BaseImage{Image: repo, ImageType: "oci"}
+			if i == 0 {
+				b.InvocationImages = []bundle.InvocationImage{{BaseImage: baseImage}}
+				continue
+			}
+			b.Images[name] = bundle.Image{BaseImage: baseImage}
+		}
+		return b
+	}
+	ref := mustParseNamed(t, "my.registry/production/my-app:0.1.0")
+
+	// Images fixed up one after another
+	reg := newMemoryRegistry()
+	expectedBundle := newBundle(reg)
+	expectedRelocationMap, err := FixupBundle(context.Background(), expectedBundle, ref, reg, WithAutoBundleUpdate())
+	assert.NilError(t, err)
+
+	reg = newMemoryRegistry()
+	resolver := &slowResolver{memoryRegistry: reg}
+	b := newBundle(reg)
+	var events []FixupEvent
+	relocationMap, err := FixupBundle(context.Background(), b, ref, resolver, WithAutoBundleUpdate(), WithImageParallelism(3),
+		WithEventCallback(func(ev FixupEvent) {
+			events = append(events, ev)
+		}))
+	assert.NilError(t, err)
+	assert.Assert(t, resolver.maxInFlight > 1 && resolver.maxInFlight <= 3, "unexpected number of concurrent resolutions: %d", resolver.maxInFlight)
+	assert.DeepEqual(t, b, expectedBundle)
+	assert.DeepEqual(t, relocationMap, expectedRelocationMap)
+
+	// The events of each image start with the copy start, and end with the copy end
+	eventsPerImage := map[string][]FixupEventType{}
+	for _, ev := range events {
+		eventsPerImage[ev.SourceImage] = append(eventsPerImage[ev.SourceImage], ev.EventType)
+	}
+	assert.Equal(t, len(eventsPerImage), 7)
+	for image, eventTypes := range eventsPerImage {
+		assert.Equal(t, eventTypes[0], FixupEventTypeCopyImageStart, image)
+		assert.Equal(t, eventTypes[len(eventTypes)-1], FixupEventTypeCopyImageEnd, image)
+	}
+
+	_, err = FixupBundle(context.Background(), b, ref, reg, WithImageParallelism(0))
+	assert.ErrorContains(t, err, "invalid image parallelism 0")
+}
+
+// noMountResolver uploads all the blobs, instead of mounting them from their source repository
+type noMountResolver struct {
+	*memoryRegistry
+}
+
+func (r noMountResolver) Pusher(ctx context.Context, ref string) (remotes.Pusher, error) {
+	pusher, err := r.memoryRegistry.Pusher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return remotes.PusherFunc(func(ctx context.Context, desc ocischemav1.Descriptor) (content.Writer, error) {
+		desc.Annotations = nil
+		return pusher.Push(ctx, desc)
+	}), nil
+}
+
+func TestFixupBundleReportsTransferProgress(t *testing.T) {
+	reg := newMemoryRegistry()
+	var images []ocischemav1.Descriptor
+	for _, name := range []string{"my-app-invoc", "my-service"} {
+		repo := "my.registry/build/" + name
+		desc := pushTestImage(t, reg, repo, name)
+		data, _ := reg.get(repo, desc.Digest)
+		reg.put(repo, desc, data, "latest")
+		images = append(images, desc)
+	}
+	b := &bundle.Bundle{
+		SchemaVersion: "v1.0.0",
+		Name:          "my-app",
+		Version:       "0.1.0",
+		InvocationImages: []bundle.InvocationImage{
+			{BaseImage: bundle.BaseImage{Image: "my.registry/build/my-app-invoc", ImageType: "oci"}},
+		},
+		Images: map[string]bundle.Image{
+			"my-service": {BaseImage: bundle.BaseImage{Image: "my.registry/build/my-service", ImageType: "oci"}},
+		},
+	}
+	ref := mustParseNamed(t, "my.registry/production/my-app:0.1.0")
+
+	lastProgress := map[string]ProgressSnapshot{}
+	_, err := FixupBundle(context.Background(), b, ref, noMountResolver{reg}, WithAutoBundleUpdate(),
+		WithEventCallback(func(ev FixupEvent) {
+			if ev.EventType == FixupEventTypeProgress {
+				lastProgress[ev.SourceImage] = ev.Progress
+			}
+		}))
+	assert.NilError(t, err)
+
+	// The invocation image is copied first, and all its content is transferred
+	invocation := lastProgress["my.registry/build/my-app-invoc"]
+	assert.Equal(t, len(invocation.Roots), 1)
+	assert.Equal(t, invocation.Roots[0].Digest, images[0].Digest)
+	assert.Equal(t, invocation.BytesTransferred, invocation.TotalBytes)
+	assertDescriptorTransferred(t, invocation.Roots[0])
+
+	// The config shared with the invocation image is already in the target repository: it is not transferred again
+	service := lastProgress["my.registry/build/my-service"]
+	assert.Equal(t, len(service.Roots), 1)
+	assertDescriptorTransferred(t, service.Roots[0])
+	var config DescriptorProgressSnapshot
+	for _, child := range service.Roots[0].Children {
+		if child.MediaType == ocischemav1.MediaTypeImageConfig {
+			config = child
+		}
+	}
+	assert.Assert(t, config.Done)
+	assert.Equal(t, config.BytesTransferred, int64(0))
+	assert.Equal(t, config.BytesSkipped, config.Size)
+	assert.Equal(t, service.BytesTransferred, service.TotalBytes-config.Size)
+	assert.Equal(t, service.BytesTransferred+service.BytesSkipped, service.TotalBytes)
+}
+
+// assertDescriptorTransferred checks that the descriptor and its children are done, and that the transferred ones
+// were transferred entirely, with their timestamps
+func assertDescriptorTransferred(t *testing.T, d DescriptorProgressSnapshot) {
+	t.Helper()
+	assert.Assert(t, d.Done, d.Digest)
+	assert.Equal(t, d.TotalBytes, d.Size, d.Digest)
+	assert.Equal(t, d.BytesTransferred+d.BytesSkipped, d.Size, d.Digest)
+	if d.BytesTransferred != 0 {
+		assert.Equal(t, d.BytesTransferred, d.Size, d.Digest)
+		assert.Assert(t, !d.StartedAt.IsZero() && !d.CompletedAt.Before(d.StartedAt), d.Digest)
+	}
+	for _, c := range d.Children {
+		assertDescriptorTransferred(t, c)
+	}
+}
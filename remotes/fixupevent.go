@@ -2,8 +2,10 @@ package remotes
 
 import (
 	"sync"
+	"time"
 
 	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
 	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
@@ -35,17 +37,37 @@ const (
 
 type descriptorProgress struct {
 	ocischemav1.Descriptor
-	done     bool
-	action   string
-	err      error
-	children []*descriptorProgress
-	mut      sync.RWMutex
+	done        bool
+	action      string
+	err         error
+	transferred int64
+	startedAt   time.Time
+	completedAt time.Time
+	children    []*descriptorProgress
+	mut         sync.RWMutex
 }
 
 func (p *descriptorProgress) markDone() {
 	p.mut.Lock()
 	defer p.mut.Unlock()
 	p.done = true
+	p.completedAt = time.Now()
+}
+
+// startTransfer resets the transferred bytes count, as a retried transfer starts again from the beginning
+func (p *descriptorProgress) startTransfer() {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if p.startedAt.IsZero() {
+		p.startedAt = time.Now()
+	}
+	p.transferred = 0
+}
+
+func (p *descriptorProgress) addTransferred(n int64) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	p.transferred += n
 }
 
 func (p *descriptorProgress) setAction(a string) {
@@ -70,10 +92,18 @@ func (p *descriptorProgress) snapshot() DescriptorProgressSnapshot {
 	p.mut.RLock()
 	defer p.mut.RUnlock()
 	result := DescriptorProgressSnapshot{
-		Descriptor: p.Descriptor,
-		Done:       p.done,
-		Action:     p.action,
-		Error:      p.err,
+		Descriptor:       p.Descriptor,
+		Done:             p.done,
+		Action:           p.action,
+		Error:            p.err,
+		BytesTransferred: p.transferred,
+		TotalBytes:       p.Size,
+		StartedAt:        p.startedAt,
+		CompletedAt:      p.completedAt,
+		Rate:             transferRate(p.transferred, p.startedAt, p.completedAt),
+	}
+	if p.done && p.transferred == 0 {
+		result.BytesSkipped = p.Size
 	}
 	if len(p.children) != 0 {
 		result.Children = make([]DescriptorProgressSnapshot, len(p.children))
@@ -105,9 +135,60 @@ func (p *progress) snapshot() ProgressSnapshot {
 			result.Roots[ix] = root.snapshot()
 		}
 	}
+	result.rollUp()
 	return result
 }
 
+// rollUp sums the bytes of all the descriptors. A blob referenced several times is only copied once, so it is
+// only counted once.
+func (s *ProgressSnapshot) rollUp() {
+	var startedAt, completedAt time.Time
+	done := true
+	seen := map[digest.Digest]struct{}{}
+	var walk func(d DescriptorProgressSnapshot)
+	walk = func(d DescriptorProgressSnapshot) {
+		if _, ok := seen[d.Digest]; !ok {
+			seen[d.Digest] = struct{}{}
+			s.BytesTransferred += d.BytesTransferred
+			s.BytesSkipped += d.BytesSkipped
+			s.TotalBytes += d.TotalBytes
+			done = done && d.Done
+			if !d.StartedAt.IsZero() && (startedAt.IsZero() || d.StartedAt.Before(startedAt)) {
+				startedAt = d.StartedAt
+			}
+			if d.CompletedAt.After(completedAt) {
+				completedAt = d.CompletedAt
+			}
+		}
+		for _, c := range d.Children {
+			walk(c)
+		}
+	}
+	for _, r := range s.Roots {
+		walk(r)
+	}
+	if !done {
+		completedAt = time.Time{}
+	}
+	s.Rate = transferRate(s.BytesTransferred, startedAt, completedAt)
+}
+
+// transferRate estimates the transfer rate in bytes per second, until now if the transfer is not completed
+func transferRate(transferred int64, startedAt, completedAt time.Time) float64 {
+	if startedAt.IsZero() {
+		return 0
+	}
+	end := completedAt
+	if end.IsZero() {
+		end = time.Now()
+	}
+	elapsed := end.Sub(startedAt).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(transferred) / elapsed
+}
+
 // DescriptorProgressSnapshot describes the current progress of a descriptor
 type DescriptorProgressSnapshot struct {
 	ocischemav1.Descriptor
@@ -115,9 +196,33 @@ type DescriptorProgressSnapshot struct {
 	Action   string
 	Error    error
 	Children []DescriptorProgressSnapshot
+	// BytesTransferred is the number of bytes copied so far. It stays at 0 when the content is mounted, skipped or
+	// already present in the target repository.
+	BytesTransferred int64
+	// BytesSkipped is the size of the content done without being copied: mounted, skipped or already present in the
+	// target repository
+	BytesSkipped int64
+	// TotalBytes is the size of the descriptor content. The descriptor is complete when BytesTransferred plus
+	// BytesSkipped reaches it.
+	TotalBytes int64
+	// StartedAt is the time the copy started, zero if it has not started yet
+	StartedAt time.Time
+	// CompletedAt is the time the descriptor was done, zero if it is not done yet
+	CompletedAt time.Time
+	// Rate is the estimated transfer rate, in bytes per second
+	Rate float64
 }
 
 // ProgressSnapshot describes the current progress of a Fixup operation
 type ProgressSnapshot struct {
 	Roots []DescriptorProgressSnapshot
+	// BytesTransferred is the number of bytes copied so far for all the descriptors
+	BytesTransferred int64
+	// BytesSkipped is the size of all the descriptors done without being copied
+	BytesSkipped int64
+	// TotalBytes is the size of all the descriptors discovered so far, the progress is the sum of BytesTransferred
+	// and BytesSkipped over it
+	TotalBytes int64
+	// Rate is the estimated overall transfer rate, in bytes per second
+	Rate float64
 }
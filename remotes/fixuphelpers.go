@@ -9,6 +9,7 @@ import (
 	"github.com/cnabio/cnab-go/bundle"
 	"github.com/containerd/containerd/v2/core/images"
 	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/containerd/log"
 	"github.com/distribution/reference"
 	"github.com/opencontainers/go-digest"
 	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
@@ -48,6 +49,26 @@ type imageFixupInfo struct {
 	targetRepo         reference.Named
 	sourceRef          reference.Named
 	resolvedDescriptor ocischemav1.Descriptor
+	strategy           FixupStrategy
+	// localSource is the local image source of the image, if it is not copied from a registry
+	localSource LocalImageSource
+}
+
+// startEventLoop forwards the events sent on the returned channel to the callback. The returned function closes the
+// channel and waits for all queued events to be treated.
+func startEventLoop(callback func(FixupEvent)) (chan<- FixupEvent, func()) {
+	events := make(chan FixupEvent)
+	eventLoopDone := make(chan struct{})
+	go func() {
+		defer close(eventLoopDone)
+		for ev := range events {
+			callback(ev)
+		}
+	}()
+	return events, func() {
+		close(events)
+		<-eventLoopDone
+	}
 }
 
 func makeEventNotifier(events chan<- FixupEvent, baseImage string, targetRef reference.Named) (eventNotifier, *progress) {
@@ -78,7 +99,12 @@ func makeSourceFetcher(ctx context.Context, resolver remotes.Resolver, sourceRef
 
 func makeManifestWalker(ctx context.Context, sourceFetcher remotes.Fetcher,
 	notifyEvent eventNotifier, cfg fixupConfig, fixupInfo imageFixupInfo, progress *progress) (func(), error) {
-	copier, err := newDescriptorCopier(ctx, cfg.resolver, sourceFetcher, fixupInfo.targetRepo.String(), notifyEvent, fixupInfo.sourceRef)
+	// The local images cannot be mounted from another repository
+	originalSource := fixupInfo.sourceRef
+	if fixupInfo.localSource != nil {
+		originalSource = nil
+	}
+	copier, err := newDescriptorCopier(ctx, cfg.resolver, sourceFetcher, fixupInfo.targetRepo.String(), notifyEvent, originalSource)
 	if err != nil {
 		return nil, err
 	}
@@ -94,6 +120,39 @@ func makeManifestWalker(ctx context.Context, sourceFetcher remotes.Fetcher,
 	return cleaner, walker.walk(ctx, fixupInfo.resolvedDescriptor)
 }
 
+// copyReferrers copies the referrers of the resolved descriptor from the source repository to the target repository,
+// using the referrers tag schema if the target registry does not index them by subject
+func copyReferrers(ctx context.Context, sourceFetcher remotes.Fetcher,
+	notifyEvent eventNotifier, cfg fixupConfig, fixupInfo imageFixupInfo, progress *progress) error {
+	sourceRepoOnly, err := reference.ParseNormalizedNamed(fixupInfo.sourceRef.Name())
+	if err != nil {
+		return err
+	}
+	subject := fixupInfo.resolvedDescriptor
+	referrers, err := fetchReferrers(ctx, sourceRepoOnly, cfg.resolver, subject.Digest, cfg.referrerArtifactTypes...)
+	if err != nil {
+		return fmt.Errorf("failed to list referrers of %s@%s: %s", sourceRepoOnly.Name(), subject.Digest, err)
+	}
+	for _, referrer := range referrers {
+		log.G(ctx).Debugf("Copying referrer %s of %s@%s", referrer.Digest, sourceRepoOnly.Name(), subject.Digest)
+		referrerInfo := fixupInfo
+		referrerInfo.resolvedDescriptor = ocischemav1.Descriptor{
+			MediaType: referrer.MediaType,
+			Digest:    referrer.Digest,
+			Size:      referrer.Size,
+		}
+		cleaner, err := makeManifestWalker(ctx, sourceFetcher, notifyEvent, cfg, referrerInfo, progress)
+		if err != nil {
+			return err
+		}
+		cleaner()
+		if err := ensureReferrerListed(ctx, fixupInfo.targetRepo, cfg.resolver, subject, referrer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func notifyError(notifyEvent eventNotifier, err error) error {
 	notifyEvent(FixupEventTypeCopyImageEnd, "", err)
 	return err
@@ -3,6 +3,7 @@ package remotes
 import (
 	"fmt"
 	"io"
+	"sync"
 
 	"github.com/cnabio/cnab-go/bundle"
 	"github.com/cnabio/cnab-to-oci/internal"
@@ -28,13 +29,25 @@ type fixupConfig struct {
 	eventCallback                 func(FixupEvent)
 	maxConcurrentJobs             int
 	jobsBufferLength              int
+	imageParallelism              int
 	resolver                      remotes.Resolver
 	invocationImagePlatformFilter platforms.Matcher
 	componentImagePlatformFilter  platforms.Matcher
 	autoBundleUpdate              bool
 	pushImages                    bool
 	imageClient                   internal.ImageClient
+	localImages                   LocalImageSource
 	pushOut                       io.Writer
+	pushCredentials               CredentialProvider
+	copyReferrers                 bool
+	referrerArtifactTypes         []string
+	retryPolicy                   RetryPolicy
+	policy                        *Policy
+	disableResolverCache          bool
+	// localPushes serializes the pushes from the docker daemon, which all go through the target tag
+	localPushes *sync.Mutex
+	// dryRun is set when planning a fixup: nothing is pushed to the target repository
+	dryRun bool
 }
 
 // FixupOption is a helper for configuring a FixupBundle
@@ -49,12 +62,21 @@ func newFixupConfig(b *bundle.Bundle, ref reference.Named, resolver remotes.Reso
 		eventCallback:     noopEventCallback,
 		jobsBufferLength:  defaultJobsBufferLength,
 		maxConcurrentJobs: defaultMaxConcurrentJobs,
+		imageParallelism:  1,
+		localPushes:       &sync.Mutex{},
 	}
 	for _, opt := range options {
 		if err := opt(&cfg); err != nil {
 			return fixupConfig{}, err
 		}
 	}
+	if cfg.pushCredentials == nil {
+		cfg.pushCredentials = defaultCredentials(resolver)
+	}
+	if !cfg.disableResolverCache {
+		// The manifests are resolved and fetched once for all the images
+		cfg.resolver = newCachingResolver(resolver)
+	}
 	return cfg, nil
 }
 
@@ -117,6 +139,27 @@ func WithParallelism(maxConcurrentJobs int, jobsBufferLength int) FixupOption {
 	}
 }
 
+// WithImageParallelism changes the number of images fixed up concurrently, 1 by default. Each image still copies
+// its content with the max concurrent jobs set by WithParallelism.
+func WithImageParallelism(maxConcurrentImages int) FixupOption {
+	return func(cfg *fixupConfig) error {
+		if maxConcurrentImages < 1 {
+			return fmt.Errorf("invalid image parallelism %d, at least one image must be fixed up at a time", maxConcurrentImages)
+		}
+		cfg.imageParallelism = maxConcurrentImages
+		return nil
+	}
+}
+
+// WithoutResolverCache resolves the digests and fetches the manifests each time they are needed. By default, they are
+// cached for the duration of the fixup.
+func WithoutResolverCache() FixupOption {
+	return func(cfg *fixupConfig) error {
+		cfg.disableResolverCache = true
+		return nil
+	}
+}
+
 // WithAutoBundleUpdate updates the bundle with content digests and size provided by the registry
 func WithAutoBundleUpdate() FixupOption {
 	return func(cfg *fixupConfig) error {
@@ -147,6 +190,33 @@ func WithPushImages(imageClient internal.ImageClient, out io.Writer) FixupOption
 	}
 }
 
+// WithLocalImageSource authorizes the fixup to push the images missing from the registries, like WithPushImages, but
+// reads them from the local image source, for instance the image store of containerd, instead of the docker daemon.
+// Their manifests and blobs are copied to the target repository, without going through a tag.
+func WithLocalImageSource(source LocalImageSource) FixupOption {
+	return func(cfg *fixupConfig) error {
+		if source == nil {
+			return fmt.Errorf("could not configure fixup, local image source cannot be nil")
+		}
+		cfg.pushImages = true
+		cfg.localImages = source
+		return nil
+	}
+}
+
+// WithPushImagesCredentials sets the credentials sent to the docker daemon to push the local images. By default,
+// they are the credentials of the resolver if it has been created by CreateResolver, or the ones of the default docker
+// CLI configuration.
+func WithPushImagesCredentials(provider CredentialProvider) FixupOption {
+	return func(cfg *fixupConfig) error {
+		if provider == nil {
+			return fmt.Errorf("could not configure fixup, credential provider cannot be nil")
+		}
+		cfg.pushCredentials = provider
+		return nil
+	}
+}
+
 // WithRelocationMap stores a previously generated relocation map. This map will be used to copy or mount images
 // based on local images but already pushed on a registry.
 // This way if a bundle is pulled on a machine that doesn't contain the images, when the bundle is pushed and images
@@ -157,3 +227,38 @@ func WithRelocationMap(relocationMap relocation.ImageRelocationMap) FixupOption
 		return nil
 	}
 }
+
+// WithReferrers copies the referrers of the images, like signatures or attestations, from their source repository
+// next to the relocated images. Only the referrers with the given artifact types are copied, or all of them if none
+// is given.
+func WithReferrers(artifactTypes ...string) FixupOption {
+	return func(cfg *fixupConfig) error {
+		cfg.copyReferrers = true
+		cfg.referrerArtifactTypes = artifactTypes
+		return nil
+	}
+}
+
+// WithRetryPolicy retries the registry operations failing with a transient error, like a 502, a 429 or a connection
+// reset, according to the given policy
+func WithRetryPolicy(policy RetryPolicy) FixupOption {
+	return func(cfg *fixupConfig) error {
+		cfg.retryPolicy = policy
+		return nil
+	}
+}
+
+// WithPolicy restricts the images the fixup is allowed to relocate. All the images are checked before any content is
+// copied, and the fixup fails with a PolicyViolationError listing all the violations.
+func WithPolicy(policy *Policy) FixupOption {
+	return func(cfg *fixupConfig) error {
+		if policy == nil {
+			return nil
+		}
+		if err := policy.validate(); err != nil {
+			return fmt.Errorf("invalid policy: %s", err)
+		}
+		cfg.policy = policy
+		return nil
+	}
+}
@@ -0,0 +1,233 @@
+package remotes
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/v2/core/remotes/docker"
+	"github.com/pelletier/go-toml/v2"
+	tomlu "github.com/pelletier/go-toml/v2/unstable"
+)
+
+// hostsFile is the part of the containerd hosts.toml format supported by the resolver
+type hostsFile struct {
+	hostsFileHost
+	Server string                   `toml:"server"`
+	Host   map[string]hostsFileHost `toml:"host"`
+}
+
+type hostsFileHost struct {
+	Capabilities []string `toml:"capabilities"`
+	OverridePath bool     `toml:"override_path"`
+	// CA is a CA file, or a list of CA files
+	CA any `toml:"ca"`
+	// Client is a client certificate file including its key, or a list of one certificate file or [certificate, key]
+	// pair
+	Client     any  `toml:"client"`
+	SkipVerify bool `toml:"skip_verify"`
+}
+
+// LoadHostsDir loads a containerd style hosts directory, containing a "<host>/hosts.toml" file per registry. The
+// "host" entries of a file are the mirrors of the registry, tried in order. Unlike containerd, a mirror without
+// explicit capabilities only serves pulls and resolves.
+func LoadHostsDir(dir string) (map[string]RegistryHostConfig, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	hosts := map[string]RegistryHostConfig{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		file := filepath.Join(dir, entry.Name(), "hosts.toml")
+		data, err := os.ReadFile(file)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		hostConfig, err := parseHostsFile(entry.Name(), filepath.Join(dir, entry.Name()), data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hosts file %q: %s", file, err)
+		}
+		hosts[entry.Name()] = hostConfig
+	}
+	return hosts, nil
+}
+
+// parseHostsFile parses the hosts.toml file of a registry, the relative certificate paths being relative to the base
+// directory
+func parseHostsFile(registry string, baseDir string, data []byte) (RegistryHostConfig, error) {
+	var file hostsFile
+	decoder := toml.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		var strictErr *toml.StrictMissingError
+		if errors.As(err, &strictErr) {
+			return RegistryHostConfig{}, errors.New(strictErr.String())
+		}
+		return RegistryHostConfig{}, err
+	}
+
+	var hostConfig RegistryHostConfig
+	serverURL := file.Server
+	if serverURL == "" && (file.CA != nil || file.Client != nil || file.SkipVerify) {
+		// The TLS settings of a file without server apply to the default server of the registry
+		serverURL = registry
+		if registry == defaultDomain {
+			serverURL = defaultRegistryHost
+		}
+	}
+	if serverURL != "" {
+		server, err := parseHostsFileEndpoint(baseDir, serverURL, file.hostsFileHost)
+		if err != nil {
+			return RegistryHostConfig{}, err
+		}
+		hostConfig.Server = &server
+	}
+	mirrors, err := sortedHostsFileMirrors(data)
+	if err != nil {
+		return RegistryHostConfig{}, err
+	}
+	for _, mirror := range mirrors {
+		endpoint, err := parseHostsFileEndpoint(baseDir, mirror, file.Host[mirror])
+		if err != nil {
+			return RegistryHostConfig{}, err
+		}
+		hostConfig.Mirrors = append(hostConfig.Mirrors, endpoint)
+	}
+	return hostConfig, nil
+}
+
+// parseHostsFileEndpoint parses an endpoint URL the way containerd does: "/v2" is appended to its path, unless the
+// path is overridden
+func parseHostsFileEndpoint(baseDir string, rawURL string, host hostsFileHost) (RegistryEndpoint, error) {
+	hasScheme := strings.Contains(rawURL, "://")
+	if !hasScheme {
+		rawURL = "https://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return RegistryEndpoint{}, fmt.Errorf("invalid endpoint %q: %s", rawURL, err)
+	}
+	endpoint := RegistryEndpoint{Host: u.Host}
+	if hasScheme {
+		endpoint.Scheme = u.Scheme
+	}
+	endpoint.Path = "/v2"
+	if u.Path != "" {
+		endpoint.Path = path.Clean(u.Path)
+		if !host.OverridePath && !strings.HasSuffix(endpoint.Path, "/v2") {
+			endpoint.Path += "/v2"
+		}
+	}
+	for _, c := range host.Capabilities {
+		switch strings.ToLower(c) {
+		case "pull":
+			endpoint.Capabilities |= docker.HostCapabilityPull
+		case "resolve":
+			endpoint.Capabilities |= docker.HostCapabilityResolve
+		case "push":
+			endpoint.Capabilities |= docker.HostCapabilityPush
+		case "referrers":
+			endpoint.Capabilities |= docker.HostCapabilityReferrers
+		default:
+			return RegistryEndpoint{}, fmt.Errorf("unknown capability %q of endpoint %q", c, rawURL)
+		}
+	}
+	tlsConfig, err := host.tlsConfig(baseDir)
+	if err != nil {
+		return RegistryEndpoint{}, fmt.Errorf("invalid TLS configuration of endpoint %q: %s", rawURL, err)
+	}
+	endpoint.TLS = tlsConfig
+	return endpoint, endpoint.validate()
+}
+
+// tlsConfig returns the TLS configuration of the host, or nil if it has none
+func (h hostsFileHost) tlsConfig(baseDir string) (*RegistryTLSConfig, error) {
+	if h.CA == nil && h.Client == nil && !h.SkipVerify {
+		return nil, nil
+	}
+	config := &RegistryTLSConfig{InsecureSkipVerify: h.SkipVerify}
+	switch ca := h.CA.(type) {
+	case nil:
+	case string:
+		config.CAFiles = []string{hostsFilePath(baseDir, ca)}
+	case []any:
+		for _, file := range ca {
+			f, ok := file.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid ca %v", ca)
+			}
+			config.CAFiles = append(config.CAFiles, hostsFilePath(baseDir, f))
+		}
+	default:
+		return nil, fmt.Errorf("invalid ca %v", ca)
+	}
+
+	client := h.Client
+	if clients, ok := client.([]any); ok {
+		if len(clients) != 1 {
+			return nil, fmt.Errorf("only one client certificate is supported, %d found", len(clients))
+		}
+		client = clients[0]
+	}
+	switch c := client.(type) {
+	case nil:
+	case string:
+		config.CertFile = hostsFilePath(baseDir, c)
+		config.KeyFile = config.CertFile
+	case []any:
+		if len(c) != 2 {
+			return nil, fmt.Errorf("invalid client certificate and key pair %v", c)
+		}
+		cert, certOK := c[0].(string)
+		key, keyOK := c[1].(string)
+		if !certOK || !keyOK {
+			return nil, fmt.Errorf("invalid client certificate and key pair %v", c)
+		}
+		config.CertFile = hostsFilePath(baseDir, cert)
+		config.KeyFile = hostsFilePath(baseDir, key)
+	default:
+		return nil, fmt.Errorf("invalid client %v", client)
+	}
+	return config, nil
+}
+
+func hostsFilePath(baseDir string, file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(baseDir, file)
+}
+
+// sortedHostsFileMirrors returns the "host" entries in the order of the file, as decoding them into a map loses it
+func sortedHostsFileMirrors(data []byte) ([]string, error) {
+	var mirrors []string
+	p := tomlu.Parser{}
+	p.Reset(data)
+	for p.NextExpression() {
+		e := p.Expression()
+		if e.Kind != tomlu.Table {
+			continue
+		}
+		var parts []string
+		for it := e.Key(); it.Next(); {
+			parts = append(parts, string(it.Node().Data))
+		}
+		// Only the "host.<url>" tables, without their sub-tables
+		if len(parts) != 2 || parts[0] != "host" {
+			continue
+		}
+		mirrors = append(mirrors, parts[1])
+	}
+	return mirrors, p.Error()
+}
@@ -0,0 +1,183 @@
+package remotes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/cnabio/cnab-to-oci/converter"
+	"github.com/cnabio/cnab-to-oci/relocation"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/containerd/log"
+	"github.com/distribution/reference"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// importConfig defines the input required for an Import operation
+type importConfig struct {
+	eventCallback     func(FixupEvent)
+	maxConcurrentJobs int
+	refName           string
+}
+
+// ImportOption is a helper for configuring an Import
+type ImportOption func(*importConfig) error
+
+func newImportConfig(options ...ImportOption) (importConfig, error) {
+	cfg := importConfig{
+		eventCallback:     noopEventCallback,
+		maxConcurrentJobs: defaultMaxConcurrentJobs,
+	}
+	for _, opt := range options {
+		if err := opt(&cfg); err != nil {
+			return importConfig{}, err
+		}
+	}
+	return cfg, nil
+}
+
+// WithImportEventCallback specifies a callback to execute for each Import event
+func WithImportEventCallback(callback func(FixupEvent)) ImportOption {
+	return func(cfg *importConfig) error {
+		cfg.eventCallback = callback
+		return nil
+	}
+}
+
+// WithImportParallelism changes the max concurrent jobs used to push the content of each manifest
+func WithImportParallelism(maxConcurrentJobs int) ImportOption {
+	return func(cfg *importConfig) error {
+		cfg.maxConcurrentJobs = maxConcurrentJobs
+		return nil
+	}
+}
+
+// WithImportReferenceName selects the bundle to import by its reference name, when the layout contains several bundles
+func WithImportReferenceName(refName string) ImportOption {
+	return func(cfg *importConfig) error {
+		cfg.refName = refName
+		return nil
+	}
+}
+
+// Import pushes a bundle exported to an OCI image layout directory, with all its images, to the target repository.
+// The bundle index is pushed unchanged, so the returned descriptor has the same digest as the exported one. The
+// returned relocation map points the bundle images to the target repository.
+// Content already present in the target repository is not pushed again.
+func Import(ctx context.Context, dir string, targetRef reference.Named, resolver remotes.Resolver, opts ...ImportOption) (ocischemav1.Descriptor, relocation.ImageRelocationMap, error) {
+	logger := log.G(ctx)
+	logger.Debugf("Importing CNAB Bundle from %s to %s", dir, targetRef)
+
+	cfg, err := newImportConfig(opts...)
+	if err != nil {
+		return ocischemav1.Descriptor{}, nil, err
+	}
+	store := layoutStore{root: dir}
+	layoutDescriptor, err := store.findManifest(cfg.refName)
+	if err != nil {
+		return ocischemav1.Descriptor{}, nil, err
+	}
+	if layoutDescriptor.MediaType != ocischemav1.MediaTypeImageIndex && layoutDescriptor.MediaType != images.MediaTypeDockerSchema2ManifestList {
+		return ocischemav1.Descriptor{}, nil, fmt.Errorf("invalid media type %q for bundle manifest", layoutDescriptor.MediaType)
+	}
+	indexDescriptor := ocischemav1.Descriptor{
+		MediaType: layoutDescriptor.MediaType,
+		Digest:    layoutDescriptor.Digest,
+		Size:      layoutDescriptor.Size,
+	}
+	indexPayload, err := readLayoutBlob(ctx, store, indexDescriptor)
+	if err != nil {
+		return ocischemav1.Descriptor{}, nil, fmt.Errorf("failed to read bundle manifest from %s: %s", dir, err)
+	}
+	var index ocischemav1.Index
+	if err := json.Unmarshal(indexPayload, &index); err != nil {
+		return ocischemav1.Descriptor{}, nil, fmt.Errorf("failed to read bundle manifest from %s: %s", dir, err)
+	}
+	logPayload(logger, index)
+
+	targetRepo, err := reference.ParseNormalizedNamed(targetRef.Name())
+	if err != nil {
+		return ocischemav1.Descriptor{}, nil, err
+	}
+
+	events, stopEventLoop := startEventLoop(cfg.eventCallback)
+	defer stopEventLoop()
+
+	for _, d := range index.Manifests {
+		if err := importDescriptorTree(ctx, resolver, store, targetRepo, d, cfg, events); err != nil {
+			return ocischemav1.Descriptor{}, nil, err
+		}
+	}
+
+	logger.Debug("Pushing CNAB Index")
+	if err := pushPayload(ctx, resolver, targetRef.String(), indexDescriptor, indexPayload); err != nil {
+		return ocischemav1.Descriptor{}, nil, err
+	}
+
+	b, err := getBundle(ctx, targetRef, resolver, index)
+	if err != nil {
+		return ocischemav1.Descriptor{}, nil, err
+	}
+	relocationMap, err := converter.GenerateRelocationMap(&index, b, targetRef)
+	if err != nil {
+		return ocischemav1.Descriptor{}, nil, err
+	}
+
+	logger.Debug("CNAB Bundle imported")
+	return indexDescriptor, relocationMap, nil
+}
+
+func importDescriptorTree(ctx context.Context, resolver remotes.Resolver, store layoutStore, targetRepo reference.Named,
+	desc ocischemav1.Descriptor, cfg importConfig, events chan<- FixupEvent) error {
+	sourceImage := fmt.Sprintf("%s@%s", store.root, desc.Digest)
+	notifyEvent, progress := makeEventNotifier(events, sourceImage, targetRepo)
+	notifyEvent(FixupEventTypeCopyImageStart, "", nil)
+
+	copier, err := newDescriptorCopier(ctx, resolver, store, targetRepo.String(), notifyEvent, nil)
+	if err != nil {
+		return notifyError(notifyEvent, err)
+	}
+	descriptorContentHandler := &descriptorContentHandler{
+		descriptorCopier: copier,
+		targetRepo:       targetRepo.String(),
+	}
+	walker := newManifestWalker(notifyEvent, progress, descriptorContentHandler, cfg.maxConcurrentJobs)
+	walker.getChildren = skipMissingManifests(walker.getChildren, store)
+	if err := walker.walk(withMutedContext(ctx), desc); err != nil {
+		return notifyError(notifyEvent, err)
+	}
+
+	notifyEvent(FixupEventTypeCopyImageEnd, "", nil)
+	return nil
+}
+
+// skipMissingManifests filters out the manifests of a multi-arch image which have not been exported
+// because of a platform filter
+func skipMissingManifests(getChildren images.HandlerFunc, store layoutStore) images.HandlerFunc {
+	return func(ctx context.Context, desc ocischemav1.Descriptor) ([]ocischemav1.Descriptor, error) {
+		children, err := getChildren(ctx, desc)
+		if err != nil {
+			return nil, err
+		}
+		var result []ocischemav1.Descriptor
+		for _, c := range children {
+			if isManifest(c.MediaType) && !store.exists(c) {
+				log.G(ctx).Debugf("Skipping manifest %s, missing from OCI layout", c.Digest)
+				continue
+			}
+			result = append(result, c)
+		}
+		return result, nil
+	}
+}
+
+func readLayoutBlob(ctx context.Context, store layoutStore, desc ocischemav1.Descriptor) ([]byte, error) {
+	reader, err := store.Fetch(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
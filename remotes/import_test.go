@@ -0,0 +1,68 @@
+package remotes
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cnabio/cnab-to-oci/relocation"
+	"gotest.tools/v3/assert"
+)
+
+func TestImport(t *testing.T) {
+	srcRegistry := newMemoryRegistry()
+	srcRef := mustParseNamed(t, "my.registry/build/my-app:0.1.0")
+	b, pushedDescriptor := pushTestBundle(t, srcRegistry, srcRef)
+	dir := t.TempDir()
+	_, err := Export(context.Background(), srcRef, srcRegistry, dir)
+	assert.NilError(t, err)
+
+	// Import in a disconnected registry, under a new name
+	dstRegistry := newMemoryRegistry()
+	dstRef := mustParseNamed(t, "airgap.registry/apps/my-app:0.1.0")
+	importedDescriptor, relocationMap, err := Import(context.Background(), dir, dstRef, dstRegistry)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, importedDescriptor, pushedDescriptor)
+	expectedRelocationMap := relocation.ImageRelocationMap{
+		"my.registry/build/my-app-invoc": "airgap.registry/apps/my-app@" + b.InvocationImages[0].Digest,
+		"my.registry/build/my-service":   "airgap.registry/apps/my-app@" + b.Images["my-service"].Digest,
+	}
+	assert.DeepEqual(t, relocationMap, expectedRelocationMap)
+
+	// Every blob is available in the target repository
+	for dgst, data := range srcRegistry.repository("my.registry/build/my-app").content {
+		imported, ok := dstRegistry.get("airgap.registry/apps/my-app", dgst)
+		assert.Assert(t, ok, dgst)
+		assert.DeepEqual(t, imported, data)
+	}
+	pulled, pulledRelocationMap, _, err := Pull(context.Background(), dstRef, dstRegistry)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, pulled, b)
+	assert.DeepEqual(t, pulledRelocationMap, expectedRelocationMap)
+
+	// Importing again is a no-op
+	repo := dstRegistry.repository("airgap.registry/apps/my-app")
+	uploads := repo.uploads
+	_, _, err = Import(context.Background(), dir, dstRef, dstRegistry)
+	assert.NilError(t, err)
+	assert.Equal(t, repo.uploads, uploads)
+}
+
+func TestImportSelectsReference(t *testing.T) {
+	reg := newMemoryRegistry()
+	dir := t.TempDir()
+	for _, ref := range []string{"my.registry/build/my-app:0.1.0", "my.registry/build/my-app:0.2.0"} {
+		pushTestBundle(t, reg, mustParseNamed(t, ref))
+		_, err := Export(context.Background(), mustParseNamed(t, ref), reg, dir)
+		assert.NilError(t, err)
+	}
+	dstRef := mustParseNamed(t, "airgap.registry/apps/my-app:0.2.0")
+
+	_, _, err := Import(context.Background(), dir, dstRef, newMemoryRegistry())
+	assert.ErrorContains(t, err, "contains 2 manifests")
+
+	_, _, err = Import(context.Background(), dir, dstRef, newMemoryRegistry(), WithImportReferenceName("my.registry/build/my-app:0.3.0"))
+	assert.ErrorContains(t, err, "not found")
+
+	_, _, err = Import(context.Background(), dir, dstRef, newMemoryRegistry(), WithImportReferenceName("my.registry/build/my-app:0.2.0"))
+	assert.NilError(t, err)
+}
@@ -0,0 +1,108 @@
+package remotes
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cnabio/cnab-to-oci/converter"
+	"github.com/cnabio/cnab-to-oci/relocation"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/containerd/log"
+	"github.com/distribution/reference"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// BundleInspection is a structured view of a bundle stored in a registry
+type BundleInspection struct {
+	Reference                string                        `json:"reference"`
+	IndexDescriptor          ocischemav1.Descriptor        `json:"indexDescriptor"`
+	Annotations              map[string]string             `json:"annotations,omitempty"`
+	ArtifactType             string                        `json:"artifactType,omitempty"`
+	ConfigManifestDescriptor ocischemav1.Descriptor        `json:"configManifestDescriptor"`
+	ConfigBlobDescriptor     ocischemav1.Descriptor        `json:"configBlobDescriptor"`
+	ConfigArtifactType       string                        `json:"configArtifactType,omitempty"`
+	Images                   []InspectedImage              `json:"images"`
+	Fallbacks                []string                      `json:"fallbacks,omitempty"`
+	RelocationMap            relocation.ImageRelocationMap `json:"relocationMap"`
+}
+
+// InspectedImage describes an invocation or component image descriptor of a bundle index
+type InspectedImage struct {
+	Descriptor    ocischemav1.Descriptor `json:"descriptor"`
+	Type          string                 `json:"type"`
+	ComponentName string                 `json:"componentName,omitempty"`
+}
+
+// Inspect fetches the bundle index, its config manifest and the bundle itself, and describes how the bundle is
+// stored in the registry. It resolves the bundle exactly the same way Pull does.
+func Inspect(ctx context.Context, ref reference.Named, resolver remotes.Resolver) (*BundleInspection, error) {
+	log.G(ctx).Debugf("Inspecting CNAB Bundle %s", ref)
+	index, indexDescriptor, err := getIndex(ctx, ref, resolver)
+	if err != nil {
+		return nil, err
+	}
+	repoOnly, err := reference.ParseNormalizedNamed(ref.Name())
+	if err != nil {
+		return nil, fmt.Errorf("invalid bundle manifest reference name %q: %s", ref, err)
+	}
+	configManifestDescriptor, err := getConfigManifestDescriptor(ctx, ref, index)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := getConfigManifest(ctx, ref, repoOnly, resolver, configManifestDescriptor)
+	if err != nil {
+		return nil, err
+	}
+	b, err := getBundleConfig(ctx, ref, repoOnly, resolver, manifest)
+	if err != nil {
+		return nil, err
+	}
+	relocationMap, err := converter.GenerateRelocationMap(&index, b, ref)
+	if err != nil {
+		return nil, err
+	}
+	configBlobDescriptor, err := converter.GetBundleConfigDescriptor(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BundleInspection{
+		Reference:                ref.String(),
+		IndexDescriptor:          indexDescriptor,
+		Annotations:              index.Annotations,
+		ConfigManifestDescriptor: configManifestDescriptor,
+		ConfigBlobDescriptor:     configBlobDescriptor,
+		ArtifactType:             index.ArtifactType,
+		ConfigArtifactType:       manifest.ArtifactType,
+		Fallbacks:                detectFallbacks(indexDescriptor, configManifestDescriptor, configBlobDescriptor),
+		RelocationMap:            relocationMap,
+	}
+	for _, d := range index.Manifests {
+		descriptorType := d.Annotations[converter.CNABDescriptorTypeAnnotation]
+		if descriptorType == converter.CNABDescriptorTypeConfig {
+			continue
+		}
+		result.Images = append(result.Images, InspectedImage{
+			Descriptor:    d,
+			Type:          descriptorType,
+			ComponentName: d.Annotations[converter.CNABDescriptorComponentNameAnnotation],
+		})
+	}
+	return result, nil
+}
+
+// detectFallbacks lists the compatibility fallbacks that were used when the bundle was pushed
+func detectFallbacks(indexDescriptor, configManifestDescriptor, configBlobDescriptor ocischemav1.Descriptor) []string {
+	var fallbacks []string
+	if indexDescriptor.MediaType == images.MediaTypeDockerSchema2ManifestList {
+		fallbacks = append(fallbacks, fmt.Sprintf("index stored as %s instead of %s", indexDescriptor.MediaType, ocischemav1.MediaTypeImageIndex))
+	}
+	if configManifestDescriptor.MediaType != ocischemav1.MediaTypeImageManifest {
+		fallbacks = append(fallbacks, fmt.Sprintf("config manifest stored as %s instead of %s", configManifestDescriptor.MediaType, ocischemav1.MediaTypeImageManifest))
+	}
+	if configBlobDescriptor.MediaType != converter.CNABConfigMediaType {
+		fallbacks = append(fallbacks, fmt.Sprintf("config blob stored as %s instead of %s", configBlobDescriptor.MediaType, converter.CNABConfigMediaType))
+	}
+	return fallbacks
+}
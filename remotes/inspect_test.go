@@ -0,0 +1,67 @@
+package remotes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/cnabio/cnab-to-oci/converter"
+	"github.com/cnabio/cnab-to-oci/tests"
+	"github.com/distribution/reference"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"gotest.tools/v3/assert"
+	"gotest.tools/v3/assert/cmp"
+)
+
+func TestInspect(t *testing.T) {
+	index := tests.MakeTestOCIIndex()
+	bufBundleManifest, err := json.Marshal(index)
+	assert.NilError(t, err)
+	b := tests.MakeTestBundle()
+	bufBundle, err := json.Marshal(b)
+	assert.NilError(t, err)
+
+	fetcher := &mockFetcher{indexBuffers: []*bytes.Buffer{
+		// Bundle index
+		bytes.NewBuffer(bufBundleManifest),
+		// Bundle config manifest
+		bytes.NewBufferString(bundleConfigManifestDescriptor),
+		// Bundle config
+		bytes.NewBuffer(bufBundle),
+	}}
+	resolver := &mockResolver{
+		fetcher: fetcher,
+		resolvedDescriptors: []ocischemav1.Descriptor{
+			// Bundle index descriptor
+			{
+				MediaType: ocischemav1.MediaTypeImageIndex,
+				Digest:    tests.BundleDigest,
+				Size:      int64(len(bufBundleManifest)),
+			},
+		},
+	}
+	ref, err := reference.ParseNamed("my.registry/namespace/my-app:my-tag")
+	assert.NilError(t, err)
+
+	inspection, err := Inspect(context.Background(), ref, resolver)
+	assert.NilError(t, err)
+
+	assert.Equal(t, inspection.Reference, "my.registry/namespace/my-app:my-tag")
+	assert.Equal(t, inspection.IndexDescriptor.Digest, tests.BundleDigest)
+	assert.DeepEqual(t, inspection.Annotations, index.Annotations)
+	assert.DeepEqual(t, inspection.ConfigManifestDescriptor, index.Manifests[0])
+	assert.Equal(t, inspection.ConfigBlobDescriptor.MediaType, converter.CNABConfigMediaType)
+	assert.DeepEqual(t, inspection.RelocationMap, tests.MakeRelocationMap())
+
+	// The test index stores the config manifest with a Docker media type
+	assert.Equal(t, len(inspection.Fallbacks), 1)
+	assert.Assert(t, cmp.Contains(inspection.Fallbacks[0], "config manifest stored as application/vnd.docker.distribution.manifest.v2+json"))
+
+	expectedImages := []InspectedImage{
+		{Descriptor: index.Manifests[1], Type: converter.CNABDescriptorTypeInvocation},
+		{Descriptor: index.Manifests[2], Type: converter.CNABDescriptorTypeComponent, ComponentName: "another-image"},
+		{Descriptor: index.Manifests[3], Type: converter.CNABDescriptorTypeComponent, ComponentName: "image-1"},
+	}
+	assert.DeepEqual(t, inspection.Images, expectedImages)
+}
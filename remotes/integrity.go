@@ -0,0 +1,98 @@
+package remotes
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cnabio/cnab-go/bundle"
+	"github.com/cnabio/cnab-to-oci/converter"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// IntegrityError is returned by a strict pull when the pulled bundle.json does not match the bundle index
+type IntegrityError struct {
+	// Reference is the pulled bundle reference
+	Reference string
+	// Inconsistencies lists every mismatch found between the bundle.json and the index
+	Inconsistencies []string
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("bundle %s failed the integrity checks: %s", e.Reference, strings.Join(e.Inconsistencies, "; "))
+}
+
+// checkBundleIntegrity returns the inconsistencies between the bundle and its index: the runtime version, and the
+// digest, size and media type of each invocation and component image
+func checkBundleIntegrity(index ocischemav1.Index, b *bundle.Bundle) []string {
+	var inconsistencies []string
+	if version, ok := index.Annotations[converter.CNABRuntimeVersionAnnotation]; !ok {
+		inconsistencies = append(inconsistencies, fmt.Sprintf("index has no %s annotation", converter.CNABRuntimeVersionAnnotation))
+	} else if version != string(b.SchemaVersion) {
+		inconsistencies = append(inconsistencies, fmt.Sprintf("index runtime version %q differs from bundle schema version %q", version, b.SchemaVersion))
+	}
+
+	invocationImages := map[int]struct{}{}
+	components := map[string]struct{}{}
+	for _, d := range index.Manifests {
+		switch d.Annotations[converter.CNABDescriptorTypeAnnotation] {
+		case converter.CNABDescriptorTypeInvocation:
+			i, err := converter.GetInvocationImageIndex(d)
+			if err != nil {
+				inconsistencies = append(inconsistencies, err.Error())
+				continue
+			}
+			name := fmt.Sprintf("invocation image %d", i)
+			if _, ok := invocationImages[i]; ok {
+				inconsistencies = append(inconsistencies, fmt.Sprintf("%s has several descriptors in the index", name))
+				continue
+			}
+			invocationImages[i] = struct{}{}
+			if i >= len(b.InvocationImages) {
+				inconsistencies = append(inconsistencies, fmt.Sprintf("%s is in the index but not in the bundle", name))
+				continue
+			}
+			inconsistencies = append(inconsistencies, checkImageDescriptor(name, b.InvocationImages[i].BaseImage, d)...)
+		case converter.CNABDescriptorTypeComponent:
+			componentName := d.Annotations[converter.CNABDescriptorComponentNameAnnotation]
+			name := fmt.Sprintf("component %q", componentName)
+			if _, ok := components[componentName]; ok {
+				inconsistencies = append(inconsistencies, fmt.Sprintf("%s has several descriptors in the index", name))
+				continue
+			}
+			components[componentName] = struct{}{}
+			image, ok := b.Images[componentName]
+			if !ok {
+				inconsistencies = append(inconsistencies, fmt.Sprintf("%s is in the index but not in the bundle", name))
+				continue
+			}
+			inconsistencies = append(inconsistencies, checkImageDescriptor(name, image.BaseImage, d)...)
+		}
+	}
+
+	for i := range b.InvocationImages {
+		if _, ok := invocationImages[i]; !ok {
+			inconsistencies = append(inconsistencies, fmt.Sprintf("invocation image %d has no descriptor in the index", i))
+		}
+	}
+	for _, name := range sortedImageNames(b.Images) {
+		if _, ok := components[name]; !ok {
+			inconsistencies = append(inconsistencies, fmt.Sprintf("component %q has no descriptor in the index", name))
+		}
+	}
+	return inconsistencies
+}
+
+func checkImageDescriptor(name string, baseImage bundle.BaseImage, d ocischemav1.Descriptor) []string {
+	var inconsistencies []string
+	if baseImage.Digest != d.Digest.String() {
+		inconsistencies = append(inconsistencies, fmt.Sprintf("%s digest %q differs from the index digest %q", name, baseImage.Digest, d.Digest))
+	}
+	if baseImage.Size != uint64(d.Size) {
+		inconsistencies = append(inconsistencies, fmt.Sprintf("%s size %d differs from the index size %d", name, baseImage.Size, d.Size))
+	}
+	// Without media type, the index media type has been deduced from the image type when pushing
+	if baseImage.MediaType != "" && baseImage.MediaType != d.MediaType {
+		inconsistencies = append(inconsistencies, fmt.Sprintf("%s media type %q differs from the index media type %q", name, baseImage.MediaType, d.MediaType))
+	}
+	return inconsistencies
+}
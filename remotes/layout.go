@@ -0,0 +1,162 @@
+package remotes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/errdefs"
+	"github.com/opencontainers/go-digest"
+	ocischema "github.com/opencontainers/image-spec/specs-go"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	ociLayoutBlobsDir  = "blobs"
+	ociLayoutIndexFile = "index.json"
+)
+
+// layoutStore reads and writes the content of an OCI image layout directory.
+// See https://github.com/opencontainers/image-spec/blob/main/image-layout.md
+type layoutStore struct {
+	root string
+}
+
+func (s layoutStore) blobPath(d digest.Digest) string {
+	return filepath.Join(s.root, ociLayoutBlobsDir, d.Algorithm().String(), d.Encoded())
+}
+
+// exists checks if a blob is already stored with the expected size
+func (s layoutStore) exists(desc ocischemav1.Descriptor) bool {
+	if err := desc.Digest.Validate(); err != nil {
+		return false
+	}
+	fi, err := os.Stat(s.blobPath(desc.Digest))
+	return err == nil && fi.Size() == desc.Size
+}
+
+// Fetch implements remotes.Fetcher
+func (s layoutStore) Fetch(_ context.Context, desc ocischemav1.Descriptor) (io.ReadCloser, error) {
+	if err := desc.Digest.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid digest %q: %w", desc.Digest, errdefs.ErrInvalidArgument)
+	}
+	f, err := os.Open(s.blobPath(desc.Digest))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("blob %s not found in OCI layout %s: %w", desc.Digest, s.root, errdefs.ErrNotFound)
+	}
+	return f, err
+}
+
+// writeBlob stores a blob read from the reader, checking its size and digest before making it visible in the layout
+func (s layoutStore) writeBlob(desc ocischemav1.Descriptor, reader io.Reader) error {
+	if err := desc.Digest.Validate(); err != nil {
+		return fmt.Errorf("invalid digest %q: %w", desc.Digest, errdefs.ErrInvalidArgument)
+	}
+	path := s.blobPath(desc.Digest)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-"+desc.Digest.Encoded())
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	verifier := desc.Digest.Verifier()
+	n, err := io.Copy(io.MultiWriter(tmp, verifier), reader)
+	if err != nil {
+		return err
+	}
+	if n != desc.Size {
+		return fmt.Errorf("unexpected size %d for blob %s, expected %d", n, desc.Digest, desc.Size)
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("content does not match digest %s", desc.Digest)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s layoutStore) writeBytes(desc ocischemav1.Descriptor, data []byte) error {
+	if s.exists(desc) {
+		return nil
+	}
+	return s.writeBlob(desc, bytes.NewReader(data))
+}
+
+// readIndex reads the index.json file of the layout. A missing file returns an empty index.
+func (s layoutStore) readIndex() (ocischemav1.Index, error) {
+	data, err := os.ReadFile(filepath.Join(s.root, ociLayoutIndexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return ocischemav1.Index{
+			Versioned: ocischema.Versioned{SchemaVersion: 2},
+			MediaType: ocischemav1.MediaTypeImageIndex,
+		}, nil
+	}
+	if err != nil {
+		return ocischemav1.Index{}, err
+	}
+	var index ocischemav1.Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return ocischemav1.Index{}, fmt.Errorf("invalid OCI layout index %s: %s", s.root, err)
+	}
+	return index, nil
+}
+
+// addManifest references the descriptor from the index.json file, replacing any existing entry with the same reference name,
+// and writes the oci-layout file.
+func (s layoutStore) addManifest(desc ocischemav1.Descriptor) error {
+	index, err := s.readIndex()
+	if err != nil {
+		return err
+	}
+	var manifests []ocischemav1.Descriptor
+	for _, d := range index.Manifests {
+		if d.Annotations[ocischemav1.AnnotationRefName] != desc.Annotations[ocischemav1.AnnotationRefName] {
+			manifests = append(manifests, d)
+		}
+	}
+	index.Manifests = append(manifests, desc)
+
+	layout, err := json.Marshal(ocischemav1.ImageLayout{Version: ocischemav1.ImageLayoutVersion})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(s.root, ocischemav1.ImageLayoutFile), layout, 0644); err != nil {
+		return err
+	}
+	indexBytes, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.root, ociLayoutIndexFile), indexBytes, 0644)
+}
+
+// findManifest returns the descriptor referenced from the index.json file with the given reference name.
+// If refName is empty, the index.json file must reference exactly one manifest.
+func (s layoutStore) findManifest(refName string) (ocischemav1.Descriptor, error) {
+	index, err := s.readIndex()
+	if err != nil {
+		return ocischemav1.Descriptor{}, err
+	}
+	if refName == "" {
+		if len(index.Manifests) != 1 {
+			return ocischemav1.Descriptor{}, fmt.Errorf("OCI layout %s contains %d manifests, a reference name must be specified", s.root, len(index.Manifests))
+		}
+		return index.Manifests[0], nil
+	}
+	for _, d := range index.Manifests {
+		if d.Annotations[ocischemav1.AnnotationRefName] == refName {
+			return d, nil
+		}
+	}
+	return ocischemav1.Descriptor{}, fmt.Errorf("reference %q not found in OCI layout %s: %w", refName, s.root, errdefs.ErrNotFound)
+}
@@ -0,0 +1,80 @@
+package remotes
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/containerd/containerd/v2/pkg/namespaces"
+	"github.com/containerd/errdefs"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// LocalImageSource provides the images only available locally, like the images built on the machine. The fixup copies
+// their manifests and blobs to the target repository, as it does for the images of a registry.
+type LocalImageSource interface {
+	// Fetch returns the content of a manifest or a blob of the local images
+	remotes.Fetcher
+	// Resolve returns the descriptor of a local image, given its reference, like "my-app:latest", or its digest. It
+	// returns an errdefs.ErrNotFound error if there is no such image.
+	Resolve(ctx context.Context, ref string) (ocischemav1.Descriptor, error)
+}
+
+// containerdImageSource reads the images of a containerd namespace
+type containerdImageSource struct {
+	images    images.Store
+	content   content.Provider
+	namespace string
+}
+
+// NewContainerdImageSource reads the local images of a containerd namespace, "default" if empty, from its image store
+// and its content store, like the ones given by the ImageService and ContentStore methods of a containerd client.
+// Nerdctl uses the "default" namespace.
+func NewContainerdImageSource(imageStore images.Store, contentStore content.Provider, namespace string) LocalImageSource {
+	if namespace == "" {
+		namespace = namespaces.Default
+	}
+	return &containerdImageSource{images: imageStore, content: contentStore, namespace: namespace}
+}
+
+func (s *containerdImageSource) Resolve(ctx context.Context, ref string) (ocischemav1.Descriptor, error) {
+	ctx = namespaces.WithNamespace(ctx, s.namespace)
+	if dgst, err := digest.Parse(ref); err == nil {
+		imgs, err := s.images.List(ctx)
+		if err != nil {
+			return ocischemav1.Descriptor{}, err
+		}
+		for _, img := range imgs {
+			if img.Target.Digest == dgst {
+				return img.Target, nil
+			}
+		}
+		return ocischemav1.Descriptor{}, fmt.Errorf("no image %s in containerd namespace %q: %w", ref, s.namespace, errdefs.ErrNotFound)
+	}
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return ocischemav1.Descriptor{}, err
+	}
+	// The containerd images are named by their fully qualified reference, like "docker.io/library/alpine:latest"
+	img, err := s.images.Get(ctx, reference.TagNameOnly(named).String())
+	if err != nil {
+		return ocischemav1.Descriptor{}, fmt.Errorf("failed to find image %s in containerd namespace %q: %w", ref, s.namespace, err)
+	}
+	return img.Target, nil
+}
+
+func (s *containerdImageSource) Fetch(ctx context.Context, desc ocischemav1.Descriptor) (io.ReadCloser, error) {
+	ra, err := s.content.ReaderAt(namespaces.WithNamespace(ctx, s.namespace), desc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from the containerd content store: %w", desc.Digest, err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{content.NewReader(ra), ra}, nil
+}
@@ -0,0 +1,131 @@
+package remotes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/cnabio/cnab-go/bundle"
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/plugins/content/local"
+	"github.com/containerd/errdefs"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocischema "github.com/opencontainers/image-spec/specs-go"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"gotest.tools/v3/assert"
+)
+
+// testImageStore is an in-memory containerd image store, only supporting Get and List
+type testImageStore struct {
+	images.Store
+	images map[string]images.Image
+}
+
+func (s *testImageStore) Get(_ context.Context, name string) (images.Image, error) {
+	img, ok := s.images[name]
+	if !ok {
+		return images.Image{}, errdefs.ErrNotFound
+	}
+	return img, nil
+}
+
+func (s *testImageStore) List(_ context.Context, _ ...string) ([]images.Image, error) {
+	var imgs []images.Image
+	for _, img := range s.images {
+		imgs = append(imgs, img)
+	}
+	return imgs, nil
+}
+
+// writeTestContainerdImage writes an image to the content store and returns its descriptor
+func writeTestContainerdImage(t *testing.T, store content.Store, name string) ocischemav1.Descriptor {
+	t.Helper()
+	ctx := context.Background()
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+	layer := []byte(name + " layer")
+	manifest := ocischemav1.Manifest{
+		Versioned: ocischema.Versioned{SchemaVersion: 2},
+		MediaType: ocischemav1.MediaTypeImageManifest,
+		Config:    ocischemav1.Descriptor{MediaType: ocischemav1.MediaTypeImageConfig, Digest: digest.FromBytes(config), Size: int64(len(config))},
+		Layers: []ocischemav1.Descriptor{
+			{MediaType: ocischemav1.MediaTypeImageLayerGzip, Digest: digest.FromBytes(layer), Size: int64(len(layer))},
+		},
+	}
+	manifestBytes, err := json.Marshal(manifest)
+	assert.NilError(t, err)
+	desc := ocischemav1.Descriptor{MediaType: ocischemav1.MediaTypeImageManifest, Digest: digest.FromBytes(manifestBytes), Size: int64(len(manifestBytes))}
+	for _, blob := range []struct {
+		desc ocischemav1.Descriptor
+		data []byte
+	}{{manifest.Config, config}, {manifest.Layers[0], layer}, {desc, manifestBytes}} {
+		assert.NilError(t, content.WriteBlob(ctx, store, blob.desc.Digest.String(), bytes.NewReader(blob.data), blob.desc))
+	}
+	return desc
+}
+
+func TestFixupBundleWithLocalImageSource(t *testing.T) {
+	store, err := local.NewStore(t.TempDir())
+	assert.NilError(t, err)
+	invocation := writeTestContainerdImage(t, store, "my-app-invoc")
+	service := writeTestContainerdImage(t, store, "my-service")
+	imageStore := &testImageStore{images: map[string]images.Image{
+		"my.registry/namespace/my-app-invoc:latest": {Name: "my.registry/namespace/my-app-invoc:latest", Target: invocation},
+		"my.registry/namespace/my-service:1.0":      {Name: "my.registry/namespace/my-service:1.0", Target: service},
+	}}
+	b := &bundle.Bundle{
+		SchemaVersion: "v1.0.0",
+		InvocationImages: []bundle.InvocationImage{
+			{BaseImage: bundle.BaseImage{Image: "my.registry/namespace/my-app-invoc", ImageType: "oci"}},
+		},
+		Images: map[string]bundle.Image{
+			"my-service": {BaseImage: bundle.BaseImage{Digest: service.Digest.String(), ImageType: "oci"}},
+		},
+		Name:    "my-app",
+		Version: "0.1.0",
+	}
+	registry := newMemoryRegistry()
+	ref, err := reference.ParseNamed("my.registry/namespace/my-app")
+	assert.NilError(t, err)
+
+	relocationMap, err := FixupBundle(context.Background(), b, ref, registry, WithAutoBundleUpdate(),
+		WithLocalImageSource(NewContainerdImageSource(imageStore, store, "")))
+	assert.NilError(t, err)
+	assert.Equal(t, b.InvocationImages[0].Digest, invocation.Digest.String())
+	assert.Equal(t, b.Images["my-service"].Digest, service.Digest.String())
+	assert.Equal(t, relocationMap["my.registry/namespace/my-app-invoc"], "my.registry/namespace/my-app@"+invocation.Digest.String())
+
+	// The manifests and the blobs have been copied to the target repository
+	for _, desc := range []ocischemav1.Descriptor{invocation, service} {
+		manifestBytes, ok := registry.get("my.registry/namespace/my-app", desc.Digest)
+		assert.Assert(t, ok, "missing manifest %s", desc.Digest)
+		var manifest ocischemav1.Manifest
+		assert.NilError(t, json.Unmarshal(manifestBytes, &manifest))
+		for _, blob := range append(manifest.Layers, manifest.Config) {
+			_, ok := registry.get("my.registry/namespace/my-app", blob.Digest)
+			assert.Assert(t, ok, "missing blob %s", blob.Digest)
+		}
+	}
+}
+
+func TestContainerdImageSourceResolve(t *testing.T) {
+	store, err := local.NewStore(t.TempDir())
+	assert.NilError(t, err)
+	desc := writeTestContainerdImage(t, store, "alpine")
+	source := NewContainerdImageSource(&testImageStore{images: map[string]images.Image{
+		"docker.io/library/alpine:latest": {Name: "docker.io/library/alpine:latest", Target: desc},
+	}}, store, "")
+	ctx := context.Background()
+
+	for _, ref := range []string{"alpine", "docker.io/library/alpine:latest", desc.Digest.String()} {
+		resolved, err := source.Resolve(ctx, ref)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, resolved, desc)
+	}
+	_, err = source.Resolve(ctx, "alpine:edge")
+	assert.Assert(t, errdefs.IsNotFound(err))
+	_, err = source.Resolve(ctx, digest.FromString("missing").String())
+	assert.Assert(t, errdefs.IsNotFound(err))
+}
@@ -3,12 +3,17 @@ package remotes
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"iter"
+	"strings"
+	"sync"
 
 	"github.com/containerd/containerd/v2/core/content"
 	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/containerd/errdefs"
+	"github.com/distribution/reference"
 	"github.com/moby/moby/api/types/jsonstream"
 	"github.com/moby/moby/client"
 	"github.com/opencontainers/go-digest"
@@ -119,17 +124,250 @@ func (rc mockReadCloser) Wait(_ context.Context) error {
 type mockImageClient struct {
 	pushedImages int
 	taggedImages map[string]string
+	// registryAuths are the encoded credentials sent with each push
+	registryAuths []string
+	// savedImages are the archives exported by ImageSave, by image reference
+	savedImages map[string][]byte
+	imageSaves  int
 }
 
 func newMockImageClient() *mockImageClient {
-	return &mockImageClient{taggedImages: map[string]string{}}
+	return &mockImageClient{taggedImages: map[string]string{}, savedImages: map[string][]byte{}}
 }
 
-func (c *mockImageClient) ImagePush(_ context.Context, _ string, _ client.ImagePushOptions) (client.ImagePushResponse, error) {
+func (c *mockImageClient) ImagePush(_ context.Context, _ string, options client.ImagePushOptions) (client.ImagePushResponse, error) {
 	c.pushedImages++
+	c.registryAuths = append(c.registryAuths, options.RegistryAuth)
 	return mockReadCloser{}, nil
 }
 func (c *mockImageClient) ImageTag(_ context.Context, options client.ImageTagOptions) (client.ImageTagResult, error) {
 	c.taggedImages[options.Source] = options.Target
 	return client.ImageTagResult{}, nil
 }
+func (c *mockImageClient) ImageSave(_ context.Context, imageIDs []string, _ ...client.ImageSaveOption) (client.ImageSaveResult, error) {
+	c.imageSaves++
+	archive, ok := c.savedImages[strings.Join(imageIDs, ",")]
+	if !ok {
+		return nil, fmt.Errorf("no such image: %s", strings.Join(imageIDs, ","))
+	}
+	return io.NopCloser(bytes.NewReader(archive)), nil
+}
+
+// memoryRegistry is an in-memory implementation of remotes.Resolver, storing content per repository.
+// Pushing a blob annotated with a distribution source label mounts it from the source repository when possible.
+type memoryRegistry struct {
+	mut          sync.Mutex
+	repositories map[string]*memoryRepository
+	// referrersAPI makes the fetchers implement remotes.ReferrersFetcher
+	referrersAPI bool
+}
+
+type memoryRepository struct {
+	content     map[digest.Digest][]byte
+	descriptors map[digest.Digest]ocischemav1.Descriptor
+	tags        map[string]digest.Digest
+	mounted     map[digest.Digest]struct{}
+	// number of blobs and manifests uploaded through a writer
+	uploads int
+}
+
+func newMemoryRegistry() *memoryRegistry {
+	return &memoryRegistry{repositories: map[string]*memoryRepository{}}
+}
+
+func (r *memoryRegistry) repository(name string) *memoryRepository {
+	repo, ok := r.repositories[name]
+	if !ok {
+		repo = &memoryRepository{
+			content:     map[digest.Digest][]byte{},
+			descriptors: map[digest.Digest]ocischemav1.Descriptor{},
+			tags:        map[string]digest.Digest{},
+			mounted:     map[digest.Digest]struct{}{},
+		}
+		r.repositories[name] = repo
+	}
+	return repo
+}
+
+func (r *memoryRegistry) put(repoName string, desc ocischemav1.Descriptor, data []byte, tag string) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	repo := r.repository(repoName)
+	desc.Annotations = nil
+	repo.content[desc.Digest] = data
+	repo.descriptors[desc.Digest] = desc
+	if tag != "" {
+		repo.tags[tag] = desc.Digest
+	}
+}
+
+func (r *memoryRegistry) get(repoName string, dgst digest.Digest) ([]byte, bool) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	data, ok := r.repository(repoName).content[dgst]
+	return data, ok
+}
+
+func (r *memoryRegistry) Resolve(_ context.Context, ref string) (string, ocischemav1.Descriptor, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", ocischemav1.Descriptor{}, err
+	}
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	repo := r.repository(named.Name())
+	var dgst digest.Digest
+	switch v := named.(type) {
+	case reference.Digested:
+		dgst = v.Digest()
+	case reference.Tagged:
+		dgst = repo.tags[v.Tag()]
+	}
+	desc, ok := repo.descriptors[dgst]
+	if !ok {
+		return "", ocischemav1.Descriptor{}, fmt.Errorf("%s: %w", ref, errdefs.ErrNotFound)
+	}
+	return ref, desc, nil
+}
+
+func (r *memoryRegistry) Fetcher(_ context.Context, ref string) (remotes.Fetcher, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return nil, err
+	}
+	fetcher := memoryFetcher{registry: r, repoName: named.Name()}
+	if r.referrersAPI {
+		return memoryReferrersFetcher{fetcher}, nil
+	}
+	return fetcher, nil
+}
+
+func (r *memoryRegistry) Pusher(_ context.Context, ref string) (remotes.Pusher, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return nil, err
+	}
+	pusher := memoryPusher{registry: r, repoName: named.Name()}
+	if tagged, ok := named.(reference.Tagged); ok {
+		pusher.tag = tagged.Tag()
+	}
+	return pusher, nil
+}
+
+type memoryFetcher struct {
+	registry *memoryRegistry
+	repoName string
+}
+
+func (f memoryFetcher) Fetch(_ context.Context, desc ocischemav1.Descriptor) (io.ReadCloser, error) {
+	data, ok := f.registry.get(f.repoName, desc.Digest)
+	if !ok {
+		return nil, fmt.Errorf("%s@%s: %w", f.repoName, desc.Digest, errdefs.ErrNotFound)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+type memoryReferrersFetcher struct {
+	memoryFetcher
+}
+
+func (f memoryReferrersFetcher) FetchReferrers(ctx context.Context, dgst digest.Digest, opts ...remotes.FetchReferrersOpt) ([]ocischemav1.Descriptor, error) {
+	var cfg remotes.FetchReferrersConfig
+	for _, opt := range opts {
+		if err := opt(ctx, &cfg); err != nil {
+			return nil, err
+		}
+	}
+	f.registry.mut.Lock()
+	defer f.registry.mut.Unlock()
+	repo := f.registry.repository(f.repoName)
+	referrers := []ocischemav1.Descriptor{}
+	for d, desc := range repo.descriptors {
+		if desc.MediaType != ocischemav1.MediaTypeImageManifest {
+			continue
+		}
+		var manifest ocischemav1.Manifest
+		if err := json.Unmarshal(repo.content[d], &manifest); err != nil || manifest.Subject == nil || manifest.Subject.Digest != dgst {
+			continue
+		}
+		referrers = append(referrers, ocischemav1.Descriptor{
+			MediaType:    desc.MediaType,
+			Digest:       desc.Digest,
+			Size:         desc.Size,
+			ArtifactType: manifest.ArtifactType,
+			Annotations:  manifest.Annotations,
+		})
+	}
+	return filterReferrers(referrers, cfg.ArtifactTypes), nil
+}
+
+type memoryPusher struct {
+	registry *memoryRegistry
+	repoName string
+	tag      string
+}
+
+func (p memoryPusher) Push(_ context.Context, desc ocischemav1.Descriptor) (content.Writer, error) {
+	tag := ""
+	if isManifest(desc.MediaType) {
+		tag = p.tag
+	}
+	if _, ok := p.registry.get(p.repoName, desc.Digest); ok {
+		if tag != "" {
+			data, _ := p.registry.get(p.repoName, desc.Digest)
+			p.registry.put(p.repoName, desc, data, tag)
+		}
+		return nil, fmt.Errorf("content %v on remote: %w", desc.Digest, errdefs.ErrAlreadyExists)
+	}
+	for label, source := range desc.Annotations {
+		if !strings.HasPrefix(label, labelDistributionSource) || isManifest(desc.MediaType) {
+			continue
+		}
+		domain := strings.TrimPrefix(label, labelDistributionSource+".")
+		sourceRepo, err := reference.ParseNormalizedNamed(source)
+		if err != nil || reference.Domain(sourceRepo) != domain {
+			if sourceRepo, err = reference.ParseNormalizedNamed(domain + "/" + source); err != nil {
+				continue
+			}
+		}
+		if data, ok := p.registry.get(sourceRepo.Name(), desc.Digest); ok {
+			p.registry.put(p.repoName, desc, data, "")
+			p.registry.mut.Lock()
+			p.registry.repository(p.repoName).mounted[desc.Digest] = struct{}{}
+			p.registry.mut.Unlock()
+			return nil, fmt.Errorf("content %v on remote: mounted: %w", desc.Digest, errdefs.ErrAlreadyExists)
+		}
+	}
+	return &memoryWriter{pusher: p, desc: desc, tag: tag}, nil
+}
+
+type memoryWriter struct {
+	pusher memoryPusher
+	desc   ocischemav1.Descriptor
+	tag    string
+	buf    bytes.Buffer
+}
+
+func (w *memoryWriter) Write(p []byte) (int, error) { return w.buf.Write(p) }
+func (w *memoryWriter) Close() error                { return nil }
+func (w *memoryWriter) Digest() digest.Digest       { return digest.FromBytes(w.buf.Bytes()) }
+func (w *memoryWriter) Commit(_ context.Context, size int64, expected digest.Digest, _ ...content.Opt) error {
+	if size != 0 && int64(w.buf.Len()) != size {
+		return fmt.Errorf("unexpected commit size %d, expected %d", w.buf.Len(), size)
+	}
+	if expected != "" && w.Digest() != expected {
+		return fmt.Errorf("unexpected commit digest %s, expected %s", w.Digest(), expected)
+	}
+	w.pusher.registry.put(w.pusher.repoName, w.desc, w.buf.Bytes(), w.tag)
+	w.pusher.registry.mut.Lock()
+	w.pusher.registry.repository(w.pusher.repoName).uploads++
+	w.pusher.registry.mut.Unlock()
+	return nil
+}
+func (w *memoryWriter) Status() (content.Status, error) {
+	return content.Status{Offset: int64(w.buf.Len()), Total: w.desc.Size}, nil
+}
+func (w *memoryWriter) Truncate(size int64) error {
+	w.buf.Truncate(int(size))
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/containerd/containerd/v2/core/content"
 	"github.com/containerd/containerd/v2/core/images"
@@ -64,6 +65,12 @@ func (h *descriptorCopier) Handle(ctx context.Context, desc *descriptorProgress)
 		}
 		h.eventNotifier.reportProgress(retErr)
 	}()
+	return retry(ctx, fmt.Sprintf("copy %s", desc.Digest), func() error {
+		return h.copy(ctx, desc)
+	})
+}
+
+func (h *descriptorCopier) copy(ctx context.Context, desc *descriptorProgress) error {
 	writer, err := pushWithAnnotation(ctx, h.targetPusher, h.originalSource, desc.Descriptor)
 	if errors.Is(err, errdefs.ErrAlreadyExists) {
 		desc.markDone()
@@ -81,7 +88,9 @@ func (h *descriptorCopier) Handle(ctx context.Context, desc *descriptorProgress)
 		return err
 	}
 	defer reader.Close()
-	err = content.Copy(ctx, writer, reader, desc.Size, desc.Digest)
+	desc.startTransfer()
+	progressWriter := &progressWriter{Writer: writer, progress: desc, eventNotifier: h.eventNotifier}
+	err = content.Copy(ctx, progressWriter, reader, desc.Size, desc.Digest)
 	if errors.Is(err, errdefs.ErrAlreadyExists) {
 		err = nil
 	}
@@ -91,12 +100,36 @@ func (h *descriptorCopier) Handle(ctx context.Context, desc *descriptorProgress)
 	return err
 }
 
+// progressReportInterval is the minimum delay between two progress events reported during a blob copy
+const progressReportInterval = 500 * time.Millisecond
+
+// progressWriter counts the bytes written to the target, and regularly reports the copy progress
+type progressWriter struct {
+	content.Writer
+	progress      *descriptorProgress
+	eventNotifier eventNotifier
+	lastReport    time.Time
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.progress.addTransferred(int64(n))
+	if now := time.Now(); now.Sub(w.lastReport) >= progressReportInterval {
+		w.lastReport = now
+		w.eventNotifier.reportProgress(nil)
+	}
+	return n, err
+}
+
 func pushWithAnnotation(ctx context.Context, pusher remotes.Pusher, ref reference.Named, desc ocischemav1.Descriptor) (content.Writer, error) {
 	// Add the distribution source annotation to help containerd
-	// mount instead of push when possible.
-	repo := fmt.Sprintf("%s.%s", labelDistributionSource, reference.Domain(ref))
-	desc.Annotations = map[string]string{
-		repo: reference.FamiliarName(ref),
+	// mount instead of push when possible. There is nothing to mount
+	// from when the content does not come from a registry.
+	if ref != nil {
+		repo := fmt.Sprintf("%s.%s", labelDistributionSource, reference.Domain(ref))
+		desc.Annotations = map[string]string{
+			repo: reference.FamiliarName(ref),
+		}
 	}
 	return pusher.Push(ctx, desc)
 }
@@ -114,7 +147,12 @@ type imageContentProvider struct {
 }
 
 func (p *imageContentProvider) ReaderAt(ctx context.Context, desc ocischemav1.Descriptor) (content.ReaderAt, error) {
-	rc, err := p.fetcher.Fetch(ctx, desc)
+	var rc io.ReadCloser
+	err := retry(ctx, fmt.Sprintf("fetch %s", desc.Digest), func() error {
+		var err error
+		rc, err = p.fetcher.Fetch(ctx, desc)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -293,11 +331,7 @@ func (w *manifestWalker) walk(ctx context.Context, desc ocischemav1.Descriptor)
 				return c.Err()
 			default:
 			}
-			err = task.copyTask(c)
-			if err != nil {
-				return err
-			}
-			return nil
+			return task.copyTask(c)
 		})
 		lastDepth = task.depth
 	}
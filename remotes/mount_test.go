@@ -7,9 +7,11 @@ import (
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/containerd/containerd/v2/core/remotes/docker"
 	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
 	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"gotest.tools/v3/assert"
 )
@@ -80,3 +82,87 @@ func TestMountOnPush(t *testing.T) {
 	_, _ = pushWithAnnotation(context.TODO(), r, ref, desc)
 	assert.Equal(t, hasMounted, true)
 }
+
+func TestProgressWriterThrottlesReports(t *testing.T) {
+	reports := 0
+	desc := &descriptorProgress{Descriptor: ocischemav1.Descriptor{Size: 6}}
+	w := &progressWriter{
+		Writer:   &memoryWriter{},
+		progress: desc,
+		eventNotifier: func(FixupEventType, string, error) {
+			reports++
+		},
+	}
+
+	// The first write is reported, the next ones only once the report interval has elapsed
+	for _, chunk := range []string{"ab", "cd"} {
+		_, err := w.Write([]byte(chunk))
+		assert.NilError(t, err)
+	}
+	assert.Equal(t, reports, 1)
+	w.lastReport = time.Now().Add(-progressReportInterval)
+	_, err := w.Write([]byte("ef"))
+	assert.NilError(t, err)
+	assert.Equal(t, reports, 2)
+	assert.Equal(t, desc.snapshot().BytesTransferred, int64(6))
+}
+
+func TestProgressSnapshotRollUpCountsBlobsOnce(t *testing.T) {
+	started := time.Now().Add(-2 * time.Second)
+	completed := started.Add(time.Second)
+	layer := DescriptorProgressSnapshot{
+		Descriptor:       ocischemav1.Descriptor{Digest: digest.FromString("layer"), Size: 10},
+		Done:             true,
+		BytesTransferred: 10,
+		TotalBytes:       10,
+		StartedAt:        started,
+		CompletedAt:      completed,
+	}
+	manifest := func(name string) DescriptorProgressSnapshot {
+		return DescriptorProgressSnapshot{
+			Descriptor:   ocischemav1.Descriptor{Digest: digest.FromString(name), Size: 5},
+			Done:         true,
+			BytesSkipped: 5,
+			TotalBytes:   5,
+			Children:     []DescriptorProgressSnapshot{layer},
+		}
+	}
+	snapshot := ProgressSnapshot{Roots: []DescriptorProgressSnapshot{manifest("amd64"), manifest("arm64")}}
+	snapshot.rollUp()
+	assert.Equal(t, snapshot.BytesTransferred, int64(10))
+	assert.Equal(t, snapshot.BytesSkipped, int64(10))
+	assert.Equal(t, snapshot.TotalBytes, int64(20))
+	assert.Equal(t, snapshot.Rate, float64(10))
+}
+
+func TestDescriptorCopierSetsTransferTimestamps(t *testing.T) {
+	reg := newMemoryRegistry()
+	blob := []byte("layer")
+	desc := ocischemav1.Descriptor{MediaType: ocischemav1.MediaTypeImageLayer, Digest: digest.FromBytes(blob), Size: int64(len(blob))}
+	reg.put("my.registry/build/my-app", desc, blob, "")
+	ctx := context.Background()
+	fetcher, err := reg.Fetcher(ctx, "my.registry/build/my-app")
+	assert.NilError(t, err)
+	copier, err := newDescriptorCopier(ctx, reg, fetcher, "my.registry/production/my-app", func(FixupEventType, string, error) {}, nil)
+	assert.NilError(t, err)
+
+	before := time.Now()
+	progress := &descriptorProgress{Descriptor: desc}
+	assert.NilError(t, copier.Handle(ctx, progress))
+	snapshot := progress.snapshot()
+	assert.Assert(t, snapshot.Done)
+	assert.Equal(t, snapshot.BytesTransferred, desc.Size)
+	assert.Equal(t, snapshot.BytesSkipped, int64(0))
+	assert.Assert(t, !snapshot.StartedAt.Before(before))
+	assert.Assert(t, !snapshot.CompletedAt.Before(snapshot.StartedAt))
+
+	// The content already in the target repository is done, without being transferred
+	progress = &descriptorProgress{Descriptor: desc}
+	assert.NilError(t, copier.Handle(ctx, progress))
+	snapshot = progress.snapshot()
+	assert.Assert(t, snapshot.Done)
+	assert.Equal(t, snapshot.BytesTransferred, int64(0))
+	assert.Equal(t, snapshot.BytesSkipped, desc.Size)
+	assert.Assert(t, snapshot.StartedAt.IsZero())
+	assert.Assert(t, !snapshot.CompletedAt.IsZero())
+}
@@ -0,0 +1,244 @@
+package remotes
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/cnabio/cnab-go/bundle"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/containerd/log"
+	"github.com/containerd/platforms"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// FixupStrategy is the way an image is made available in the target repository by the fixup
+type FixupStrategy string
+
+const (
+	// FixupStrategyPushByDigest pushes an image referenced only by its digest from the docker daemon, or the local image
+	// source
+	FixupStrategyPushByDigest = FixupStrategy("PushByDigest")
+	// FixupStrategyRelocationMap copies the image from its location in the relocation map
+	FixupStrategyRelocationMap = FixupStrategy("RelocationMap")
+	// FixupStrategyResolve copies the image from its original repository
+	FixupStrategyResolve = FixupStrategy("Resolve")
+	// FixupStrategyPushLocalImage pushes the image from the docker daemon, or the local image source
+	FixupStrategyPushLocalImage = FixupStrategy("PushLocalImage")
+)
+
+// PlanAction is the action the fixup would take for a descriptor
+type PlanAction string
+
+const (
+	// PlanActionCopy copies the content from the source repository
+	PlanActionCopy = PlanAction("Copy")
+	// PlanActionMount mounts the blob from the source repository, on the same registry
+	PlanActionMount = PlanAction("Mount")
+	// PlanActionSkipExisting skips the content already present in the target repository
+	PlanActionSkipExisting = PlanAction("Skip (already present)")
+	// PlanActionSkipForeignLayer skips a foreign layer, not distributed by the registry
+	PlanActionSkipForeignLayer = PlanAction("Skip (foreign layer)")
+	// PlanActionPush pushes the image from the docker daemon
+	PlanActionPush = PlanAction("Push")
+)
+
+// FixupPlan describes what FixupBundle would do, without writing anything to the target repository
+type FixupPlan struct {
+	Images []ImageFixupPlan `json:"images"`
+	// BytesToTransfer is the size of all the content to copy
+	BytesToTransfer int64 `json:"bytesToTransfer"`
+}
+
+// ImageFixupPlan describes how an image of the bundle would be fixed up
+type ImageFixupPlan struct {
+	// Name is the name of the image in the bundle, like "InvocationImage" or the component name
+	Name        string                 `json:"name"`
+	SourceImage string                 `json:"sourceImage"`
+	TargetRef   string                 `json:"targetRef"`
+	Strategy    FixupStrategy          `json:"strategy"`
+	Action      PlanAction             `json:"action,omitempty"`
+	Descriptor  ocischemav1.Descriptor `json:"descriptor"`
+	// Platforms lists the platforms kept by the platform filter, if any
+	Platforms       []string         `json:"platforms,omitempty"`
+	Descriptors     []DescriptorPlan `json:"descriptors,omitempty"`
+	BytesToTransfer int64            `json:"bytesToTransfer"`
+}
+
+// DescriptorPlan is the action the fixup would take for a manifest or a blob of an image
+type DescriptorPlan struct {
+	ocischemav1.Descriptor
+	Action PlanAction `json:"action"`
+}
+
+// PlanFixup runs the resolution chain of FixupBundle and walks the manifest tree of each image, without writing
+// anything to the target repository nor modifying the bundle. The images which would be pushed from the docker daemon
+// are not inspected, while the images of the local image source are walked like the images of a registry. The policy,
+// if any, is checked first.
+func PlanFixup(ctx context.Context, b *bundle.Bundle, ref reference.Named, resolver remotes.Resolver, opts ...FixupOption) (*FixupPlan, error) {
+	logger := log.G(ctx)
+	logger.Debugf("Planning fixup of bundle %s", ref)
+
+	cfg, err := newFixupConfig(b, ref, resolver, opts...)
+	if err != nil {
+		return nil, err
+	}
+	cfg.dryRun = true
+	ctx = withRetryPolicy(ctx, cfg.retryPolicy)
+
+	if len(b.InvocationImages) == 0 {
+		return nil, fmt.Errorf("no invocation image in bundle %q", ref)
+	}
+	fixups := bundleImageFixups(b, cfg)
+	if err := checkPolicy(ctx, cfg, fixups); err != nil {
+		return nil, err
+	}
+	plan := &FixupPlan{}
+	for _, f := range fixups {
+		imagePlan, err := planImage(ctx, f.name, f.baseImage, cfg, f.platformFilter)
+		if err != nil {
+			return nil, err
+		}
+		plan.add(imagePlan)
+	}
+	return plan, nil
+}
+
+func (p *FixupPlan) add(imagePlan ImageFixupPlan) {
+	p.Images = append(p.Images, imagePlan)
+	p.BytesToTransfer += imagePlan.BytesToTransfer
+}
+
+func planImage(ctx context.Context, name string, baseImage bundle.BaseImage, cfg fixupConfig, platformFilter platforms.Matcher) (ImageFixupPlan, error) {
+	sourceImage := baseImage
+	if relocatedBaseImage, ok := cfg.relocationMap[baseImage.Image]; ok {
+		sourceImage.Image = relocatedBaseImage
+	}
+	ctx = withMutedContext(ctx)
+	fixupInfo, pushed, err := fixupBaseImage(ctx, name, &sourceImage, cfg)
+	if err != nil {
+		return ImageFixupPlan{}, err
+	}
+	if !cfg.autoBundleUpdate {
+		if err := checkFixedUpDescriptor(&baseImage, fixupInfo.resolvedDescriptor); err != nil {
+			return ImageFixupPlan{}, err
+		}
+	}
+	imagePlan := ImageFixupPlan{
+		Name:        name,
+		SourceImage: sourceImage.Image,
+		TargetRef:   fixupInfo.targetRepo.String(),
+		Strategy:    fixupInfo.strategy,
+		Descriptor:  fixupInfo.resolvedDescriptor,
+	}
+	if pushed {
+		imagePlan.Action = PlanActionPush
+		return imagePlan, nil
+	}
+
+	var sourceFetcher *sourceFetcherWithLocalData
+	if fixupInfo.localSource != nil {
+		sourceFetcher = newSourceFetcherWithLocalData(fixupInfo.localSource)
+	} else {
+		if fixupInfo.sourceRef.Name() == fixupInfo.targetRepo.Name() {
+			imagePlan.Action = PlanActionSkipExisting
+			return imagePlan, nil
+		}
+		if sourceFetcher, err = makeSourceFetcher(ctx, cfg.resolver, fixupInfo.sourceRef.Name()); err != nil {
+			return ImageFixupPlan{}, err
+		}
+	}
+	if err := fixupPlatforms(ctx, &baseImage, cfg.relocationMap, &fixupInfo, sourceFetcher, platformFilter); err != nil {
+		return ImageFixupPlan{}, err
+	}
+	imagePlan.Descriptor = fixupInfo.resolvedDescriptor
+
+	planner := descriptorPlanner{
+		resolver:   cfg.resolver,
+		targetRepo: fixupInfo.targetRepo,
+		mountable:  fixupInfo.localSource == nil && reference.Domain(fixupInfo.sourceRef) == reference.Domain(fixupInfo.targetRepo),
+		children:   images.ChildrenHandler(&imageContentProvider{sourceFetcher}),
+		planned:    map[digest.Digest]struct{}{},
+	}
+	if err := planner.walk(ctx, fixupInfo.resolvedDescriptor, &imagePlan); err != nil {
+		return ImageFixupPlan{}, err
+	}
+	if platformFilter != nil && len(imagePlan.Descriptors) > 0 {
+		imagePlan.Platforms = planner.platforms
+	}
+	return imagePlan, nil
+}
+
+// descriptorPlanner walks a manifest tree the same way the manifest walker does, checking which content is already
+// present in the target repository instead of copying it
+type descriptorPlanner struct {
+	resolver   remotes.Resolver
+	targetRepo reference.Named
+	mountable  bool
+	children   images.HandlerFunc
+	planned    map[digest.Digest]struct{}
+	platforms  []string
+}
+
+func (p *descriptorPlanner) walk(ctx context.Context, desc ocischemav1.Descriptor, imagePlan *ImageFixupPlan) error {
+	if _, ok := p.planned[desc.Digest]; ok {
+		return nil
+	}
+	p.planned[desc.Digest] = struct{}{}
+
+	action, err := p.action(ctx, desc)
+	if err != nil {
+		return err
+	}
+	imagePlan.Descriptors = append(imagePlan.Descriptors, DescriptorPlan{Descriptor: desc, Action: action})
+	if action == PlanActionCopy {
+		imagePlan.BytesToTransfer += desc.Size
+	}
+	if action == PlanActionSkipExisting && isManifest(desc.MediaType) {
+		// the whole tree of an existing manifest is present
+		return nil
+	}
+	children, err := p.children.Handle(ctx, desc)
+	if err != nil {
+		return err
+	}
+	for _, c := range children {
+		if c.Platform != nil && (desc.MediaType == ocischemav1.MediaTypeImageIndex || desc.MediaType == images.MediaTypeDockerSchema2ManifestList) {
+			p.platforms = append(p.platforms, platforms.Format(*c.Platform))
+		}
+		if err := p.walk(ctx, c, imagePlan); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *descriptorPlanner) action(ctx context.Context, desc ocischemav1.Descriptor) (PlanAction, error) {
+	if len(desc.URLs) > 0 {
+		return PlanActionSkipForeignLayer, nil
+	}
+	// Resolving a digested reference finds manifests and blobs
+	targetRef, err := reference.WithDigest(p.targetRepo, desc.Digest)
+	if err != nil {
+		return "", err
+	}
+	if _, _, err := resolve(ctx, p.resolver, targetRef.String()); err == nil {
+		return PlanActionSkipExisting, nil
+	}
+	if p.mountable && !isManifest(desc.MediaType) {
+		return PlanActionMount, nil
+	}
+	return PlanActionCopy, nil
+}
+
+func sortedImageNames(images map[string]bundle.Image) []string {
+	names := make([]string, 0, len(images))
+	for name := range images {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,171 @@
+package remotes
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/cnabio/cnab-go/bundle"
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/containerd/v2/plugins/content/local"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"gotest.tools/v3/assert"
+)
+
+func TestPlanFixup(t *testing.T) {
+	reg := newMemoryRegistry()
+	invocationImage := pushTestImage(t, reg, "my.registry/build/my-app-invoc", "my-app-invoc")
+	serviceImage := pushTestImage(t, reg, "other.registry/library/my-service", "my-service")
+	invocationManifest := readTestManifest(t, reg, "my.registry/build/my-app-invoc", invocationImage)
+	serviceManifest := readTestManifest(t, reg, "other.registry/library/my-service", serviceImage)
+
+	// The invocation image layer is already present in the target repository
+	layerData, _ := reg.get("my.registry/build/my-app-invoc", invocationManifest.Layers[0].Digest)
+	reg.put("my.registry/production/my-app", invocationManifest.Layers[0], layerData, "")
+
+	b := &bundle.Bundle{
+		SchemaVersion: "v1.0.0",
+		Name:          "my-app",
+		Version:       "0.1.0",
+		InvocationImages: []bundle.InvocationImage{
+			{BaseImage: bundle.BaseImage{Image: "my.registry/build/my-app-invoc@" + invocationImage.Digest.String(), ImageType: "oci"}},
+		},
+		Images: map[string]bundle.Image{
+			"my-service": {BaseImage: bundle.BaseImage{Image: "other.registry/library/my-service@" + serviceImage.Digest.String(), ImageType: "oci"}},
+		},
+	}
+	original, err := json.Marshal(b)
+	assert.NilError(t, err)
+	ref := mustParseNamed(t, "my.registry/production/my-app:0.1.0")
+
+	plan, err := PlanFixup(context.Background(), b, ref, reg, WithAutoBundleUpdate())
+	assert.NilError(t, err)
+
+	// Nothing is written, and the bundle is left untouched
+	after, err := json.Marshal(b)
+	assert.NilError(t, err)
+	assert.Equal(t, string(after), string(original))
+	target := reg.repository("my.registry/production/my-app")
+	assert.Equal(t, target.uploads, 0)
+	assert.Equal(t, len(target.content), 1)
+
+	assert.Equal(t, len(plan.Images), 2)
+	invocationPlan := plan.Images[0]
+	assert.Equal(t, invocationPlan.Name, "InvocationImage")
+	assert.Equal(t, invocationPlan.Strategy, FixupStrategyResolve)
+	assert.Equal(t, invocationPlan.TargetRef, "my.registry/production/my-app")
+	assert.Equal(t, invocationPlan.Descriptor.Digest, invocationImage.Digest)
+	assertPlannedActions(t, invocationPlan, map[string]PlanAction{
+		invocationImage.Digest.String():              PlanActionCopy,
+		invocationManifest.Config.Digest.String():    PlanActionMount,
+		invocationManifest.Layers[0].Digest.String(): PlanActionSkipExisting,
+	})
+	assert.Equal(t, invocationPlan.BytesToTransfer, invocationImage.Size)
+
+	servicePlan := plan.Images[1]
+	assert.Equal(t, servicePlan.Name, "my-service")
+	assertPlannedActions(t, servicePlan, map[string]PlanAction{
+		serviceImage.Digest.String():              PlanActionCopy,
+		serviceManifest.Config.Digest.String():    PlanActionCopy,
+		serviceManifest.Layers[0].Digest.String(): PlanActionCopy,
+	})
+	assert.Equal(t, servicePlan.BytesToTransfer, serviceImage.Size+serviceManifest.Config.Size+serviceManifest.Layers[0].Size)
+	assert.Equal(t, plan.BytesToTransfer, invocationPlan.BytesToTransfer+servicePlan.BytesToTransfer)
+
+	// Once fixed up, there is nothing left to transfer
+	_, err = FixupBundle(context.Background(), b, ref, reg, WithAutoBundleUpdate())
+	assert.NilError(t, err)
+	plan, err = PlanFixup(context.Background(), b, ref, reg, WithAutoBundleUpdate())
+	assert.NilError(t, err)
+	assert.Equal(t, plan.BytesToTransfer, int64(0))
+	for _, imagePlan := range plan.Images {
+		assert.Equal(t, len(imagePlan.Descriptors), 1)
+		assert.Equal(t, imagePlan.Descriptors[0].Action, PlanActionSkipExisting)
+	}
+}
+
+func TestPlanFixupPushedImages(t *testing.T) {
+	reg := newMemoryRegistry()
+	b := &bundle.Bundle{
+		SchemaVersion: "v1.0.0",
+		Name:          "my-app",
+		Version:       "0.1.0",
+		InvocationImages: []bundle.InvocationImage{
+			{BaseImage: bundle.BaseImage{Image: "my-app-invoc:local", ImageType: "docker"}},
+		},
+	}
+	ref := mustParseNamed(t, "my.registry/production/my-app:0.1.0")
+	imageClient := newMockImageClient()
+
+	plan, err := PlanFixup(context.Background(), b, ref, reg, WithAutoBundleUpdate(), WithPushImages(imageClient, nil))
+	assert.NilError(t, err)
+	assert.Equal(t, len(plan.Images), 1)
+	assert.Equal(t, plan.Images[0].Strategy, FixupStrategyPushLocalImage)
+	assert.Equal(t, plan.Images[0].Action, PlanActionPush)
+	// The image is neither tagged nor pushed
+	assert.Equal(t, imageClient.pushedImages, 0)
+	assert.Equal(t, len(imageClient.taggedImages), 0)
+}
+
+func TestPlanFixupLocalImageSource(t *testing.T) {
+	store, err := local.NewStore(t.TempDir())
+	assert.NilError(t, err)
+	invocation := writeTestContainerdImage(t, store, "my-app-invoc")
+	source := NewContainerdImageSource(&testImageStore{images: map[string]images.Image{
+		"docker.io/library/my-app-invoc:local": {Name: "docker.io/library/my-app-invoc:local", Target: invocation},
+	}}, store, "")
+	reg := newMemoryRegistry()
+	b := &bundle.Bundle{
+		SchemaVersion: "v1.0.0",
+		Name:          "my-app",
+		Version:       "0.1.0",
+		InvocationImages: []bundle.InvocationImage{
+			{BaseImage: bundle.BaseImage{Image: "my-app-invoc:local", ImageType: "oci"}},
+		},
+	}
+	ref := mustParseNamed(t, "my.registry/production/my-app:0.1.0")
+
+	plan, err := PlanFixup(context.Background(), b, ref, reg, WithAutoBundleUpdate(), WithLocalImageSource(source))
+	assert.NilError(t, err)
+	assert.Equal(t, len(plan.Images), 1)
+	assert.Equal(t, plan.Images[0].Strategy, FixupStrategyPushLocalImage)
+	assert.DeepEqual(t, plan.Images[0].Descriptor, invocation)
+	// The local content is copied, it can not be mounted from another repository
+	manifestBytes, err := content.ReadBlob(context.Background(), store, invocation)
+	assert.NilError(t, err)
+	var manifest ocischemav1.Manifest
+	assert.NilError(t, json.Unmarshal(manifestBytes, &manifest))
+	assertPlannedActions(t, plan.Images[0], map[string]PlanAction{
+		invocation.Digest.String():         PlanActionCopy,
+		manifest.Config.Digest.String():    PlanActionCopy,
+		manifest.Layers[0].Digest.String(): PlanActionCopy,
+	})
+	assert.Equal(t, plan.BytesToTransfer, invocation.Size+manifest.Config.Size+manifest.Layers[0].Size)
+	// Nothing has been written to the target repository
+	_, ok := reg.get("my.registry/production/my-app", invocation.Digest)
+	assert.Assert(t, !ok)
+
+	// The missing local images are reported by the plan
+	b.InvocationImages[0].Image = "my-app-invoc:missing"
+	_, err = PlanFixup(context.Background(), b, ref, reg, WithAutoBundleUpdate(), WithLocalImageSource(source))
+	assert.ErrorContains(t, err, "failed to resolve local image my-app-invoc:missing")
+}
+
+func readTestManifest(t *testing.T, reg *memoryRegistry, repo string, desc ocischemav1.Descriptor) ocischemav1.Manifest {
+	t.Helper()
+	data, ok := reg.get(repo, desc.Digest)
+	assert.Assert(t, ok)
+	var manifest ocischemav1.Manifest
+	assert.NilError(t, json.Unmarshal(data, &manifest))
+	return manifest
+}
+
+func assertPlannedActions(t *testing.T, imagePlan ImageFixupPlan, expected map[string]PlanAction) {
+	t.Helper()
+	actions := map[string]PlanAction{}
+	for _, d := range imagePlan.Descriptors {
+		actions[d.Digest.String()] = d.Action
+	}
+	assert.DeepEqual(t, actions, expected)
+}
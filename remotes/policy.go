@@ -0,0 +1,293 @@
+package remotes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/cnabio/cnab-go/bundle"
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/containerd/platforms"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.yaml.in/yaml/v2"
+)
+
+// Policy restricts what FixupBundle is allowed to relocate into the target repository. The zero value allows
+// everything. Registry and repository patterns are matched with path.Match, repositories by their full name like
+// "docker.io/library/*".
+type Policy struct {
+	// AllowedRegistries lists the registries the images can be copied from, all of them if empty
+	AllowedRegistries []string `json:"allowedRegistries,omitempty" yaml:"allowedRegistries,omitempty"`
+	// DeniedRegistries lists the registries the images cannot be copied from, even if they are allowed
+	DeniedRegistries []string `json:"deniedRegistries,omitempty" yaml:"deniedRegistries,omitempty"`
+	// AllowedRepositories lists the repositories the images can be copied from, all of them if empty
+	AllowedRepositories []string `json:"allowedRepositories,omitempty" yaml:"allowedRepositories,omitempty"`
+	// DeniedRepositories lists the repositories the images cannot be copied from, even if they are allowed
+	DeniedRepositories []string `json:"deniedRepositories,omitempty" yaml:"deniedRepositories,omitempty"`
+	// RequireDigest requires the bundle images to be pinned, with a digested reference or a content digest
+	RequireDigest bool `json:"requireDigest,omitempty" yaml:"requireDigest,omitempty"`
+	// MaxImageSize caps the size in bytes of the manifests, configs and layers of an image, after platform filtering
+	MaxImageSize int64 `json:"maxImageSize,omitempty" yaml:"maxImageSize,omitempty"`
+	// MaxLayers caps the number of layers of each image manifest
+	MaxLayers int `json:"maxLayers,omitempty" yaml:"maxLayers,omitempty"`
+	// ForbidForeignLayers forbids the layers which are not distributed by the registry
+	ForbidForeignLayers bool `json:"forbidForeignLayers,omitempty" yaml:"forbidForeignLayers,omitempty"`
+	// ForbidLocalPush forbids pushing the images from the docker daemon, or from the local image source
+	ForbidLocalPush bool `json:"forbidLocalPush,omitempty" yaml:"forbidLocalPush,omitempty"`
+}
+
+// PolicyViolation is a rule of the policy broken by an image of the bundle
+type PolicyViolation struct {
+	// Image is the name of the image in the bundle, like "InvocationImage" or the component name
+	Image   string `json:"image"`
+	Message string `json:"message"`
+}
+
+// PolicyViolationError is returned by FixupBundle when images of the bundle violate the policy. Nothing has been copied.
+type PolicyViolationError struct {
+	// Reference is the target reference of the fixup
+	Reference  string
+	Violations []PolicyViolation
+}
+
+func (e *PolicyViolationError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = fmt.Sprintf("%s: %s", v.Image, v.Message)
+	}
+	return fmt.Sprintf("bundle %s violates the fixup policy: %s", e.Reference, strings.Join(messages, "; "))
+}
+
+// LoadPolicy reads a policy from a YAML or JSON file
+func LoadPolicy(file string) (*Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := ParsePolicy(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy %q: %s", file, err)
+	}
+	return policy, nil
+}
+
+// ParsePolicy parses a YAML or JSON policy. Unknown fields are rejected, so a misspelled rule is not silently ignored.
+func ParsePolicy(data []byte) (*Policy, error) {
+	var policy Policy
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&policy); err != nil {
+			return nil, err
+		}
+	} else if err := yaml.UnmarshalStrict(data, &policy); err != nil {
+		return nil, err
+	}
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func (p *Policy) validate() error {
+	for _, patterns := range [][]string{p.AllowedRegistries, p.DeniedRegistries, p.AllowedRepositories, p.DeniedRepositories} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q: %s", pattern, err)
+			}
+		}
+	}
+	if p.MaxImageSize < 0 {
+		return fmt.Errorf("invalid max image size %d", p.MaxImageSize)
+	}
+	if p.MaxLayers < 0 {
+		return fmt.Errorf("invalid max layers %d", p.MaxLayers)
+	}
+	return nil
+}
+
+// checkSource returns the violations of the registry and repository rules by the repository an image is copied from
+func (p *Policy) checkSource(source reference.Named) []string {
+	var violations []string
+	registry := reference.Domain(source)
+	if matchesAny(p.DeniedRegistries, registry) {
+		violations = append(violations, fmt.Sprintf("registry %q is denied", registry))
+	} else if len(p.AllowedRegistries) > 0 && !matchesAny(p.AllowedRegistries, registry) {
+		violations = append(violations, fmt.Sprintf("registry %q is not allowed", registry))
+	}
+	repository := source.Name()
+	if matchesAny(p.DeniedRepositories, repository) {
+		violations = append(violations, fmt.Sprintf("repository %q is denied", repository))
+	} else if len(p.AllowedRepositories) > 0 && !matchesAny(p.AllowedRepositories, repository) {
+		violations = append(violations, fmt.Sprintf("repository %q is not allowed", repository))
+	}
+	return violations
+}
+
+// inspectsContent returns true if the rules of the policy require walking the manifest tree of the images
+func (p *Policy) inspectsContent() bool {
+	return p.MaxImageSize > 0 || p.MaxLayers > 0 || p.ForbidForeignLayers
+}
+
+func matchesAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPolicy evaluates the policy against all the images of the bundle, before anything is copied, and returns all
+// the violations at once
+func checkPolicy(ctx context.Context, cfg fixupConfig, fixups []*imageFixup) error {
+	if cfg.policy == nil {
+		return nil
+	}
+	// The images are resolved the same way as the fixup does, without pushing anything
+	cfg.dryRun = true
+	var violations []PolicyViolation
+	for _, f := range fixups {
+		messages, err := checkImagePolicy(ctx, f.name, f.baseImage, cfg, f.platformFilter)
+		if err != nil {
+			return err
+		}
+		for _, message := range messages {
+			violations = append(violations, PolicyViolation{Image: f.name, Message: message})
+		}
+	}
+	if len(violations) > 0 {
+		return &PolicyViolationError{Reference: cfg.targetRef.String(), Violations: violations}
+	}
+	return nil
+}
+
+func checkImagePolicy(ctx context.Context, name string, baseImage bundle.BaseImage, cfg fixupConfig, platformFilter platforms.Matcher) ([]string, error) {
+	policy := cfg.policy
+	var violations []string
+	if policy.RequireDigest && !isDigestPinned(baseImage) {
+		violations = append(violations, fmt.Sprintf("image %q is not pinned to a digest", baseImage.Image))
+	}
+
+	sourceImage := baseImage
+	if relocatedBaseImage, ok := cfg.relocationMap[baseImage.Image]; ok {
+		sourceImage.Image = relocatedBaseImage
+	}
+	ctx = withMutedContext(ctx)
+	fixupInfo, pushed, err := fixupBaseImage(ctx, name, &sourceImage, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if pushed {
+		if policy.ForbidLocalPush {
+			violations = append(violations, fmt.Sprintf("image %q would be pushed from the docker daemon", baseImage.Image))
+		}
+		return violations, nil
+	}
+
+	var sourceFetcher *sourceFetcherWithLocalData
+	if fixupInfo.localSource != nil {
+		if policy.ForbidLocalPush {
+			violations = append(violations, fmt.Sprintf("image %q would be pushed from the local image source", baseImage.Image))
+		}
+		if !policy.inspectsContent() {
+			return violations, nil
+		}
+		sourceFetcher = newSourceFetcherWithLocalData(fixupInfo.localSource)
+	} else {
+		if fixupInfo.sourceRef.Name() == fixupInfo.targetRepo.Name() {
+			// Nothing is relocated
+			return violations, nil
+		}
+		violations = append(violations, policy.checkSource(fixupInfo.sourceRef)...)
+		if !policy.inspectsContent() {
+			return violations, nil
+		}
+		if sourceFetcher, err = makeSourceFetcher(ctx, cfg.resolver, fixupInfo.sourceRef.Name()); err != nil {
+			return nil, err
+		}
+	}
+	if err := fixupPlatforms(ctx, &baseImage, cfg.relocationMap, &fixupInfo, sourceFetcher, platformFilter); err != nil {
+		return nil, err
+	}
+	stats := imageStats{
+		children: images.ChildrenHandler(&imageContentProvider{sourceFetcher}),
+		measured: map[digest.Digest]struct{}{},
+	}
+	if err := stats.measure(ctx, fixupInfo.resolvedDescriptor); err != nil {
+		return nil, err
+	}
+	if policy.MaxImageSize > 0 && stats.size > policy.MaxImageSize {
+		violations = append(violations, fmt.Sprintf("image %q size %d exceeds the maximum of %d bytes", baseImage.Image, stats.size, policy.MaxImageSize))
+	}
+	if policy.MaxLayers > 0 && stats.maxLayers > policy.MaxLayers {
+		violations = append(violations, fmt.Sprintf("image %q has %d layers, more than the maximum of %d", baseImage.Image, stats.maxLayers, policy.MaxLayers))
+	}
+	if policy.ForbidForeignLayers {
+		for _, layer := range stats.foreignLayers {
+			violations = append(violations, fmt.Sprintf("image %q has a foreign layer %s", baseImage.Image, layer))
+		}
+	}
+	return violations, nil
+}
+
+// isDigestPinned returns true if the image reference is digested, or if the bundle declares the image content digest
+func isDigestPinned(baseImage bundle.BaseImage) bool {
+	if baseImage.Digest != "" {
+		return true
+	}
+	named, err := reference.ParseNormalizedNamed(baseImage.Image)
+	if err != nil {
+		return false
+	}
+	_, ok := named.(reference.Digested)
+	return ok
+}
+
+// imageStats walks the manifest tree of an image to measure the content the fixup would copy
+type imageStats struct {
+	children      images.HandlerFunc
+	measured      map[digest.Digest]struct{}
+	size          int64
+	maxLayers     int
+	foreignLayers []digest.Digest
+}
+
+func (s *imageStats) measure(ctx context.Context, desc ocischemav1.Descriptor) error {
+	if _, ok := s.measured[desc.Digest]; ok {
+		return nil
+	}
+	s.measured[desc.Digest] = struct{}{}
+	if len(desc.URLs) > 0 || images.IsNonDistributable(desc.MediaType) {
+		// foreign layers are not distributed by the registry, nor copied
+		s.foreignLayers = append(s.foreignLayers, desc.Digest)
+		return nil
+	}
+	s.size += desc.Size
+
+	children, err := s.children.Handle(ctx, desc)
+	if err != nil {
+		return err
+	}
+	if images.IsManifestType(desc.MediaType) {
+		layers := 0
+		for _, c := range children {
+			if images.IsLayerType(c.MediaType) {
+				layers++
+			}
+		}
+		s.maxLayers = max(s.maxLayers, layers)
+	}
+	for _, c := range children {
+		if err := s.measure(ctx, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
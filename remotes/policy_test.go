@@ -0,0 +1,124 @@
+package remotes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/cnabio/cnab-go/bundle"
+	"gotest.tools/v3/assert"
+)
+
+func TestFixupPolicy(t *testing.T) {
+	reg := newMemoryRegistry()
+	invocationImage := pushTestImage(t, reg, "my.registry/build/my-app-invoc", "my-app-invoc")
+	serviceImage := pushTestImage(t, reg, "other.registry/library/my-service", "my-service")
+	serviceData, _ := reg.get("other.registry/library/my-service", serviceImage.Digest)
+	reg.put("other.registry/library/my-service", serviceImage, serviceData, "1.0")
+	invocationManifest := readTestManifest(t, reg, "my.registry/build/my-app-invoc", invocationImage)
+	invocationSize := invocationImage.Size + invocationManifest.Config.Size + invocationManifest.Layers[0].Size
+
+	newBundle := func() *bundle.Bundle {
+		return &bundle.Bundle{
+			SchemaVersion: "v1.0.0",
+			Name:          "my-app",
+			Version:       "0.1.0",
+			InvocationImages: []bundle.InvocationImage{
+				{BaseImage: bundle.BaseImage{Image: "my.registry/build/my-app-invoc@" + invocationImage.Digest.String(), ImageType: "oci"}},
+			},
+			Images: map[string]bundle.Image{
+				"local":      {BaseImage: bundle.BaseImage{Image: "my-local-image:dev", ImageType: "oci"}},
+				"my-service": {BaseImage: bundle.BaseImage{Image: "other.registry/library/my-service:1.0", ImageType: "oci"}},
+			},
+		}
+	}
+	ref := mustParseNamed(t, "my.registry/production/my-app:0.1.0")
+	policy := &Policy{
+		AllowedRegistries:  []string{"*.registry"},
+		DeniedRepositories: []string{"other.registry/library/*"},
+		RequireDigest:      true,
+		MaxImageSize:       invocationSize - 1,
+		ForbidLocalPush:    true,
+	}
+
+	// All the violations are reported at once, before anything is copied
+	for _, fixup := range []func(*bundle.Bundle, ...FixupOption) error{
+		func(b *bundle.Bundle, opts ...FixupOption) error {
+			_, err := FixupBundle(context.Background(), b, ref, reg, opts...)
+			return err
+		},
+		func(b *bundle.Bundle, opts ...FixupOption) error {
+			_, err := PlanFixup(context.Background(), b, ref, reg, opts...)
+			return err
+		},
+	} {
+		imageClient := newMockImageClient()
+		err := fixup(newBundle(), WithAutoBundleUpdate(), WithPushImages(imageClient, nil), WithPolicy(policy))
+		var violationErr *PolicyViolationError
+		assert.Assert(t, errors.As(err, &violationErr), "unexpected error %v", err)
+		assert.DeepEqual(t, violationErr.Violations, []PolicyViolation{
+			{Image: "InvocationImage", Message: fmt.Sprintf(`image "my.registry/build/my-app-invoc@%s" size %d exceeds the maximum of %d bytes`,
+				invocationImage.Digest, invocationSize, invocationSize-1)},
+			{Image: "local", Message: `image "my-local-image:dev" is not pinned to a digest`},
+			{Image: "local", Message: `image "my-local-image:dev" would be pushed from the docker daemon`},
+			{Image: "my-service", Message: `image "other.registry/library/my-service:1.0" is not pinned to a digest`},
+			{Image: "my-service", Message: `repository "other.registry/library/my-service" is denied`},
+		})
+		assert.Equal(t, imageClient.pushedImages, 0)
+		assert.Equal(t, len(reg.repository("my.registry/production/my-app").content), 0)
+	}
+
+	// The images allowed by the policy are fixed up
+	b := newBundle()
+	delete(b.Images, "local")
+	_, err := FixupBundle(context.Background(), b, ref, reg, WithAutoBundleUpdate(), WithPolicy(&Policy{
+		AllowedRegistries: []string{"my.registry", "other.registry"},
+		MaxImageSize:      invocationSize,
+		MaxLayers:         1,
+	}))
+	assert.NilError(t, err)
+
+	_, err = FixupBundle(context.Background(), newBundle(), ref, reg, WithPolicy(&Policy{DeniedRegistries: []string{"["}}))
+	assert.ErrorContains(t, err, "invalid policy: invalid pattern")
+}
+
+func TestParsePolicy(t *testing.T) {
+	expected := &Policy{
+		AllowedRegistries:   []string{"my.registry"},
+		DeniedRepositories:  []string{"my.registry/untrusted/*"},
+		RequireDigest:       true,
+		MaxImageSize:        1 << 30,
+		MaxLayers:           64,
+		ForbidForeignLayers: true,
+	}
+	policy, err := ParsePolicy([]byte(`
+allowedRegistries:
+  - my.registry
+deniedRepositories: ["my.registry/untrusted/*"]
+requireDigest: true
+maxImageSize: 1073741824
+maxLayers: 64
+forbidForeignLayers: true
+`))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, policy, expected)
+
+	policy, err = ParsePolicy([]byte(`{
+	"allowedRegistries": ["my.registry"],
+	"deniedRepositories": ["my.registry/untrusted/*"],
+	"requireDigest": true,
+	"maxImageSize": 1073741824,
+	"maxLayers": 64,
+	"forbidForeignLayers": true
+}`))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, policy, expected)
+
+	_, err = ParsePolicy([]byte(`allowedRegistry: my.registry`))
+	assert.ErrorContains(t, err, "allowedRegistry")
+	_, err = ParsePolicy([]byte(`{"maxLayer": 3}`))
+	assert.ErrorContains(t, err, "maxLayer")
+	_, err = ParsePolicy([]byte(`maxLayers: -1`))
+	assert.ErrorContains(t, err, "invalid max layers -1")
+}
@@ -2,6 +2,7 @@ package remotes
 
 import (
 	"context"
+	"crypto"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -20,9 +21,67 @@ import (
 	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
+// pullConfig defines the input required for a Pull operation
+type pullConfig struct {
+	retryPolicy RetryPolicy
+	strict      bool
+	trustedKeys []crypto.PublicKey
+}
+
+// PullOption is a helper for configuring a Pull
+type PullOption func(*pullConfig) error
+
+func newPullConfig(options ...PullOption) (pullConfig, error) {
+	var cfg pullConfig
+	for _, opt := range options {
+		if err := opt(&cfg); err != nil {
+			return pullConfig{}, err
+		}
+	}
+	return cfg, nil
+}
+
+// WithPullRetryPolicy retries the fetches failing with a transient error, like a 502, a 429 or a connection reset,
+// according to the given policy
+func WithPullRetryPolicy(policy RetryPolicy) PullOption {
+	return func(cfg *pullConfig) error {
+		cfg.retryPolicy = policy
+		return nil
+	}
+}
+
+// WithStrictPull checks that the pulled bundle.json matches the bundle index: the runtime version, and the digest,
+// size and media type of every invocation and component image. An *IntegrityError listing every inconsistency is
+// returned otherwise.
+func WithStrictPull() PullOption {
+	return func(cfg *pullConfig) error {
+		cfg.strict = true
+		return nil
+	}
+}
+
+// WithSignatureVerification checks, before returning the bundle, that it has been signed with Sign by one of the
+// given ed25519 or ECDSA public keys. The signature must cover both the pulled index and the canonical bundle.json,
+// otherwise an error wrapping ErrSignatureVerification is returned.
+func WithSignatureVerification(keys ...crypto.PublicKey) PullOption {
+	return func(cfg *pullConfig) error {
+		if len(keys) == 0 {
+			return errors.New("at least one public key is required to verify the bundle signature")
+		}
+		cfg.trustedKeys = keys
+		return nil
+	}
+}
+
 // Pull pulls a bundle from an OCI Image Index manifest
-func Pull(ctx context.Context, ref reference.Named, resolver remotes.Resolver) (*bundle.Bundle, relocation.ImageRelocationMap, digest.Digest, error) {
+func Pull(ctx context.Context, ref reference.Named, resolver remotes.Resolver, opts ...PullOption) (*bundle.Bundle, relocation.ImageRelocationMap, digest.Digest, error) {
 	log.G(ctx).Debugf("Pulling CNAB Bundle %s", ref)
+	cfg, err := newPullConfig(opts...)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	ctx = withRetryPolicy(ctx, cfg.retryPolicy)
+	resolver = newCachingResolver(resolver)
 	index, descriptor, err := getIndex(ctx, ref, resolver)
 	if err != nil {
 		return nil, nil, "", err
@@ -31,6 +90,16 @@ func Pull(ctx context.Context, ref reference.Named, resolver remotes.Resolver) (
 	if err != nil {
 		return nil, nil, "", err
 	}
+	if len(cfg.trustedKeys) > 0 {
+		if err := verifyBundleSignature(ctx, ref, resolver, descriptor.Digest, b, cfg.trustedKeys); err != nil {
+			return nil, nil, "", err
+		}
+	}
+	if cfg.strict {
+		if inconsistencies := checkBundleIntegrity(index, b); len(inconsistencies) > 0 {
+			return nil, nil, "", &IntegrityError{Reference: ref.String(), Inconsistencies: inconsistencies}
+		}
+	}
 	relocationMap, err := converter.GenerateRelocationMap(&index, b, ref)
 	if err != nil {
 		return nil, nil, "", err
@@ -41,33 +110,39 @@ func Pull(ctx context.Context, ref reference.Named, resolver remotes.Resolver) (
 }
 
 func getIndex(ctx context.Context, ref auth.Scope, resolver remotes.Resolver) (ocischemav1.Index, ocischemav1.Descriptor, error) {
+	index, indexDescriptor, _, err := getIndexWithPayload(ctx, ref, resolver)
+	return index, indexDescriptor, err
+}
+
+// getIndexWithPayload returns the bundle index along with its raw payload, so it can be pushed again without changing its digest
+func getIndexWithPayload(ctx context.Context, ref auth.Scope, resolver remotes.Resolver) (ocischemav1.Index, ocischemav1.Descriptor, []byte, error) {
 	logger := log.G(ctx)
 
 	logger.Debug("Getting OCI Index Descriptor")
-	resolvedRef, indexDescriptor, err := resolver.Resolve(withMutedContext(ctx), ref.String())
+	resolvedRef, indexDescriptor, err := resolve(withMutedContext(ctx), resolver, ref.String())
 	if err != nil {
 		if errors.Is(err, errdefs.ErrNotFound) {
-			return ocischemav1.Index{}, ocischemav1.Descriptor{}, err
+			return ocischemav1.Index{}, ocischemav1.Descriptor{}, nil, err
 		}
-		return ocischemav1.Index{}, ocischemav1.Descriptor{}, fmt.Errorf("failed to resolve bundle manifest %q: %s", ref, err)
+		return ocischemav1.Index{}, ocischemav1.Descriptor{}, nil, fmt.Errorf("failed to resolve bundle manifest %q: %s", ref, err)
 	}
 	if indexDescriptor.MediaType != ocischemav1.MediaTypeImageIndex && indexDescriptor.MediaType != images.MediaTypeDockerSchema2ManifestList {
-		return ocischemav1.Index{}, ocischemav1.Descriptor{}, fmt.Errorf("invalid media type %q for bundle manifest", indexDescriptor.MediaType)
+		return ocischemav1.Index{}, ocischemav1.Descriptor{}, nil, fmt.Errorf("invalid media type %q for bundle manifest", indexDescriptor.MediaType)
 	}
 	logPayload(logger, indexDescriptor)
 
 	logger.Debugf("Fetching OCI Index %s", indexDescriptor.Digest)
 	indexPayload, err := pullPayload(ctx, resolver, resolvedRef, indexDescriptor)
 	if err != nil {
-		return ocischemav1.Index{}, ocischemav1.Descriptor{}, fmt.Errorf("failed to pull bundle manifest %q: %s", ref, err)
+		return ocischemav1.Index{}, ocischemav1.Descriptor{}, nil, fmt.Errorf("failed to pull bundle manifest %q: %s", ref, err)
 	}
 	var index ocischemav1.Index
 	if err := json.Unmarshal(indexPayload, &index); err != nil {
-		return ocischemav1.Index{}, ocischemav1.Descriptor{}, fmt.Errorf("failed to pull bundle manifest %q: %s", ref, err)
+		return ocischemav1.Index{}, ocischemav1.Descriptor{}, nil, fmt.Errorf("failed to pull bundle manifest %q: %s", ref, err)
 	}
 	logPayload(logger, index)
 
-	return index, indexDescriptor, nil
+	return index, indexDescriptor, indexPayload, nil
 }
 
 func getBundle(ctx context.Context, ref reference.Named, resolver remotes.Resolver, index ocischemav1.Index) (*bundle.Bundle, error) {
@@ -129,15 +204,19 @@ func getConfigManifest(ctx context.Context, ref reference.Named, repoOnly refere
 func getBundleConfig(ctx context.Context, ref reference.Named, repoOnly reference.Named, resolver remotes.Resolver, manifest ocischemav1.Manifest) (*bundle.Bundle, error) {
 	logger := log.G(ctx)
 
-	logger.Debugf("Fetching Bundle %s", manifest.Config.Digest)
-	configRef, err := reference.WithDigest(repoOnly, manifest.Config.Digest)
+	configDescriptor, err := converter.GetBundleConfigDescriptor(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pull bundle %q: %s", ref, err)
+	}
+	logger.Debugf("Fetching Bundle %s", configDescriptor.Digest)
+	configRef, err := reference.WithDigest(repoOnly, configDescriptor.Digest)
 	if err != nil {
 		return nil, fmt.Errorf("invalid bundle reference name %q: %s", ref, err)
 	}
 	configPayload, err := pullPayload(ctx, resolver, configRef.String(), ocischemav1.Descriptor{
-		Digest:    manifest.Config.Digest,
-		MediaType: manifest.Config.MediaType,
-		Size:      manifest.Config.Size,
+		Digest:    configDescriptor.Digest,
+		MediaType: configDescriptor.MediaType,
+		Size:      configDescriptor.Size,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to pull bundle %q: %s", ref, err)
@@ -152,6 +231,16 @@ func getBundleConfig(ctx context.Context, ref reference.Named, repoOnly referenc
 }
 
 func pullPayload(ctx context.Context, resolver remotes.Resolver, reference string, descriptor ocischemav1.Descriptor) ([]byte, error) {
+	var result []byte
+	err := retry(ctx, fmt.Sprintf("fetch %s", descriptor.Digest), func() error {
+		var err error
+		result, err = pullPayloadOnce(ctx, resolver, reference, descriptor)
+		return err
+	})
+	return result, err
+}
+
+func pullPayloadOnce(ctx context.Context, resolver remotes.Resolver, reference string, descriptor ocischemav1.Descriptor) ([]byte, error) {
 	ctx = withMutedContext(ctx)
 	fetcher, err := resolver.Fetcher(ctx, reference)
 	if err != nil {
@@ -4,12 +4,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"testing"
 
+	"github.com/cnabio/cnab-go/bundle"
+	"github.com/cnabio/cnab-to-oci/relocation"
 	"github.com/cnabio/cnab-to-oci/tests"
 	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
 	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"gotest.tools/v3/assert"
 )
@@ -195,3 +199,55 @@ func createExampleResolver() *mockResolver {
 		},
 	}
 }
+
+func TestStrictPull(t *testing.T) {
+	reg := newMemoryRegistry()
+	ref := mustParseNamed(t, "my.registry/namespace/my-app:0.1.0")
+	b, _ := pushTestBundle(t, reg, ref)
+
+	pulled, _, _, err := Pull(context.Background(), ref, reg, WithStrictPull())
+	assert.NilError(t, err)
+	assert.DeepEqual(t, pulled, b)
+
+	// Push a tampered bundle.json, and swap it into the original index
+	tampered := *b
+	tampered.SchemaVersion = "v1.0.1"
+	service := b.Images["my-service"]
+	service.Size++
+	ghost := service
+	ghost.Image = "my.registry/namespace/ghost"
+	tampered.Images = map[string]bundle.Image{"my-service": service, "ghost": ghost}
+	tamperedRef := mustParseNamed(t, "my.registry/namespace/my-app:tampered")
+	_, err = Push(context.Background(), &tampered, relocation.ImageRelocationMap{
+		b.InvocationImages[0].Image: ref.Name() + "@" + b.InvocationImages[0].Digest,
+		service.Image:               ref.Name() + "@" + service.Digest,
+		ghost.Image:                 ref.Name() + "@" + service.Digest,
+	}, tamperedRef, reg, false)
+	assert.NilError(t, err)
+	index, _, err := getIndex(context.Background(), ref, reg)
+	assert.NilError(t, err)
+	tamperedIndex, _, err := getIndex(context.Background(), tamperedRef, reg)
+	assert.NilError(t, err)
+	index.Manifests[0] = tamperedIndex.Manifests[0]
+	indexPayload, err := json.Marshal(index)
+	assert.NilError(t, err)
+	reg.put(ref.Name(), ocischemav1.Descriptor{
+		MediaType: ocischemav1.MediaTypeImageIndex,
+		Digest:    digest.FromBytes(indexPayload),
+		Size:      int64(len(indexPayload)),
+	}, indexPayload, "0.1.0")
+
+	// Without strict mode, the tampered bundle is pulled
+	pulled, _, _, err = Pull(context.Background(), ref, reg)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, pulled, &tampered)
+
+	_, _, _, err = Pull(context.Background(), ref, reg, WithStrictPull())
+	var integrityErr *IntegrityError
+	assert.Assert(t, errors.As(err, &integrityErr))
+	assert.DeepEqual(t, integrityErr.Inconsistencies, []string{
+		`index runtime version "v1.0.0" differs from bundle schema version "v1.0.1"`,
+		fmt.Sprintf(`component "my-service" size %d differs from the index size %d`, service.Size, service.Size-1),
+		`component "ghost" has no descriptor in the index`,
+	})
+}
@@ -6,7 +6,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"os"
 
 	"github.com/cnabio/cnab-go/bundle"
 	"github.com/cnabio/cnab-to-oci/converter"
@@ -17,9 +16,6 @@ import (
 	"github.com/containerd/errdefs"
 	"github.com/containerd/log"
 	"github.com/distribution/reference"
-	"github.com/docker/cli/cli/config"
-	"github.com/docker/cli/cli/config/credentials"
-	configtypes "github.com/docker/cli/cli/config/types"
 	"github.com/moby/moby/api/pkg/authconfig"
 	registrytypes "github.com/moby/moby/api/types/registry"
 	"github.com/moby/moby/client"
@@ -31,6 +27,63 @@ import (
 // ManifestOption is a callback used to customize a manifest before pushing it
 type ManifestOption func(*ocischemav1.Index) error
 
+// PushOption is an option for PushWithOptions. A ManifestOption is a PushOption.
+type PushOption interface {
+	applyPushOption(*pushSettings)
+}
+
+type pushSettings struct {
+	manifestOptions      []ManifestOption
+	ociArtifactManifests bool
+	retryPolicy          RetryPolicy
+	fallbackCallback     func(fallback string)
+}
+
+func newPushSettings(options ...PushOption) pushSettings {
+	settings := pushSettings{
+		fallbackCallback: func(string) {},
+	}
+	for _, opt := range options {
+		opt.applyPushOption(&settings)
+	}
+	return settings
+}
+
+func (o ManifestOption) applyPushOption(settings *pushSettings) {
+	settings.manifestOptions = append(settings.manifestOptions, o)
+}
+
+type pushOptionFunc func(*pushSettings)
+
+func (f pushOptionFunc) applyPushOption(settings *pushSettings) {
+	f(settings)
+}
+
+// WithOCIArtifactManifests pushes the bundle using the OCI 1.1 artifact fields: the index has the CNAB artifact
+// type, and the config manifest has the CNAB config artifact type, an empty config and the bundle config as its single
+// layer. When fallbacks are allowed, the bundle is pushed in the previous format if the registry rejects them.
+func WithOCIArtifactManifests() PushOption {
+	return pushOptionFunc(func(settings *pushSettings) {
+		settings.ociArtifactManifests = true
+	})
+}
+
+// WithPushRetryPolicy retries the pushes failing with a transient error, like a 502, a 429 or a connection reset,
+// according to the given policy
+func WithPushRetryPolicy(policy RetryPolicy) PushOption {
+	return pushOptionFunc(func(settings *pushSettings) {
+		settings.retryPolicy = policy
+	})
+}
+
+// WithPushFallbackCallback specifies a callback called with a description of each compatibility fallback used
+// while pushing the bundle
+func WithPushFallbackCallback(callback func(fallback string)) PushOption {
+	return pushOptionFunc(func(settings *pushSettings) {
+		settings.fallbackCallback = callback
+	})
+}
+
 // Push pushes a bundle as an OCI Image Index manifest
 func Push(ctx context.Context,
 	b *bundle.Bundle,
@@ -39,14 +92,33 @@ func Push(ctx context.Context,
 	resolver remotes.Resolver,
 	allowFallbacks bool,
 	options ...ManifestOption) (ocischemav1.Descriptor, error) {
+	pushOptions := make([]PushOption, len(options))
+	for i, opt := range options {
+		pushOptions[i] = opt
+	}
+	return PushWithOptions(ctx, b, relocationMap, ref, resolver, allowFallbacks, pushOptions...)
+}
+
+// PushWithOptions pushes a bundle as an OCI Image Index manifest, like Push, with the push settings like the OCI 1.1
+// artifact manifests or the retry policy
+func PushWithOptions(ctx context.Context,
+	b *bundle.Bundle,
+	relocationMap relocation.ImageRelocationMap,
+	ref reference.Named,
+	resolver remotes.Resolver,
+	allowFallbacks bool,
+	options ...PushOption) (ocischemav1.Descriptor, error) {
 	log.G(ctx).Debugf("Pushing CNAB Bundle %s", ref)
 
-	confManifestDescriptor, err := pushConfig(ctx, b, ref, resolver, allowFallbacks)
+	settings := newPushSettings(options...)
+	resolver = newCachingResolver(resolver)
+	ctx = withRetryPolicy(ctx, settings.retryPolicy)
+	confManifestDescriptor, err := pushConfig(ctx, b, ref, resolver, allowFallbacks, settings)
 	if err != nil {
 		return ocischemav1.Descriptor{}, err
 	}
 
-	indexDescriptor, err := pushIndex(ctx, b, relocationMap, ref, resolver, allowFallbacks, confManifestDescriptor, options...)
+	indexDescriptor, err := pushIndex(ctx, b, relocationMap, ref, resolver, allowFallbacks, confManifestDescriptor, settings)
 	if err != nil {
 		return ocischemav1.Descriptor{}, err
 	}
@@ -59,15 +131,20 @@ func pushConfig(ctx context.Context,
 	b *bundle.Bundle,
 	ref reference.Named, //nolint:interfacer
 	resolver remotes.Resolver,
-	allowFallbacks bool) (ocischemav1.Descriptor, error) {
+	allowFallbacks bool,
+	settings pushSettings) (ocischemav1.Descriptor, error) {
 	logger := log.G(ctx)
 	logger.Debugf("Pushing CNAB Bundle Config")
 
-	bundleConfig, err := converter.PrepareForPush(b)
+	prepare := converter.PrepareForPush
+	if settings.ociArtifactManifests {
+		prepare = converter.PrepareArtifactForPush
+	}
+	bundleConfig, err := prepare(b)
 	if err != nil {
 		return ocischemav1.Descriptor{}, err
 	}
-	confManifestDescriptor, err := pushBundleConfig(ctx, resolver, ref.Name(), bundleConfig, allowFallbacks)
+	confManifestDescriptor, err := pushBundleConfig(ctx, resolver, ref.Name(), bundleConfig, allowFallbacks, settings.fallbackCallback)
 	if err != nil {
 		return ocischemav1.Descriptor{}, fmt.Errorf("error while pushing bundle config manifest: %s", err)
 	}
@@ -77,10 +154,14 @@ func pushConfig(ctx context.Context,
 }
 
 func pushIndex(ctx context.Context, b *bundle.Bundle, relocationMap relocation.ImageRelocationMap, ref reference.Named, resolver remotes.Resolver, allowFallbacks bool,
-	confManifestDescriptor ocischemav1.Descriptor, options ...ManifestOption) (ocischemav1.Descriptor, error) {
+	confManifestDescriptor ocischemav1.Descriptor, settings pushSettings) (ocischemav1.Descriptor, error) {
 	logger := log.G(ctx)
 	logger.Debug("Pushing CNAB Index")
 
+	options := append([]ManifestOption{}, settings.manifestOptions...)
+	if settings.ociArtifactManifests {
+		options = append(options, withArtifactType)
+	}
 	indexDescriptor, indexPayload, err := prepareIndex(b, relocationMap, ref, confManifestDescriptor, options...)
 	if err != nil {
 		return ocischemav1.Descriptor{}, err
@@ -97,8 +178,15 @@ func pushIndex(ctx context.Context, b *bundle.Bundle, relocationMap relocation.I
 			return ocischemav1.Descriptor{}, err
 		}
 		logger.Debugf("Unable to push OCI Index: %v", err)
+		if settings.ociArtifactManifests {
+			// retry without the OCI 1.1 artifact type
+			settings.fallbackCallback("index pushed without the OCI artifact type")
+			settings.ociArtifactManifests = false
+			return pushIndex(ctx, b, relocationMap, ref, resolver, allowFallbacks, confManifestDescriptor, settings)
+		}
 		// retry with a docker manifestlist
-		return pushDockerManifestList(ctx, b, relocationMap, ref, resolver, confManifestDescriptor, options...)
+		settings.fallbackCallback(fmt.Sprintf("index pushed as %s instead of %s", images.MediaTypeDockerSchema2ManifestList, ocischemav1.MediaTypeImageIndex))
+		return pushDockerManifestList(ctx, b, relocationMap, ref, resolver, confManifestDescriptor, settings.manifestOptions...)
 	}
 
 	logger.Debugf("CNAB Index pushed")
@@ -148,6 +236,13 @@ func prepareIndex(b *bundle.Bundle,
 	return indexDescriptor, indexPayload, nil
 }
 
+// withArtifactType sets the OCI 1.1 artifactType field of the index, in addition to the artifact type annotation
+func withArtifactType(ix *ocischemav1.Index) error {
+	ix.MediaType = ocischemav1.MediaTypeImageIndex
+	ix.ArtifactType = converter.ArtifactTypeValue
+	return nil
+}
+
 type ociIndexWrapper struct {
 	ocischemav1.Index
 	MediaType string `json:"mediaType,omitempty"`
@@ -179,11 +274,20 @@ func prepareIndexNonOCI(b *bundle.Bundle,
 	if err != nil {
 		return ocischemav1.Descriptor{}, nil, err
 	}
+	indexDescriptor, indexPayload, err := marshalNonOCIIndex(ix)
+	if err != nil {
+		return ocischemav1.Descriptor{}, nil, fmt.Errorf("invalid bundle manifest %q: %s", ref, err)
+	}
+	return indexDescriptor, indexPayload, nil
+}
+
+// marshalNonOCIIndex serializes an index as a Docker manifest list
+func marshalNonOCIIndex(ix *ocischemav1.Index) (ocischemav1.Descriptor, []byte, error) {
 	w := &ociIndexWrapper{Index: *ix, MediaType: images.MediaTypeDockerSchema2ManifestList}
 	w.SchemaVersion = 2
 	indexPayload, err := json.Marshal(w)
 	if err != nil {
-		return ocischemav1.Descriptor{}, nil, fmt.Errorf("invalid bundle manifest %q: %s", ref, err)
+		return ocischemav1.Descriptor{}, nil, err
 	}
 	indexDescriptor := ocischemav1.Descriptor{
 		Digest:    digest.FromBytes(indexPayload),
@@ -194,6 +298,12 @@ func prepareIndexNonOCI(b *bundle.Bundle,
 }
 
 func pushPayload(ctx context.Context, resolver remotes.Resolver, reference string, descriptor ocischemav1.Descriptor, payload []byte) error {
+	return retry(ctx, fmt.Sprintf("push %s", descriptor.Digest), func() error {
+		return pushPayloadOnce(ctx, resolver, reference, descriptor, payload)
+	})
+}
+
+func pushPayloadOnce(ctx context.Context, resolver remotes.Resolver, reference string, descriptor ocischemav1.Descriptor, payload []byte) error {
 	ctx = withMutedContext(ctx)
 	pusher, err := resolver.Pusher(ctx, reference)
 	if err != nil {
@@ -220,41 +330,63 @@ func pushPayload(ctx context.Context, resolver remotes.Resolver, reference strin
 	return err
 }
 
-func pushBundleConfig(ctx context.Context, resolver remotes.Resolver, reference string, bundleConfig *converter.PreparedBundleConfig, allowFallbacks bool) (ocischemav1.Descriptor, error) {
+func pushBundleConfig(ctx context.Context, resolver remotes.Resolver, reference string, bundleConfig *converter.PreparedBundleConfig,
+	allowFallbacks bool, fallbackCallback func(string)) (ocischemav1.Descriptor, error) {
+	if bundleConfig.EmptyConfig {
+		emptyConfigDescriptor := ocischemav1.Descriptor{
+			MediaType: ocischemav1.DescriptorEmptyJSON.MediaType,
+			Digest:    ocischemav1.DescriptorEmptyJSON.Digest,
+			Size:      ocischemav1.DescriptorEmptyJSON.Size,
+		}
+		if d, err := pushBundleConfigDescriptor(ctx, "Empty Config", resolver, reference,
+			emptyConfigDescriptor, ocischemav1.DescriptorEmptyJSON.Data, bundleConfig, allowFallbacks, fallbackCallback); err != nil {
+			return d, err
+		}
+	}
 	if d, err := pushBundleConfigDescriptor(ctx, "Config", resolver, reference,
-		bundleConfig.ConfigBlobDescriptor, bundleConfig.ConfigBlob, bundleConfig.Fallback, allowFallbacks); err != nil {
+		bundleConfig.ConfigBlobDescriptor, bundleConfig.ConfigBlob, bundleConfig, allowFallbacks, fallbackCallback); err != nil {
 		return d, err
 	}
 	return pushBundleConfigDescriptor(ctx, "Config Manifest", resolver, reference,
-		bundleConfig.ManifestDescriptor, bundleConfig.Manifest, bundleConfig.Fallback, allowFallbacks)
+		bundleConfig.ManifestDescriptor, bundleConfig.Manifest, bundleConfig, allowFallbacks, fallbackCallback)
 }
 
 func pushBundleConfigDescriptor(ctx context.Context, name string, resolver remotes.Resolver, reference string,
-	descriptor ocischemav1.Descriptor, payload []byte, fallback *converter.PreparedBundleConfig, allowFallbacks bool) (ocischemav1.Descriptor, error) {
+	descriptor ocischemav1.Descriptor, payload []byte, bundleConfig *converter.PreparedBundleConfig, allowFallbacks bool, fallbackCallback func(string)) (ocischemav1.Descriptor, error) {
 	logger := log.G(ctx)
 	logger.Debugf("Trying to push CNAB Bundle %s", name)
 	logger.Debugf("CNAB Bundle %s Descriptor", name)
 	logPayload(logger, descriptor)
 
 	if err := pushPayload(ctx, resolver, reference, descriptor, payload); err != nil {
-		if allowFallbacks && fallback != nil {
+		if allowFallbacks && bundleConfig.Fallback != nil {
 			logger.Debugf("Failed to push CNAB Bundle %s, trying with a fallback method", name)
-			return pushBundleConfig(ctx, resolver, reference, fallback, allowFallbacks)
+			fallbackCallback(fmt.Sprintf("config pushed as %s instead of %s", describeBundleConfig(bundleConfig.Fallback), describeBundleConfig(bundleConfig)))
+			return pushBundleConfig(ctx, resolver, reference, bundleConfig.Fallback, allowFallbacks, fallbackCallback)
 		}
 		return ocischemav1.Descriptor{}, err
 	}
 	return descriptor, nil
 }
 
-func pushTaggedImage(ctx context.Context, imageClient internal.ImageClient, targetRef reference.Named, out io.Writer) error {
-	authConfig := resolveAuthConfig(targetRef)
+func describeBundleConfig(bundleConfig *converter.PreparedBundleConfig) string {
+	if bundleConfig.EmptyConfig {
+		return fmt.Sprintf("artifact manifest %s", bundleConfig.ManifestDescriptor.MediaType)
+	}
+	return fmt.Sprintf("manifest %s with config %s", bundleConfig.ManifestDescriptor.MediaType, bundleConfig.ConfigBlobDescriptor.MediaType)
+}
+
+func pushTaggedImage(ctx context.Context, imageClient internal.ImageClient, targetRef reference.Named, credentials CredentialProvider, out io.Writer) error {
+	hostName := reference.Domain(targetRef)
+	authConfig, err := registryCredentials(ctx, credentials, hostName)
+	if err != nil {
+		return fmt.Errorf("failed to get the credentials of %s: %s", hostName, err)
+	}
 	encodedAuth, err := authconfig.Encode(registrytypes.AuthConfig{
 		Username:      authConfig.Username,
 		Password:      authConfig.Password,
-		ServerAddress: authConfig.ServerAddress,
-		Auth:          authConfig.Auth,
+		ServerAddress: hostName,
 		IdentityToken: authConfig.IdentityToken,
-		RegistryToken: authConfig.RegistryToken,
 	})
 	if err != nil {
 		return err
@@ -269,31 +401,3 @@ func pushTaggedImage(ctx context.Context, imageClient internal.ImageClient, targ
 	defer reader.Close()
 	return jsonmessage.DisplayJSONMessagesStream(reader, out, 0, false, nil)
 }
-
-func resolveAuthConfig(targetRef reference.Named) configtypes.AuthConfig {
-	cfg := config.LoadDefaultConfigFile(os.Stderr)
-
-	hostName := reference.Domain(targetRef)
-	if hostName == defaultDomain || hostName == legacyDefaultDomain || hostName == defaultRegistryHost {
-		hostName = legacyDefaultDomain
-	}
-
-	configs, err := cfg.GetAllCredentials()
-	if err != nil {
-		return configtypes.AuthConfig{}
-	}
-
-	// See https://github.com/docker/cli/blob/23446275646041f9b598d64c51be24d5d0e49376/cli/config/credentials/file_store.go#L32-L47
-	// We are looking for the hostname in the configuration, and if not we are trying with a pure hostname (so without
-	// http/https).
-	authConfig, ok := configs[hostName]
-	if !ok {
-		for reg, config := range configs {
-			if hostName == credentials.ConvertToHostname(reg) {
-				return config
-			}
-		}
-		return configtypes.AuthConfig{}
-	}
-	return authConfig
-}
@@ -139,6 +139,90 @@ func TestFallbackConfigManifest(t *testing.T) {
 	assert.Equal(t, expectedConfigManifest, pusher.buffers[3].String())
 }
 
+func TestPushOCIArtifactManifests(t *testing.T) {
+	reg := newMemoryRegistry()
+	b := tests.MakeTestBundle()
+	ref, err := reference.ParseNamed("my.registry/namespace/my-app:my-tag")
+	assert.NilError(t, err)
+
+	descriptor, err := PushWithOptions(context.Background(), b, tests.MakeRelocationMap(), ref, reg, false, WithOCIArtifactManifests())
+	assert.NilError(t, err)
+	indexPayload, ok := reg.get("my.registry/namespace/my-app", descriptor.Digest)
+	assert.Assert(t, ok)
+	var index ocischemav1.Index
+	assert.NilError(t, json.Unmarshal(indexPayload, &index))
+	assert.Equal(t, index.MediaType, ocischemav1.MediaTypeImageIndex)
+	assert.Equal(t, index.ArtifactType, converter.ArtifactTypeValue)
+
+	configManifestDescriptor, err := converter.GetBundleConfigManifestDescriptor(&index)
+	assert.NilError(t, err)
+	configManifestPayload, ok := reg.get("my.registry/namespace/my-app", configManifestDescriptor.Digest)
+	assert.Assert(t, ok)
+	var configManifest ocischemav1.Manifest
+	assert.NilError(t, json.Unmarshal(configManifestPayload, &configManifest))
+	assert.Equal(t, configManifest.ArtifactType, converter.CNABConfigMediaType)
+	assert.Equal(t, configManifest.Config.Digest, ocischemav1.DescriptorEmptyJSON.Digest)
+	_, ok = reg.get("my.registry/namespace/my-app", ocischemav1.DescriptorEmptyJSON.Digest)
+	assert.Assert(t, ok)
+
+	// The bundle is read from the config manifest layer
+	pulled, _, _, err := Pull(context.Background(), ref, reg)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, pulled, b)
+}
+
+func TestPushManifestOptions(t *testing.T) {
+	reg := newMemoryRegistry()
+	b := tests.MakeTestBundle()
+	ref, err := reference.ParseNamed("my.registry/namespace/my-app:my-tag")
+	assert.NilError(t, err)
+
+	// Push still takes the manifest options, as they were before the push options
+	options := []ManifestOption{func(index *ocischemav1.Index) error {
+		index.Annotations["io.example.custom"] = "value"
+		return nil
+	}}
+	descriptor, err := Push(context.Background(), b, tests.MakeRelocationMap(), ref, reg, false, options...)
+	assert.NilError(t, err)
+	indexPayload, ok := reg.get("my.registry/namespace/my-app", descriptor.Digest)
+	assert.Assert(t, ok)
+	var index ocischemav1.Index
+	assert.NilError(t, json.Unmarshal(indexPayload, &index))
+	assert.Equal(t, index.Annotations["io.example.custom"], "value")
+}
+
+func TestFallbackOCIArtifactManifests(t *testing.T) {
+	// Reject the artifact config manifest and the index with an artifact type,
+	// so that the bundle is pushed in the previous format
+	pusher := newMockPusher([]error{
+		nil,             // empty config
+		nil,             // bundle config layer
+		errors.New("1"), // artifact config manifest
+		nil,             // bundle config
+		nil,             // config manifest
+		errors.New("2"), // index with artifact type
+		nil,             // index
+	})
+	resolver := &mockResolver{pusher: pusher}
+	b := tests.MakeTestBundle()
+	ref, err := reference.ParseNamed("my.registry/namespace/my-app:my-tag")
+	assert.NilError(t, err)
+
+	var fallbacks []string
+	descriptor, err := PushWithOptions(context.Background(), b, tests.MakeRelocationMap(), ref, resolver, true, WithOCIArtifactManifests(),
+		WithPushFallbackCallback(func(fallback string) {
+			fallbacks = append(fallbacks, fallback)
+		}))
+	assert.NilError(t, err)
+	assert.Equal(t, tests.BundleDigest, descriptor.Digest)
+	assert.Equal(t, ocischemav1.DescriptorEmptyJSON.Digest, pusher.pushedDescriptors[0].Digest)
+	assert.Equal(t, oneLiner(expectedBundleManifest), pusher.buffers[6].String())
+	assert.DeepEqual(t, fallbacks, []string{
+		"config pushed as manifest application/vnd.oci.image.manifest.v1+json with config application/vnd.cnab.config.v1+json instead of artifact manifest application/vnd.oci.image.manifest.v1+json",
+		"index pushed without the OCI artifact type",
+	})
+}
+
 func oneLiner(s string) string {
 	return strings.Replace(strings.Replace(s, " ", "", -1), "\n", "", -1)
 }
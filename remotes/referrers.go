@@ -0,0 +1,272 @@
+package remotes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/log"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocischema "github.com/opencontainers/image-spec/specs-go"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// AttachedBlob is a blob of an artifact attached to a bundle, like a signature, a SBOM or a document
+type AttachedBlob struct {
+	// MediaType is the media type of the blob
+	MediaType string
+	// Title is the optional title of the blob, typically its file name
+	Title string
+	// Data is the content of the blob
+	Data []byte
+}
+
+// attachConfig defines the input required for an Attach operation
+type attachConfig struct {
+	annotations map[string]string
+}
+
+// AttachOption is a helper for configuring an Attach
+type AttachOption func(*attachConfig) error
+
+func newAttachConfig(options ...AttachOption) (attachConfig, error) {
+	var cfg attachConfig
+	for _, opt := range options {
+		if err := opt(&cfg); err != nil {
+			return attachConfig{}, err
+		}
+	}
+	return cfg, nil
+}
+
+// WithAttachAnnotations sets the annotations of the attached artifact manifest
+func WithAttachAnnotations(annotations map[string]string) AttachOption {
+	return func(cfg *attachConfig) error {
+		cfg.annotations = annotations
+		return nil
+	}
+}
+
+// Attach pushes an artifact manifest with the given artifact type and blobs, whose subject is the bundle index
+// referenced by ref. The artifact can then be listed with ListReferrers.
+// For registries without support for the OCI referrers API, the referrers tag schema index is updated.
+func Attach(ctx context.Context, ref reference.Named, resolver remotes.Resolver, artifactType string, blobs []AttachedBlob, opts ...AttachOption) (ocischemav1.Descriptor, error) {
+	logger := log.G(ctx)
+	logger.Debugf("Attaching %s artifact to %s", artifactType, ref)
+
+	if artifactType == "" {
+		return ocischemav1.Descriptor{}, errors.New("artifact type must be set")
+	}
+	cfg, err := newAttachConfig(opts...)
+	if err != nil {
+		return ocischemav1.Descriptor{}, err
+	}
+	subject, err := resolveSubject(ctx, ref, resolver)
+	if err != nil {
+		return ocischemav1.Descriptor{}, err
+	}
+	repoOnly, err := reference.ParseNormalizedNamed(ref.Name())
+	if err != nil {
+		return ocischemav1.Descriptor{}, err
+	}
+
+	manifest := ocischemav1.Manifest{
+		Versioned:    ocischema.Versioned{SchemaVersion: 2},
+		MediaType:    ocischemav1.MediaTypeImageManifest,
+		ArtifactType: artifactType,
+		Config:       ocischemav1.DescriptorEmptyJSON,
+		Layers:       []ocischemav1.Descriptor{},
+		Subject:      &subject,
+		Annotations:  cfg.annotations,
+	}
+	for _, blob := range blobs {
+		desc := ocischemav1.Descriptor{
+			MediaType: blob.MediaType,
+			Digest:    digest.FromBytes(blob.Data),
+			Size:      int64(len(blob.Data)),
+		}
+		logger.Debugf("Pushing blob %s", desc.Digest)
+		if err := pushPayload(ctx, resolver, repoOnly.Name(), desc, blob.Data); err != nil {
+			return ocischemav1.Descriptor{}, fmt.Errorf("failed to push artifact blob %q: %s", blob.Title, err)
+		}
+		if blob.Title != "" {
+			desc.Annotations = map[string]string{ocischemav1.AnnotationTitle: blob.Title}
+		}
+		manifest.Layers = append(manifest.Layers, desc)
+	}
+	if len(manifest.Layers) == 0 {
+		// An artifact without blobs uses the empty descriptor as its single layer
+		manifest.Layers = append(manifest.Layers, ocischemav1.DescriptorEmptyJSON)
+	}
+	emptyConfigDescriptor := ocischemav1.Descriptor{
+		MediaType: ocischemav1.DescriptorEmptyJSON.MediaType,
+		Digest:    ocischemav1.DescriptorEmptyJSON.Digest,
+		Size:      ocischemav1.DescriptorEmptyJSON.Size,
+	}
+	if err := pushPayload(ctx, resolver, repoOnly.Name(), emptyConfigDescriptor, ocischemav1.DescriptorEmptyJSON.Data); err != nil {
+		return ocischemav1.Descriptor{}, fmt.Errorf("failed to push artifact config: %s", err)
+	}
+
+	manifestPayload, err := json.Marshal(manifest)
+	if err != nil {
+		return ocischemav1.Descriptor{}, err
+	}
+	manifestDescriptor := ocischemav1.Descriptor{
+		MediaType: ocischemav1.MediaTypeImageManifest,
+		Digest:    digest.FromBytes(manifestPayload),
+		Size:      int64(len(manifestPayload)),
+	}
+	logger.Debug("Pushing artifact manifest")
+	logPayload(logger, manifest)
+	if err := pushPayload(ctx, resolver, repoOnly.Name(), manifestDescriptor, manifestPayload); err != nil {
+		return ocischemav1.Descriptor{}, fmt.Errorf("failed to push artifact manifest: %s", err)
+	}
+
+	// Registries supporting the referrers API index the manifest by its subject. Otherwise, the artifact is added
+	// to the referrers tag schema index.
+	referrer := manifestDescriptor
+	referrer.ArtifactType = artifactType
+	referrer.Annotations = cfg.annotations
+	if err := ensureReferrerListed(ctx, repoOnly, resolver, subject, referrer); err != nil {
+		return ocischemav1.Descriptor{}, err
+	}
+
+	logger.Debug("Artifact attached")
+	return manifestDescriptor, nil
+}
+
+// ListReferrers lists the manifests referring to the manifest referenced by ref, optionally filtered by artifact type.
+// It uses the OCI referrers API when the registry supports it, and the referrers tag schema otherwise.
+func ListReferrers(ctx context.Context, ref reference.Named, resolver remotes.Resolver, artifactTypes ...string) ([]ocischemav1.Descriptor, error) {
+	log.G(ctx).Debugf("Listing referrers of %s", ref)
+	subject, err := resolveSubject(ctx, ref, resolver)
+	if err != nil {
+		return nil, err
+	}
+	repoOnly, err := reference.ParseNormalizedNamed(ref.Name())
+	if err != nil {
+		return nil, err
+	}
+	return fetchReferrers(ctx, repoOnly, resolver, subject.Digest, artifactTypes...)
+}
+
+func resolveSubject(ctx context.Context, ref reference.Named, resolver remotes.Resolver) (ocischemav1.Descriptor, error) {
+	_, desc, err := resolve(withMutedContext(ctx), resolver, ref.String())
+	if err != nil {
+		if errors.Is(err, errdefs.ErrNotFound) {
+			return ocischemav1.Descriptor{}, err
+		}
+		return ocischemav1.Descriptor{}, fmt.Errorf("failed to resolve %q: %s", ref, err)
+	}
+	return ocischemav1.Descriptor{
+		MediaType: desc.MediaType,
+		Digest:    desc.Digest,
+		Size:      desc.Size,
+	}, nil
+}
+
+func fetchReferrers(ctx context.Context, repoOnly reference.Named, resolver remotes.Resolver, subject digest.Digest, artifactTypes ...string) ([]ocischemav1.Descriptor, error) {
+	fetcher, err := resolver.Fetcher(ctx, repoOnly.Name())
+	if err != nil {
+		return nil, err
+	}
+	if referrersFetcher, ok := fetcher.(remotes.ReferrersFetcher); ok {
+		// The referrers fetcher falls back to the referrers tag schema by itself
+		return referrersFetcher.FetchReferrers(withMutedContext(ctx), subject, remotes.WithReferrerArtifactTypes(artifactTypes...))
+	}
+	index, err := fetchReferrersTagIndex(ctx, repoOnly, resolver, subject)
+	if err != nil {
+		return nil, err
+	}
+	return filterReferrers(index.Manifests, artifactTypes), nil
+}
+
+func filterReferrers(referrers []ocischemav1.Descriptor, artifactTypes []string) []ocischemav1.Descriptor {
+	if len(artifactTypes) == 0 {
+		return referrers
+	}
+	var result []ocischemav1.Descriptor
+	for _, d := range referrers {
+		for _, t := range artifactTypes {
+			if d.ArtifactType == t {
+				result = append(result, d)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// referrersTag returns the referrers tag schema tag of a subject digest
+func referrersTag(subject digest.Digest) string {
+	return fmt.Sprintf("%s-%s", subject.Algorithm(), subject.Encoded())
+}
+
+// fetchReferrersTagIndex fetches the referrers tag schema index of a subject. A missing tag returns an empty index.
+func fetchReferrersTagIndex(ctx context.Context, repoOnly reference.Named, resolver remotes.Resolver, subject digest.Digest) (ocischemav1.Index, error) {
+	emptyIndex := ocischemav1.Index{
+		Versioned: ocischema.Versioned{SchemaVersion: 2},
+		MediaType: ocischemav1.MediaTypeImageIndex,
+		Manifests: []ocischemav1.Descriptor{},
+	}
+	tagRef, err := reference.WithTag(repoOnly, referrersTag(subject))
+	if err != nil {
+		return ocischemav1.Index{}, err
+	}
+	resolvedRef, desc, err := resolve(withMutedContext(ctx), resolver, tagRef.String())
+	if errors.Is(err, errdefs.ErrNotFound) {
+		return emptyIndex, nil
+	}
+	if err != nil {
+		return ocischemav1.Index{}, fmt.Errorf("failed to resolve referrers tag %q: %s", tagRef, err)
+	}
+	payload, err := pullPayload(ctx, resolver, resolvedRef, desc)
+	if err != nil {
+		return ocischemav1.Index{}, fmt.Errorf("failed to pull referrers tag %q: %s", tagRef, err)
+	}
+	var index ocischemav1.Index
+	if err := json.Unmarshal(payload, &index); err != nil {
+		return ocischemav1.Index{}, fmt.Errorf("invalid referrers tag %q: %s", tagRef, err)
+	}
+	return index, nil
+}
+
+func ensureReferrerListed(ctx context.Context, repoOnly reference.Named, resolver remotes.Resolver, subject, referrer ocischemav1.Descriptor) error {
+	referrers, err := fetchReferrers(ctx, repoOnly, resolver, subject.Digest)
+	if err != nil {
+		return fmt.Errorf("failed to list referrers of %s: %s", subject.Digest, err)
+	}
+	for _, d := range referrers {
+		if d.Digest == referrer.Digest {
+			return nil
+		}
+	}
+
+	log.G(ctx).Debugf("Adding %s to the referrers tag schema index of %s", referrer.Digest, subject.Digest)
+	index, err := fetchReferrersTagIndex(ctx, repoOnly, resolver, subject.Digest)
+	if err != nil {
+		return err
+	}
+	index.Manifests = append(index.Manifests, referrer)
+	payload, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	indexDescriptor := ocischemav1.Descriptor{
+		MediaType: ocischemav1.MediaTypeImageIndex,
+		Digest:    digest.FromBytes(payload),
+		Size:      int64(len(payload)),
+	}
+	tagRef, err := reference.WithTag(repoOnly, referrersTag(subject.Digest))
+	if err != nil {
+		return err
+	}
+	if err := pushPayload(ctx, resolver, tagRef.String(), indexDescriptor, payload); err != nil {
+		return fmt.Errorf("failed to push referrers tag %q: %s", tagRef, err)
+	}
+	return nil
+}
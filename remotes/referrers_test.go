@@ -0,0 +1,77 @@
+package remotes
+
+import (
+	"context"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"gotest.tools/v3/assert"
+)
+
+func TestAttachAndListReferrers(t *testing.T) {
+	for _, referrersAPI := range []bool{false, true} {
+		reg := newMemoryRegistry()
+		reg.referrersAPI = referrersAPI
+		ref := mustParseNamed(t, "my.registry/build/my-app:0.1.0")
+		_, bundleDescriptor := pushTestBundle(t, reg, ref)
+
+		sbom := []byte(`{"spdxVersion":"SPDX-2.3"}`)
+		sbomDescriptor, err := Attach(context.Background(), ref, reg, "application/spdx+json", []AttachedBlob{
+			{MediaType: "application/spdx+json", Title: "sbom.spdx.json", Data: sbom},
+		}, WithAttachAnnotations(map[string]string{"org.example.scanner": "test"}))
+		assert.NilError(t, err)
+		readmeDescriptor, err := Attach(context.Background(), ref, reg, "text/markdown", []AttachedBlob{
+			{MediaType: "text/markdown", Title: "README.md", Data: []byte("# My app")},
+		})
+		assert.NilError(t, err)
+
+		// The artifact manifest refers to the bundle index
+		repo := reg.repository("my.registry/build/my-app")
+		_, ok := repo.content[digest.FromBytes(sbom)]
+		assert.Assert(t, ok)
+		_, ok = repo.content[ocischemav1.DescriptorEmptyJSON.Digest]
+		assert.Assert(t, ok)
+
+		// The referrers tag schema is only used when the registry has no referrers API
+		_, hasTag := repo.tags[referrersTag(bundleDescriptor.Digest)]
+		assert.Equal(t, hasTag, !referrersAPI)
+
+		referrers, err := ListReferrers(context.Background(), ref, reg)
+		assert.NilError(t, err)
+		assert.Equal(t, len(referrers), 2)
+
+		referrers, err = ListReferrers(context.Background(), ref, reg, "application/spdx+json")
+		assert.NilError(t, err)
+		assert.Equal(t, len(referrers), 1)
+		assert.Equal(t, referrers[0].Digest, sbomDescriptor.Digest)
+		assert.Equal(t, referrers[0].ArtifactType, "application/spdx+json")
+		assert.Equal(t, referrers[0].Annotations["org.example.scanner"], "test")
+
+		// Attaching the same artifact again does not duplicate it
+		_, err = Attach(context.Background(), ref, reg, "text/markdown", []AttachedBlob{
+			{MediaType: "text/markdown", Title: "README.md", Data: []byte("# My app")},
+		})
+		assert.NilError(t, err)
+		referrers, err = ListReferrers(context.Background(), ref, reg, "text/markdown")
+		assert.NilError(t, err)
+		assert.Equal(t, len(referrers), 1)
+		assert.Equal(t, referrers[0].Digest, readmeDescriptor.Digest)
+	}
+}
+
+func TestListReferrersWithoutReferrers(t *testing.T) {
+	reg := newMemoryRegistry()
+	ref := mustParseNamed(t, "my.registry/build/my-app:0.1.0")
+	pushTestBundle(t, reg, ref)
+
+	referrers, err := ListReferrers(context.Background(), ref, reg)
+	assert.NilError(t, err)
+	assert.Equal(t, len(referrers), 0)
+}
+
+func TestAttachMissingSubject(t *testing.T) {
+	reg := newMemoryRegistry()
+	_, err := Attach(context.Background(), mustParseNamed(t, "my.registry/build/my-app:0.1.0"), reg, "text/markdown", nil)
+	assert.ErrorContains(t, err, "not found")
+}
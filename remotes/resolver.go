@@ -17,13 +17,30 @@ import (
 // multiRegistryResolver is an OCI registry resolver that accepts a list of
 // insecure registries. It will skip TLS validation for registries that are secured with TLS
 // use plain http for unsecured registries and any registry that is exposed on a loopback ip address.
+// The registry hosts with their own TLS configuration, like a private CA or a client certificate, get their own client.
 type multiRegistryResolver struct {
 	resolver            remotes.Resolver
 	plainHTTPRegistries map[string]struct{}
 	skipTLSRegistries   map[string]struct{}
-	authorizer          docker.Authorizer
-	skipTLSClient       *http.Client
-	skipTLSAuthorizer   docker.Authorizer
+	defaultClient       registryClient
+	tlsClients          map[string]registryClient
+	hosts               map[string]RegistryHostConfig
+	credentials         CredentialProvider
+}
+
+// registryClient is the client sending the requests to a registry host, and the authorizer getting its tokens
+type registryClient struct {
+	client     *http.Client
+	authorizer docker.Authorizer
+}
+
+// newRegistryClient creates a client using the transport. When a retry policy is set, throttled responses are turned
+// into errors carrying their Retry-After delay, so they can be honoured when retrying the request.
+func newRegistryClient(transport http.RoundTripper, authCreds docker.AuthorizerOpt) registryClient {
+	return registryClient{
+		client:     &http.Client{Transport: &retryAfterTransport{base: transport}},
+		authorizer: docker.NewDockerAuthorizer(authCreds, docker.WithAuthClient(&http.Client{Transport: transport})),
+	}
 }
 
 func (r *multiRegistryResolver) Resolve(ctx context.Context, ref string) (name string, desc ocispec.Descriptor, err error) {
@@ -57,51 +74,84 @@ func (r *multiRegistryResolver) Pusher(ctx context.Context, ref string) (remotes
 	return r.resolver.Pusher(ctx, ref)
 }
 
+func (r *multiRegistryResolver) credentialProvider() CredentialProvider {
+	return r.credentials
+}
+
 // CreateResolver creates a docker registry resolver, using the local docker CLI credentials
 func CreateResolver(cfg *configfile.ConfigFile, insecureRegistries ...string) remotes.Resolver {
+	return newMultiRegistryResolver(resolverConfig{credentials: DockerConfigCredentials(cfg), insecureRegistries: insecureRegistries})
+}
+
+// CreateResolverWithOptions creates a docker registry resolver configured by the given options. The credentials are
+// read from the docker CLI configuration, which can be nil if the options include WithCredentialProvider.
+func CreateResolverWithOptions(cfg *configfile.ConfigFile, options ...ResolverOption) (remotes.Resolver, error) {
+	resolverCfg, err := newResolverConfig(cfg, options...)
+	if err != nil {
+		return nil, err
+	}
+	resolver := newMultiRegistryResolver(resolverCfg)
+	if resolverCfg.blobCache == nil {
+		return resolver, nil
+	}
+	return &blobCacheResolver{Resolver: resolver, cache: resolverCfg.blobCache, offline: resolverCfg.offline}, nil
+}
+
+func newMultiRegistryResolver(resolverCfg resolverConfig) *multiRegistryResolver {
 	authCreds := docker.WithAuthCreds(func(hostName string) (string, string, error) {
-		if hostName == defaultRegistryHost {
-			hostName = "https://" + legacyDefaultDomain + "/v1/"
-		}
-		a, err := cfg.GetAuthConfig(hostName)
+		credentials, err := registryCredentials(context.Background(), resolverCfg.credentials, hostName)
 		if err != nil {
 			return "", "", err
 		}
-		if a.IdentityToken != "" {
-			return "", a.IdentityToken, nil
+		if credentials.IdentityToken != "" {
+			return "", credentials.IdentityToken, nil
 		}
-		return a.Username, a.Password, nil
+		return credentials.Username, credentials.Password, nil
 	})
 
-	clientSkipTLS := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
-		},
-	}
-
 	result := &multiRegistryResolver{
-		authorizer:          docker.NewDockerAuthorizer(authCreds),
-		skipTLSClient:       clientSkipTLS,
-		skipTLSAuthorizer:   docker.NewDockerAuthorizer(authCreds, docker.WithAuthClient(clientSkipTLS)),
+		defaultClient:       newRegistryClient(resolverCfg.newTransport(nil), authCreds),
+		tlsClients:          make(map[string]registryClient),
 		plainHTTPRegistries: make(map[string]struct{}),
 		skipTLSRegistries:   make(map[string]struct{}),
+		hosts:               resolverCfg.hosts,
+		credentials:         resolverCfg.credentials,
+	}
+	tlsConfigs := make(map[string]*tls.Config, len(resolverCfg.tlsConfigs))
+	for host, config := range resolverCfg.tlsConfigs {
+		tlsConfigs[host] = config
 	}
 
 	// Determine ahead of time how each registry is insecure
 	// 1. It uses TLS but has a bad cert
 	// 2. It doesn't use TLS
+	// Offline, the registries are never reached.
+	insecureRegistries := resolverCfg.insecureRegistries
+	if resolverCfg.offline {
+		insecureRegistries = nil
+	}
 	for _, r := range insecureRegistries {
+		skipTLSConfig := &tls.Config{}
+		if config, ok := tlsConfigs[r]; ok {
+			skipTLSConfig = config.Clone()
+		}
+		skipTLSConfig.InsecureSkipVerify = true
+		clientSkipTLS := &http.Client{Transport: resolverCfg.newTransport(skipTLSConfig)}
 		pingURL := fmt.Sprintf("https://%s/v2/", r)
 		resp, err := clientSkipTLS.Get(pingURL)
 		if err == nil {
 			resp.Body.Close()
 			result.skipTLSRegistries[r] = struct{}{}
+			tlsConfigs[r] = skipTLSConfig
 		} else {
+			// The TLS configuration of the registry does not apply to plain HTTP
 			result.plainHTTPRegistries[r] = struct{}{}
+			delete(tlsConfigs, r)
 		}
 	}
+	for host, config := range tlsConfigs {
+		result.tlsClients[host] = newRegistryClient(resolverCfg.newTransport(config), authCreds)
+	}
 
 	result.resolver = docker.NewResolver(docker.ResolverOptions{
 		Hosts: result.configureHosts(),
@@ -110,40 +160,71 @@ func CreateResolver(cfg *configfile.ConfigFile, insecureRegistries ...string) re
 	return result
 }
 
+// configureHosts returns the mirrors of the registry, tried in order for the resolves and the fetches, followed by
+// its upstream server
 func (r *multiRegistryResolver) configureHosts() docker.RegistryHosts {
 	return func(host string) ([]docker.RegistryHost, error) {
-		config := docker.RegistryHost{
-			Client:       http.DefaultClient,
-			Authorizer:   r.authorizer,
-			Host:         host,
-			Scheme:       "https",
-			Path:         "/v2",
-			Capabilities: docker.HostCapabilityPull | docker.HostCapabilityResolve | docker.HostCapabilityPush,
-		}
-
-		if _, skipTLS := r.skipTLSRegistries[host]; skipTLS {
-			config.Client = r.skipTLSClient
-			config.Authorizer = r.skipTLSAuthorizer
-		} else if _, plainHTTP := r.plainHTTPRegistries[host]; plainHTTP {
-			config.Scheme = "http"
-		} else {
-			// Default to plain http for localhost
-			match, err := docker.MatchLocalhost(host)
+		hostConfig := r.hosts[host]
+		var registryHosts []docker.RegistryHost
+		for _, mirror := range hostConfig.Mirrors {
+			registryHost, err := r.registryHost(mirror, docker.HostCapabilityPull|docker.HostCapabilityResolve)
 			if err != nil {
 				return nil, err
 			}
-			if match {
-				config.Scheme = "http"
-			}
+			registryHosts = append(registryHosts, registryHost)
 		}
 
+		server := RegistryEndpoint{Host: host}
 		// If this is not set, then we aren't prompted to authenticate to Docker Hub,
 		// which causes the returned content type to be text/html instead of the
 		// specialized content types for images and manifests
 		if host == "docker.io" {
-			config.Host = "registry-1.docker.io"
+			server.Host = "registry-1.docker.io"
 		}
+		if hostConfig.Server != nil {
+			server = *hostConfig.Server
+		}
+		registryHost, err := r.registryHost(server, docker.HostCapabilityPull|docker.HostCapabilityResolve|docker.HostCapabilityPush|docker.HostCapabilityReferrers)
+		if err != nil {
+			return nil, err
+		}
+		return append(registryHosts, registryHost), nil
+	}
+}
 
-		return []docker.RegistryHost{config}, nil
+func (r *multiRegistryResolver) registryHost(endpoint RegistryEndpoint, defaultCapabilities docker.HostCapabilities) (docker.RegistryHost, error) {
+	config := docker.RegistryHost{
+		Client:       r.defaultClient.client,
+		Authorizer:   r.defaultClient.authorizer,
+		Host:         endpoint.Host,
+		Scheme:       "https",
+		Path:         "/v2",
+		Capabilities: defaultCapabilities,
+	}
+	if endpoint.Path != "" {
+		config.Path = endpoint.Path
+	}
+	if endpoint.Capabilities != 0 {
+		config.Capabilities = endpoint.Capabilities
+	}
+
+	if _, plainHTTP := r.plainHTTPRegistries[endpoint.Host]; plainHTTP {
+		config.Scheme = "http"
+	} else if client, ok := r.tlsClients[endpoint.Host]; ok {
+		config.Client = client.client
+		config.Authorizer = client.authorizer
+	} else {
+		// Default to plain http for localhost
+		match, err := docker.MatchLocalhost(endpoint.Host)
+		if err != nil {
+			return docker.RegistryHost{}, err
+		}
+		if match {
+			config.Scheme = "http"
+		}
+	}
+	if endpoint.Scheme != "" {
+		config.Scheme = endpoint.Scheme
 	}
+	return config, nil
 }
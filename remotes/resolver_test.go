@@ -0,0 +1,144 @@
+package remotes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/remotes/docker"
+	"github.com/containerd/errdefs"
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/opencontainers/go-digest"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"gotest.tools/v3/assert"
+)
+
+// testRegistryServer serves a manifest under a tag and records the requests it receives
+type testRegistryServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []string
+}
+
+func newTestRegistryServer(manifestPath string, manifest []byte) *testRegistryServer {
+	s := &testRegistryServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+		s.mu.Unlock()
+		switch {
+		case r.URL.Path == manifestPath:
+			w.Header().Set("Content-Type", ocischemav1.MediaTypeImageManifest)
+			w.Header().Set("Docker-Content-Digest", digest.FromBytes(manifest).String())
+			w.Header().Set("Content-Length", strconv.Itoa(len(manifest)))
+			if r.Method == http.MethodGet {
+				_, _ = w.Write(manifest)
+			}
+		case strings.Contains(r.URL.Path, "/blobs/") && r.Method == http.MethodHead:
+			w.Header().Set("Content-Length", "0")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return s
+}
+
+func (s *testRegistryServer) host() string {
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+func (s *testRegistryServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func TestResolverRegistryHosts(t *testing.T) {
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)
+	mirror := newTestRegistryServer("/artifactory/api/docker/v2/namespace/app/manifests/mirrored", manifest)
+	defer mirror.Close()
+	upstream := newTestRegistryServer("/v2/namespace/app/manifests/upstream", manifest)
+	defer upstream.Close()
+
+	resolver, err := CreateResolverWithOptions(configfile.New(""), WithRegistryHosts(map[string]RegistryHostConfig{
+		"my.registry": {
+			Mirrors: []RegistryEndpoint{{Host: mirror.host(), Path: "/artifactory/api/docker/v2"}},
+			Server:  &RegistryEndpoint{Host: upstream.host(), Scheme: "http"},
+		},
+	}))
+	assert.NilError(t, err)
+
+	// The mirror is tried first, then the server
+	_, desc, err := resolver.Resolve(context.Background(), "my.registry/namespace/app:mirrored")
+	assert.NilError(t, err)
+	assert.Equal(t, desc.Digest, digest.FromBytes(manifest))
+	assert.Equal(t, len(upstream.received()), 0)
+	_, _, err = resolver.Resolve(context.Background(), "my.registry/namespace/app:upstream")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, upstream.received(), []string{"HEAD /v2/namespace/app/manifests/upstream"})
+
+	// The pushes only go to the server
+	mirrorRequests := len(mirror.received())
+	pusher, err := resolver.Pusher(context.Background(), "my.registry/namespace/app:upstream")
+	assert.NilError(t, err)
+	blob := ocischemav1.Descriptor{MediaType: ocischemav1.MediaTypeImageLayer, Digest: digest.FromString("layer"), Size: 5}
+	_, err = pusher.Push(context.Background(), blob)
+	assert.Assert(t, errdefs.IsAlreadyExists(err), "unexpected error %v", err)
+	assert.Equal(t, len(mirror.received()), mirrorRequests)
+	assert.Equal(t, upstream.received()[1], "HEAD /v2/namespace/app/blobs/"+blob.Digest.String())
+
+	_, err = CreateResolverWithOptions(configfile.New(""), WithRegistryHosts(map[string]RegistryHostConfig{
+		"my.registry": {Mirrors: []RegistryEndpoint{{Host: "mirror", Scheme: "ftp"}}},
+	}))
+	assert.ErrorContains(t, err, `invalid mirror of registry "my.registry": unsupported scheme "ftp"`)
+}
+
+func TestLoadHostsDir(t *testing.T) {
+	dir := t.TempDir()
+	assert.NilError(t, os.MkdirAll(filepath.Join(dir, "docker.io"), 0755))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "docker.io", "hosts.toml"), []byte(`
+server = "https://registry-1.docker.io"
+
+[host."https://mirror-b.example.com"]
+
+[host."http://artifactory.example.com/artifactory/api/docker/docker-remote"]
+  capabilities = ["pull", "resolve"]
+  override_path = true
+
+[host."mirror-c.example.com:5000"]
+  capabilities = ["pull"]
+`), 0644))
+	assert.NilError(t, os.MkdirAll(filepath.Join(dir, "empty.registry"), 0755))
+
+	hosts, err := LoadHostsDir(dir)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, hosts, map[string]RegistryHostConfig{
+		"docker.io": {
+			Server: &RegistryEndpoint{Host: "registry-1.docker.io", Scheme: "https", Path: "/v2"},
+			Mirrors: []RegistryEndpoint{
+				{Host: "mirror-b.example.com", Scheme: "https", Path: "/v2"},
+				{Host: "artifactory.example.com", Scheme: "http", Path: "/artifactory/api/docker/docker-remote",
+					Capabilities: docker.HostCapabilityPull | docker.HostCapabilityResolve},
+				{Host: "mirror-c.example.com:5000", Path: "/v2", Capabilities: docker.HostCapabilityPull},
+			},
+		},
+	})
+
+	_, err = parseHostsFile("my.registry", "", []byte(`[host."https://mirror.example.com"]
+  capabilities = ["delete"]`))
+	assert.ErrorContains(t, err, `unknown capability "delete"`)
+	_, err = parseHostsFile("my.registry", "", []byte(`[host."https://mirror.example.com"]
+  dial_timeout = "1s"`))
+	assert.ErrorContains(t, err, "dial_timeout")
+	for _, client := range []string{`[[]]`, `[["client.cert"]]`, `[["client.cert", 1]]`, `[["a", "b", "c"]]`} {
+		_, err = parseHostsFile("my.registry", "", []byte(`[host."https://mirror.example.com"]
+  client = `+client))
+		assert.ErrorContains(t, err, "invalid client certificate and key pair", client)
+	}
+}
@@ -0,0 +1,279 @@
+package remotes
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/containerd/containerd/v2/core/remotes/docker"
+	"github.com/docker/cli/cli/config/configfile"
+)
+
+// resolverConfig defines the registries configuration of a resolver created by CreateResolverWithOptions
+type resolverConfig struct {
+	credentials        CredentialProvider
+	insecureRegistries []string
+	hosts              map[string]RegistryHostConfig
+	tlsConfigs         map[string]*tls.Config
+	transport          http.RoundTripper
+	proxy              func(*http.Request) (*url.URL, error)
+	registryProxies    map[string]*url.URL
+	middlewares        []func(http.RoundTripper) http.RoundTripper
+	blobCache          *BlobCache
+	offline            bool
+}
+
+// ResolverOption is a helper for configuring a resolver created by CreateResolverWithOptions
+type ResolverOption func(*resolverConfig) error
+
+func newResolverConfig(cfg *configfile.ConfigFile, options ...ResolverOption) (resolverConfig, error) {
+	config := resolverConfig{
+		hosts:           map[string]RegistryHostConfig{},
+		tlsConfigs:      map[string]*tls.Config{},
+		registryProxies: map[string]*url.URL{},
+	}
+	if cfg != nil {
+		config.credentials = DockerConfigCredentials(cfg)
+	}
+	for _, opt := range options {
+		if err := opt(&config); err != nil {
+			return resolverConfig{}, err
+		}
+	}
+	if config.credentials == nil {
+		return resolverConfig{}, fmt.Errorf("no docker configuration nor credential provider to read the registry credentials from")
+	}
+	if config.offline && config.blobCache == nil {
+		return resolverConfig{}, fmt.Errorf("the offline mode requires a blob cache")
+	}
+	if _, ok := config.baseTransport().(*http.Transport); !ok {
+		// The TLS and proxy settings can only be applied to an *http.Transport
+		switch {
+		case len(config.tlsConfigs) > 0 || len(config.insecureRegistries) > 0:
+			return resolverConfig{}, fmt.Errorf("the TLS configuration of the registries and the insecure registries require an *http.Transport, not %T", config.transport)
+		case config.customProxy():
+			return resolverConfig{}, fmt.Errorf("the proxy configuration requires an *http.Transport, not %T", config.transport)
+		}
+	}
+	return config, nil
+}
+
+// RegistryHostConfig overrides the endpoints used to reach a registry
+type RegistryHostConfig struct {
+	// Mirrors are tried in order for the resolves and the fetches, before the server
+	Mirrors []RegistryEndpoint
+	// Server replaces the upstream endpoint of the registry, which is the registry host name by default
+	Server *RegistryEndpoint
+}
+
+// RegistryEndpoint is an endpoint serving the registry API
+type RegistryEndpoint struct {
+	// Host is the host name of the endpoint, with its port if any
+	Host string
+	// Scheme is "https" or "http". By default, plain HTTP is only used for the insecure registries and the loopback
+	// addresses.
+	Scheme string
+	// Path is the path of the registry API, "/v2" by default. Registries served under a path prefix have a path like
+	// "/artifactory/api/docker/docker-remote/v2".
+	Path string
+	// Capabilities are the operations sent to this endpoint: pull and resolve by default for a mirror, all of them for
+	// a server
+	Capabilities docker.HostCapabilities
+	// TLS is the TLS configuration of the endpoint host, if any
+	TLS *RegistryTLSConfig
+}
+
+func (e RegistryEndpoint) validate() error {
+	if e.Host == "" {
+		return fmt.Errorf("endpoint has no host")
+	}
+	switch e.Scheme {
+	case "", "http", "https":
+	default:
+		return fmt.Errorf("unsupported scheme %q for endpoint %q", e.Scheme, e.Host)
+	}
+	if e.Path != "" && !strings.HasPrefix(e.Path, "/") {
+		return fmt.Errorf("path %q of endpoint %q must be absolute", e.Path, e.Host)
+	}
+	return nil
+}
+
+// WithCredentialProvider replaces the docker CLI configuration as the source of the registry credentials
+func WithCredentialProvider(provider CredentialProvider) ResolverOption {
+	return func(cfg *resolverConfig) error {
+		if provider == nil {
+			return fmt.Errorf("credential provider cannot be nil")
+		}
+		cfg.credentials = provider
+		return nil
+	}
+}
+
+// addEndpoint validates the endpoint and loads its TLS configuration, if any
+func (cfg *resolverConfig) addEndpoint(endpoint RegistryEndpoint) error {
+	if err := endpoint.validate(); err != nil {
+		return err
+	}
+	if endpoint.TLS == nil {
+		return nil
+	}
+	return cfg.addTLSConfig(endpoint.Host, *endpoint.TLS)
+}
+
+func (cfg *resolverConfig) addTLSConfig(host string, config RegistryTLSConfig) error {
+	tlsConfig, err := config.tlsConfig()
+	if err != nil {
+		return fmt.Errorf("invalid TLS configuration of %q: %s", host, err)
+	}
+	cfg.tlsConfigs[host] = tlsConfig
+	return nil
+}
+
+// WithRegistryTLS sets the TLS configuration of the registry hosts, keyed by host name, replacing the ones given by
+// the previous options. The insecure registries served with TLS keep their configuration, without verifying the
+// server certificate.
+func WithRegistryTLS(configs map[string]RegistryTLSConfig) ResolverOption {
+	return func(cfg *resolverConfig) error {
+		for host, config := range configs {
+			if err := cfg.addTLSConfig(host, config); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// WithCertsDir loads the TLS configuration of the registry hosts from a docker style certificates directory, like
+// /etc/docker/certs.d
+func WithCertsDir(dir string) ResolverOption {
+	return func(cfg *resolverConfig) error {
+		configs, err := LoadCertsDir(dir)
+		if err != nil {
+			return err
+		}
+		return WithRegistryTLS(configs)(cfg)
+	}
+}
+
+// WithInsecureRegistries uses plain HTTP, or skips the TLS verification, for those registries
+func WithInsecureRegistries(registries ...string) ResolverOption {
+	return func(cfg *resolverConfig) error {
+		cfg.insecureRegistries = append(cfg.insecureRegistries, registries...)
+		return nil
+	}
+}
+
+// WithRegistryHosts overrides the endpoints of the registries, keyed by registry host name like "docker.io". The
+// pushes are only sent to the endpoints with the push capability, which are the servers by default.
+func WithRegistryHosts(hosts map[string]RegistryHostConfig) ResolverOption {
+	return func(cfg *resolverConfig) error {
+		for host, hostConfig := range hosts {
+			for _, mirror := range hostConfig.Mirrors {
+				if err := cfg.addEndpoint(mirror); err != nil {
+					return fmt.Errorf("invalid mirror of registry %q: %s", host, err)
+				}
+			}
+			if hostConfig.Server != nil {
+				if err := cfg.addEndpoint(*hostConfig.Server); err != nil {
+					return fmt.Errorf("invalid server of registry %q: %s", host, err)
+				}
+			}
+			cfg.hosts[host] = hostConfig
+		}
+		return nil
+	}
+}
+
+// WithHostsDir loads the registry endpoints from a containerd style hosts directory, containing a
+// "<host>/hosts.toml" file per registry
+func WithHostsDir(dir string) ResolverOption {
+	return func(cfg *resolverConfig) error {
+		hosts, err := LoadHostsDir(dir)
+		if err != nil {
+			return err
+		}
+		return WithRegistryHosts(hosts)(cfg)
+	}
+}
+
+// WithTransport sets the base transport of all the clients of the resolver, including the ones getting the
+// authorization tokens, instead of http.DefaultTransport. An *http.Transport is cloned for the registries with their
+// own TLS configuration, and gets the proxy configuration of the options; those options fail with another
+// http.RoundTripper.
+func WithTransport(transport http.RoundTripper) ResolverOption {
+	return func(cfg *resolverConfig) error {
+		if transport == nil {
+			return fmt.Errorf("transport cannot be nil")
+		}
+		cfg.transport = transport
+		return nil
+	}
+}
+
+// WithTransportMiddleware wraps the transports of the resolver, for instance to sign or tag the requests. The
+// middlewares are applied in order, the last one receiving the requests first.
+func WithTransportMiddleware(middleware func(http.RoundTripper) http.RoundTripper) ResolverOption {
+	return func(cfg *resolverConfig) error {
+		if middleware == nil {
+			return fmt.Errorf("transport middleware cannot be nil")
+		}
+		cfg.middlewares = append(cfg.middlewares, middleware)
+		return nil
+	}
+}
+
+// WithProxy sets the proxy of the requests, replacing the one of the base transport. A nil proxy URL means a direct
+// connection. The proxy of a golang.org/x/net/http/httpproxy configuration gives a custom NO_PROXY policy.
+func WithProxy(proxy func(*http.Request) (*url.URL, error)) ResolverOption {
+	return func(cfg *resolverConfig) error {
+		if proxy == nil {
+			return fmt.Errorf("proxy cannot be nil")
+		}
+		cfg.proxy = proxy
+		return nil
+	}
+}
+
+// WithProxyFromEnvironment uses the proxy of the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables, which
+// http.DefaultTransport already does
+func WithProxyFromEnvironment() ResolverOption {
+	return WithProxy(http.ProxyFromEnvironment)
+}
+
+// WithRegistryProxies sets the proxy URL of the registry hosts, keyed by host name with its port if any, like
+// "my.registry:5000". A nil URL means a direct connection. The other hosts use the proxy of WithProxy, or the one of
+// the base transport.
+func WithRegistryProxies(proxies map[string]*url.URL) ResolverOption {
+	return func(cfg *resolverConfig) error {
+		for host, proxyURL := range proxies {
+			if proxyURL != nil && proxyURL.Host == "" {
+				return fmt.Errorf("invalid proxy %q of registry %q: no host", proxyURL, host)
+			}
+			cfg.registryProxies[host] = proxyURL
+		}
+		return nil
+	}
+}
+
+// WithBlobCache serves the blobs and the manifests from a local cache, filling it with the content fetched from the
+// registries. The tags resolved during the TTL of the cache are not resolved again.
+func WithBlobCache(cache *BlobCache) ResolverOption {
+	return func(cfg *resolverConfig) error {
+		if cache == nil {
+			return fmt.Errorf("blob cache cannot be nil")
+		}
+		cfg.blobCache = cache
+		return nil
+	}
+}
+
+// WithOffline never sends a request to the registries: the tags, manifests and blobs all come from the blob cache,
+// whatever their age, and the pushes fail. It requires WithBlobCache.
+func WithOffline() ResolverOption {
+	return func(cfg *resolverConfig) error {
+		cfg.offline = true
+		return nil
+	}
+}
@@ -0,0 +1,207 @@
+package remotes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/remotes"
+	remoteerrors "github.com/containerd/containerd/v2/core/remotes/errors"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/log"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	defaultRetryMaxAttempts    = 5
+	defaultRetryInitialBackoff = 500 * time.Millisecond
+	defaultRetryMaxBackoff     = 30 * time.Second
+)
+
+// RetryPolicy configures how registry operations are retried after a transient failure, like a 502, a 429 or a
+// connection reset. Only idempotent operations are retried: fetching content, and pushing content-addressed blobs
+// and manifests. Errors like an authorization failure, a missing content or an invalid manifest are not retried.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts of an operation. A value lower than 2 disables retries.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry, doubled after each attempt. Defaults to 500ms.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between two attempts, unless the registry asks to wait longer with a
+	// Retry-After header. Defaults to 30s.
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy returns a retry policy with 5 attempts and an exponential backoff from 500ms up to 30s
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    defaultRetryMaxAttempts,
+		InitialBackoff: defaultRetryInitialBackoff,
+		MaxBackoff:     defaultRetryMaxBackoff,
+	}
+}
+
+// backoff returns the delay before the given retry (starting at 1), with a random jitter of up to half the delay.
+// A Retry-After delay sent by the registry is honoured if it is longer.
+func (p RetryPolicy) backoff(retry int, err error) time.Duration {
+	initial, maxBackoff := p.InitialBackoff, p.MaxBackoff
+	if initial <= 0 {
+		initial = defaultRetryInitialBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = defaultRetryMaxBackoff
+	}
+	delay := maxBackoff
+	if shift := retry - 1; shift < 32 && initial<<shift < maxBackoff {
+		delay = initial << shift
+	}
+	delay -= rand.N(delay/2 + 1)
+
+	var retryAfter *retryAfterError
+	if errors.As(err, &retryAfter) && retryAfter.delay > delay {
+		delay = retryAfter.delay
+	}
+	return delay
+}
+
+type retryPolicyKey struct{}
+
+func withRetryPolicy(ctx context.Context, policy RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, policy)
+}
+
+func retryPolicyFromContext(ctx context.Context) RetryPolicy {
+	policy, _ := ctx.Value(retryPolicyKey{}).(RetryPolicy)
+	return policy
+}
+
+// retry runs the operation until it succeeds, fails with a non transient error, or the retry policy set on the
+// context is exhausted. Without retry policy, the operation is run once.
+func retry(ctx context.Context, operation string, f func() error) error {
+	policy := retryPolicyFromContext(ctx)
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= policy.MaxAttempts || !isRetryable(err) {
+			return err
+		}
+		delay := policy.backoff(attempt, err)
+		log.G(ctx).Debugf("Attempt %d/%d to %s failed, retrying in %s: %s", attempt, policy.MaxAttempts, operation, delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// isRetryable classifies the errors returned by the registry client: server errors, throttling and network
+// failures are transient, while client errors like 401, 403, 404 or an invalid manifest are not.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var retryAfter *retryAfterError
+	if errors.As(err, &retryAfter) {
+		return true
+	}
+	var statusErr remoteerrors.ErrUnexpectedStatus
+	if errors.As(err, &statusErr) {
+		return isRetryableStatus(statusErr.StatusCode)
+	}
+	if errdefs.IsNotFound(err) || errdefs.IsAlreadyExists(err) || errdefs.IsUnauthorized(err) ||
+		errdefs.IsPermissionDenied(err) || errdefs.IsInvalidArgument(err) || errdefs.IsFailedPrecondition(err) {
+		return false
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func isRetryableStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfterError is returned when a registry throttles a request and asks to retry later
+type retryAfterError struct {
+	status string
+	delay  time.Duration
+}
+
+func (e *retryAfterError) Error() string {
+	return fmt.Sprintf("registry responded %s, retry after %s", e.status, e.delay)
+}
+
+// retryAfterTransport turns the throttled responses carrying a Retry-After header into a retryAfterError, as the
+// registry client does not expose the response headers of a failed request. It only does so when the request is
+// retried, according to the retry policy of its context: otherwise the response is returned unchanged, so the registry
+// client reports it as usual and can fall back to the next host.
+type retryAfterTransport struct {
+	base http.RoundTripper
+}
+
+func (t *retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || (resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable) {
+		return resp, err
+	}
+	if retryPolicyFromContext(req.Context()).MaxAttempts < 2 {
+		return resp, nil
+	}
+	delay, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	if !ok {
+		return resp, nil
+	}
+	resp.Body.Close()
+	return nil, &retryAfterError{status: resp.Status, delay: delay}
+}
+
+// parseRetryAfter parses a Retry-After header value, either a number of seconds or an HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if delay := date.Sub(now); delay > 0 {
+		return delay, true
+	}
+	return 0, true
+}
+
+// resolve resolves a reference, retrying transient failures according to the retry policy set on the context
+func resolve(ctx context.Context, resolver remotes.Resolver, ref string) (string, ocischemav1.Descriptor, error) {
+	var (
+		name string
+		desc ocischemav1.Descriptor
+	)
+	err := retry(ctx, fmt.Sprintf("resolve %s", ref), func() error {
+		var err error
+		name, desc, err = resolver.Resolve(ctx, ref)
+		return err
+	})
+	return name, desc, err
+}
@@ -0,0 +1,225 @@
+package remotes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/remotes"
+	remoteerrors "github.com/containerd/containerd/v2/core/remotes/errors"
+	"github.com/containerd/errdefs"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"gotest.tools/v3/assert"
+)
+
+// flakyRegistry fails the first attempt of each resolve, fetch and push with the given error
+type flakyRegistry struct {
+	*memoryRegistry
+	err      error
+	mut      sync.Mutex
+	attempts map[string]int
+}
+
+func newFlakyRegistry(reg *memoryRegistry, err error) *flakyRegistry {
+	return &flakyRegistry{memoryRegistry: reg, err: err, attempts: map[string]int{}}
+}
+
+func (r *flakyRegistry) fail(operation string) error {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.attempts[operation]++
+	if r.attempts[operation] == 1 {
+		return r.err
+	}
+	return nil
+}
+
+func (r *flakyRegistry) Resolve(ctx context.Context, ref string) (string, ocischemav1.Descriptor, error) {
+	if err := r.fail("resolve " + ref); err != nil {
+		return "", ocischemav1.Descriptor{}, err
+	}
+	return r.memoryRegistry.Resolve(ctx, ref)
+}
+
+func (r *flakyRegistry) Fetcher(ctx context.Context, ref string) (remotes.Fetcher, error) {
+	fetcher, err := r.memoryRegistry.Fetcher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return flakyFetcher{fetcher, r}, nil
+}
+
+func (r *flakyRegistry) Pusher(ctx context.Context, ref string) (remotes.Pusher, error) {
+	pusher, err := r.memoryRegistry.Pusher(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return flakyPusher{pusher, r, ref}, nil
+}
+
+type flakyFetcher struct {
+	remotes.Fetcher
+	registry *flakyRegistry
+}
+
+func (f flakyFetcher) Fetch(ctx context.Context, desc ocischemav1.Descriptor) (io.ReadCloser, error) {
+	if err := f.registry.fail(fmt.Sprintf("fetch %s", desc.Digest)); err != nil {
+		return nil, err
+	}
+	return f.Fetcher.Fetch(ctx, desc)
+}
+
+type flakyPusher struct {
+	remotes.Pusher
+	registry *flakyRegistry
+	ref      string
+}
+
+func (p flakyPusher) Push(ctx context.Context, desc ocischemav1.Descriptor) (content.Writer, error) {
+	if err := p.registry.fail(fmt.Sprintf("push %s %s", p.ref, desc.Digest)); err != nil {
+		return nil, err
+	}
+	return p.Pusher.Push(ctx, desc)
+}
+
+var testRetryPolicy = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+func TestCopyRetriesTransientErrors(t *testing.T) {
+	reg := newMemoryRegistry()
+	srcRef := mustParseNamed(t, "my.registry/build/my-app:0.1.0")
+	b, pushedDescriptor := pushTestBundle(t, reg, srcRef)
+	dstRef := mustParseNamed(t, "my.registry/production/my-app:0.1.0")
+
+	badGateway := remoteerrors.ErrUnexpectedStatus{Status: "502 Bad Gateway", StatusCode: http.StatusBadGateway}
+
+	// Without retry policy, the first transient error fails the copy
+	_, err := Copy(context.Background(), srcRef, dstRef, newFlakyRegistry(reg, badGateway))
+	assert.ErrorContains(t, err, "502 Bad Gateway")
+
+	copiedDescriptor, err := Copy(context.Background(), srcRef, dstRef, newFlakyRegistry(reg, badGateway), WithCopyRetryPolicy(testRetryPolicy))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, copiedDescriptor, pushedDescriptor)
+
+	pulled, _, _, err := Pull(context.Background(), dstRef, newFlakyRegistry(reg, syscall.ECONNRESET), WithPullRetryPolicy(testRetryPolicy))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, pulled, b)
+}
+
+func TestRetryFailsFastOnClientErrors(t *testing.T) {
+	unauthorized := remoteerrors.ErrUnexpectedStatus{Status: "401 Unauthorized", StatusCode: http.StatusUnauthorized}
+	attempts := 0
+	err := retry(withRetryPolicy(context.Background(), testRetryPolicy), "push", func() error {
+		attempts++
+		return fmt.Errorf("failed to push: %w", unauthorized)
+	})
+	assert.ErrorContains(t, err, "401 Unauthorized")
+	assert.Equal(t, attempts, 1)
+
+	attempts = 0
+	err = retry(withRetryPolicy(context.Background(), testRetryPolicy), "push", func() error {
+		attempts++
+		return syscall.ECONNRESET
+	})
+	assert.ErrorIs(t, err, syscall.ECONNRESET)
+	assert.Equal(t, attempts, 3)
+}
+
+func TestIsRetryable(t *testing.T) {
+	status := func(code int) error {
+		return remoteerrors.ErrUnexpectedStatus{Status: http.StatusText(code), StatusCode: code}
+	}
+	testCases := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{name: "bad gateway", err: status(http.StatusBadGateway), retryable: true},
+		{name: "too many requests", err: status(http.StatusTooManyRequests), retryable: true},
+		{name: "service unavailable", err: status(http.StatusServiceUnavailable), retryable: true},
+		{name: "joined registry error", err: errors.Join(status(http.StatusInternalServerError), errors.New("registry error")), retryable: true},
+		{name: "retry after", err: fmt.Errorf("failed to do request: %w", &retryAfterError{delay: time.Second}), retryable: true},
+		{name: "connection reset", err: fmt.Errorf("read: %w", syscall.ECONNRESET), retryable: true},
+		{name: "unexpected EOF", err: io.ErrUnexpectedEOF, retryable: true},
+		{name: "unauthorized", err: status(http.StatusUnauthorized), retryable: false},
+		{name: "forbidden", err: status(http.StatusForbidden), retryable: false},
+		{name: "manifest invalid", err: status(http.StatusBadRequest), retryable: false},
+		{name: "not found", err: fmt.Errorf("manifest: %w", errdefs.ErrNotFound), retryable: false},
+		{name: "canceled", err: context.Canceled, retryable: false},
+		{name: "unknown", err: errors.New("invalid digest"), retryable: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, isRetryable(tc.err), tc.retryable)
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for retry, maxDelay := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 400 * time.Millisecond, 5: time.Second, 40: time.Second} {
+		delay := policy.backoff(retry, errors.New("error"))
+		assert.Assert(t, delay <= maxDelay && delay >= maxDelay/2, "retry %d: unexpected delay %s", retry, delay)
+	}
+
+	// A longer Retry-After delay is honoured
+	delay := policy.backoff(1, &retryAfterError{delay: 5 * time.Second})
+	assert.Equal(t, delay, 5*time.Second)
+}
+
+func TestRetryAfterTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/throttled" {
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: &retryAfterTransport{base: http.DefaultTransport}}
+	get := func(ctx context.Context, path string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+path, nil)
+		assert.NilError(t, err)
+		return client.Do(req)
+	}
+
+	// Without retry policy, the throttled response is returned unchanged
+	resp, err := get(context.Background(), "/throttled")
+	assert.NilError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, resp.StatusCode, http.StatusTooManyRequests)
+
+	ctx := withRetryPolicy(context.Background(), DefaultRetryPolicy())
+	_, err = get(ctx, "/throttled")
+	var retryAfter *retryAfterError
+	assert.Assert(t, errors.As(err, &retryAfter))
+	assert.Equal(t, retryAfter.delay, 2*time.Second)
+
+	// Without Retry-After header, the response is returned unchanged
+	resp, err = get(ctx, "/unavailable")
+	assert.NilError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, resp.StatusCode, http.StatusServiceUnavailable)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	delay, ok := parseRetryAfter("120", now)
+	assert.Assert(t, ok)
+	assert.Equal(t, delay, 2*time.Minute)
+
+	delay, ok = parseRetryAfter(now.Add(30*time.Second).Format(http.TimeFormat), now)
+	assert.Assert(t, ok)
+	assert.Equal(t, delay, 30*time.Second)
+
+	_, ok = parseRetryAfter("soon", now)
+	assert.Assert(t, !ok)
+}
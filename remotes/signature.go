@@ -0,0 +1,262 @@
+package remotes
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/cnabio/cnab-go/bundle"
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/containerd/log"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// SignatureArtifactType is the artifact type of the bundle signatures attached by Sign
+	SignatureArtifactType = "application/vnd.cnab.signature.v1"
+	// SignatureMediaType is the media type of the signature envelope blob of a signature artifact
+	SignatureMediaType = "application/vnd.cnab.signature.envelope.v1+json"
+)
+
+// ErrSignatureVerification is returned when no signature of a bundle can be verified with the trusted keys
+var ErrSignatureVerification = errors.New("signature verification failed")
+
+// signaturePayload is the signed statement: it binds the bundle index to the canonical bundle.json
+type signaturePayload struct {
+	IndexDigest  digest.Digest `json:"indexDigest"`
+	BundleDigest digest.Digest `json:"bundleDigest"`
+}
+
+// signatureEnvelope is the content of a signature blob
+type signatureEnvelope struct {
+	// Payload is the signed payload, kept as raw bytes so it is verified exactly as it has been signed
+	Payload []byte `json:"payload"`
+	// KeyID is the digest of the PKIX encoded public key
+	KeyID     string `json:"keyId"`
+	Signature []byte `json:"signature"`
+}
+
+// Sign signs the bundle index referenced by ref along with the digest of its canonical bundle.json, and attaches the
+// signature to the bundle index as a referrer artifact. Ed25519 and ECDSA keys are supported.
+// The signature can be verified offline when pulling the bundle, with WithSignatureVerification.
+func Sign(ctx context.Context, ref reference.Named, resolver remotes.Resolver, key crypto.Signer) (ocischemav1.Descriptor, error) {
+	log.G(ctx).Debugf("Signing CNAB Bundle %s", ref)
+	index, indexDescriptor, err := getIndex(ctx, ref, resolver)
+	if err != nil {
+		return ocischemav1.Descriptor{}, err
+	}
+	b, err := getBundle(ctx, ref, resolver, index)
+	if err != nil {
+		return ocischemav1.Descriptor{}, err
+	}
+	bundleDigest, err := canonicalBundleDigest(b)
+	if err != nil {
+		return ocischemav1.Descriptor{}, err
+	}
+	payload, err := json.Marshal(signaturePayload{IndexDigest: indexDescriptor.Digest, BundleDigest: bundleDigest})
+	if err != nil {
+		return ocischemav1.Descriptor{}, err
+	}
+	keyID, err := publicKeyID(key.Public())
+	if err != nil {
+		return ocischemav1.Descriptor{}, err
+	}
+	signature, err := signPayload(key, payload)
+	if err != nil {
+		return ocischemav1.Descriptor{}, fmt.Errorf("failed to sign bundle %q: %s", ref, err)
+	}
+	envelope, err := json.Marshal(signatureEnvelope{Payload: payload, KeyID: keyID, Signature: signature})
+	if err != nil {
+		return ocischemav1.Descriptor{}, err
+	}
+
+	// Attach the signature to the signed index, even if the tag has moved since it was resolved
+	repoOnly, err := reference.ParseNormalizedNamed(ref.Name())
+	if err != nil {
+		return ocischemav1.Descriptor{}, err
+	}
+	digested, err := reference.WithDigest(repoOnly, indexDescriptor.Digest)
+	if err != nil {
+		return ocischemav1.Descriptor{}, err
+	}
+	return Attach(ctx, digested, resolver, SignatureArtifactType, []AttachedBlob{
+		{MediaType: SignatureMediaType, Data: envelope},
+	})
+}
+
+// verifyBundleSignature checks that at least one of the signatures attached to the bundle index is valid for one of
+// the trusted keys, and covers both the index and the canonical bundle.json
+func verifyBundleSignature(ctx context.Context, ref reference.Named, resolver remotes.Resolver, indexDigest digest.Digest, b *bundle.Bundle, keys []crypto.PublicKey) error {
+	repoOnly, err := reference.ParseNormalizedNamed(ref.Name())
+	if err != nil {
+		return err
+	}
+	bundleDigest, err := canonicalBundleDigest(b)
+	if err != nil {
+		return err
+	}
+	signatures, err := fetchReferrers(ctx, repoOnly, resolver, indexDigest, SignatureArtifactType)
+	if err != nil {
+		return fmt.Errorf("failed to list the signatures of %s: %s", indexDigest, err)
+	}
+	if len(signatures) == 0 {
+		return fmt.Errorf("%w: bundle %s is not signed", ErrSignatureVerification, ref)
+	}
+	var errs []error
+	for _, signature := range signatures {
+		err := verifySignatureArtifact(ctx, repoOnly, resolver, signature, signaturePayload{IndexDigest: indexDigest, BundleDigest: bundleDigest}, keys)
+		if err == nil {
+			log.G(ctx).Debugf("Bundle %s signature %s verified", ref, signature.Digest)
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("signature %s: %s", signature.Digest, err))
+	}
+	return fmt.Errorf("%w: no valid signature found for bundle %s: %w", ErrSignatureVerification, ref, errors.Join(errs...))
+}
+
+func verifySignatureArtifact(ctx context.Context, repoOnly reference.Named, resolver remotes.Resolver, signature ocischemav1.Descriptor, expected signaturePayload, keys []crypto.PublicKey) error {
+	manifestPayload, err := pullPayload(ctx, resolver, repoOnly.Name(), signature)
+	if err != nil {
+		return err
+	}
+	var manifest ocischemav1.Manifest
+	if err := json.Unmarshal(manifestPayload, &manifest); err != nil {
+		return err
+	}
+	for _, layer := range manifest.Layers {
+		if layer.MediaType != SignatureMediaType {
+			continue
+		}
+		envelopePayload, err := pullPayload(ctx, resolver, repoOnly.Name(), layer)
+		if err != nil {
+			return err
+		}
+		if digest.FromBytes(envelopePayload) != layer.Digest {
+			return fmt.Errorf("signature envelope does not match its digest %s", layer.Digest)
+		}
+		var envelope signatureEnvelope
+		if err := json.Unmarshal(envelopePayload, &envelope); err != nil {
+			return err
+		}
+		if !verifyPayload(keys, envelope.Payload, envelope.Signature) {
+			return fmt.Errorf("signature by key %s is not valid for any of the trusted keys", envelope.KeyID)
+		}
+		var payload signaturePayload
+		if err := json.Unmarshal(envelope.Payload, &payload); err != nil {
+			return err
+		}
+		if payload != expected {
+			return fmt.Errorf("signed index %s and bundle %s do not match the pulled index %s and bundle %s",
+				payload.IndexDigest, payload.BundleDigest, expected.IndexDigest, expected.BundleDigest)
+		}
+		return nil
+	}
+	return errors.New("no signature envelope found")
+}
+
+// canonicalBundleDigest returns the digest of the canonical JSON of the bundle, as serialized by Bundle.Marshal. It is
+// computed from the bundle itself, not from the pushed config blob, which may be the OCI 1.1 empty config or use a
+// fallback media type, so the digest does not depend on the format the bundle was pushed with.
+func canonicalBundleDigest(b *bundle.Bundle) (digest.Digest, error) {
+	canonical, err := b.Marshal()
+	if err != nil {
+		return "", err
+	}
+	return digest.FromBytes(canonical), nil
+}
+
+func signPayload(key crypto.Signer, payload []byte) ([]byte, error) {
+	switch key.Public().(type) {
+	case ed25519.PublicKey:
+		return key.Sign(rand.Reader, payload, crypto.Hash(0))
+	case *ecdsa.PublicKey:
+		hash := sha256.Sum256(payload)
+		return key.Sign(rand.Reader, hash[:], crypto.SHA256)
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, only ed25519 and ECDSA keys are supported", key.Public())
+	}
+}
+
+func verifyPayload(keys []crypto.PublicKey, payload, signature []byte) bool {
+	for _, key := range keys {
+		switch k := key.(type) {
+		case ed25519.PublicKey:
+			if ed25519.Verify(k, payload, signature) {
+				return true
+			}
+		case *ecdsa.PublicKey:
+			hash := sha256.Sum256(payload)
+			if ecdsa.VerifyASN1(k, hash[:], signature) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func publicKeyID(key crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	return digest.FromBytes(der).String(), nil
+}
+
+// ParsePrivateKeyPEM parses an ed25519 or ECDSA private key, PEM encoded in the PKCS #8 or SEC 1 format
+func ParsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM encoded private key found")
+	}
+	var (
+		key interface{}
+		err error
+	)
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		return k, nil
+	case *ecdsa.PrivateKey:
+		return k, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, only ed25519 and ECDSA keys are supported", key)
+	}
+}
+
+// ParsePublicKeyPEM parses an ed25519 or ECDSA public key, PEM encoded in the PKIX format
+func ParsePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("no PEM encoded public key found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case ed25519.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, only ed25519 and ECDSA keys are supported", key)
+	}
+}
@@ -0,0 +1,105 @@
+package remotes
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+
+	"github.com/cnabio/cnab-go/bundle"
+	"github.com/cnabio/cnab-to-oci/relocation"
+	"github.com/distribution/reference"
+	"gotest.tools/v3/assert"
+)
+
+func TestSignAndVerifyBundle(t *testing.T) {
+	for _, referrersAPI := range []bool{false, true} {
+		reg := newMemoryRegistry()
+		reg.referrersAPI = referrersAPI
+		ref := mustParseNamed(t, "my.registry/namespace/my-app:0.1.0")
+		b, pushedDescriptor := pushTestBundle(t, reg, ref)
+
+		_, edKey, err := ed25519.GenerateKey(rand.Reader)
+		assert.NilError(t, err)
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		assert.NilError(t, err)
+		_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+		assert.NilError(t, err)
+
+		// An unsigned bundle fails the verification
+		_, _, _, err = Pull(context.Background(), ref, reg, WithSignatureVerification(edKey.Public()))
+		assert.Assert(t, errors.Is(err, ErrSignatureVerification))
+		assert.ErrorContains(t, err, "is not signed")
+
+		signature, err := Sign(context.Background(), ref, reg, edKey)
+		assert.NilError(t, err)
+		referrers, err := ListReferrers(context.Background(), ref, reg, SignatureArtifactType)
+		assert.NilError(t, err)
+		assert.Equal(t, len(referrers), 1)
+		assert.Equal(t, referrers[0].Digest, signature.Digest)
+		_, err = Sign(context.Background(), ref, reg, ecKey)
+		assert.NilError(t, err)
+
+		for _, key := range []crypto.PublicKey{edKey.Public(), ecKey.Public()} {
+			pulled, _, d, err := Pull(context.Background(), ref, reg, WithSignatureVerification(otherKey.Public(), key))
+			assert.NilError(t, err)
+			assert.DeepEqual(t, pulled, b)
+			assert.Equal(t, d, pushedDescriptor.Digest)
+		}
+		_, _, _, err = Pull(context.Background(), ref, reg, WithSignatureVerification(otherKey.Public()))
+		assert.Assert(t, errors.Is(err, ErrSignatureVerification))
+		assert.ErrorContains(t, err, "not valid for any of the trusted keys")
+
+		// A bundle pushed again under the same tag is not covered by the previous signatures
+		tampered := *b
+		tampered.Description = "tampered"
+		_, err = Push(context.Background(), &tampered, relocationMapOf(b, ref), ref, reg, false)
+		assert.NilError(t, err)
+		_, _, _, err = Pull(context.Background(), ref, reg, WithSignatureVerification(edKey.Public()))
+		assert.Assert(t, errors.Is(err, ErrSignatureVerification))
+	}
+}
+
+func TestParseKeysPEM(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NilError(t, err)
+	sec1, err := x509.MarshalECPrivateKey(ecKey)
+	assert.NilError(t, err)
+	parsed, err := ParsePrivateKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1}))
+	assert.NilError(t, err)
+	assert.Assert(t, ecKey.Equal(parsed))
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NilError(t, err)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(edKey)
+	assert.NilError(t, err)
+	parsed, err = ParsePrivateKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}))
+	assert.NilError(t, err)
+	assert.Assert(t, edKey.Equal(parsed))
+
+	pkix, err := x509.MarshalPKIXPublicKey(edKey.Public())
+	assert.NilError(t, err)
+	publicKey, err := ParsePublicKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkix}))
+	assert.NilError(t, err)
+	assert.Assert(t, edKey.Public().(ed25519.PublicKey).Equal(publicKey))
+
+	_, err = ParsePublicKeyPEM([]byte("not a key"))
+	assert.ErrorContains(t, err, "no PEM encoded public key found")
+}
+
+func relocationMapOf(b *bundle.Bundle, ref reference.Named) relocation.ImageRelocationMap {
+	relocationMap := relocation.ImageRelocationMap{}
+	for _, img := range b.InvocationImages {
+		relocationMap[img.Image] = ref.Name() + "@" + img.Digest
+	}
+	for _, img := range b.Images {
+		relocationMap[img.Image] = ref.Name() + "@" + img.Digest
+	}
+	return relocationMap
+}
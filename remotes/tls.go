@@ -0,0 +1,114 @@
+package remotes
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// RegistryTLSConfig is the TLS configuration used to reach a registry host
+type RegistryTLSConfig struct {
+	// CAFiles are PEM encoded CA bundles trusted in addition to the system roots
+	CAFiles []string
+	// CertFile and KeyFile are the PEM encoded client certificate and key, for the registries requiring mutual TLS
+	CertFile string
+	KeyFile  string
+	// ServerName is the name verified in the server certificate, instead of the host name
+	ServerName string
+	// InsecureSkipVerify disables the verification of the server certificate
+	InsecureSkipVerify bool
+}
+
+// tlsConfig loads the certificates of the configuration
+func (c RegistryTLSConfig) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if len(c.CAFiles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for _, file := range c.CAFiles {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			if !pool.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("no PEM encoded certificate found in CA file %q", file)
+			}
+		}
+		config.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate %q and key %q: %s", c.CertFile, c.KeyFile, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// LoadCertsDir loads a docker style certificates directory, like /etc/docker/certs.d, containing a directory per
+// registry host. The "*.crt" files of a host directory are CA bundles, and a "<name>.cert" file is a client
+// certificate whose key is "<name>.key".
+func LoadCertsDir(dir string) (map[string]RegistryTLSConfig, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	configs := map[string]RegistryTLSConfig{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		config, err := loadHostCertsDir(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		configs[entry.Name()] = config
+	}
+	return configs, nil
+}
+
+func loadHostCertsDir(dir string) (RegistryTLSConfig, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return RegistryTLSConfig{}, err
+	}
+	var config RegistryTLSConfig
+	var certs []string
+	for _, entry := range entries {
+		file := filepath.Join(dir, entry.Name())
+		switch filepath.Ext(entry.Name()) {
+		case ".crt":
+			config.CAFiles = append(config.CAFiles, file)
+		case ".cert":
+			certs = append(certs, file)
+		case ".key":
+			if _, err := os.Stat(strings.TrimSuffix(file, ".key") + ".cert"); errors.Is(err, os.ErrNotExist) {
+				return RegistryTLSConfig{}, fmt.Errorf("missing client certificate %s.cert for key %s", strings.TrimSuffix(entry.Name(), ".key"), file)
+			}
+		}
+	}
+	sort.Strings(config.CAFiles)
+	switch len(certs) {
+	case 0:
+	case 1:
+		config.CertFile = certs[0]
+		config.KeyFile = strings.TrimSuffix(certs[0], ".cert") + ".key"
+		if _, err := os.Stat(config.KeyFile); err != nil {
+			return RegistryTLSConfig{}, fmt.Errorf("missing key for client certificate %s: %s", config.CertFile, err)
+		}
+	default:
+		return RegistryTLSConfig{}, fmt.Errorf("several client certificates found in %s, only one is supported", dir)
+	}
+	return config, nil
+}
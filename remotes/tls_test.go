@@ -0,0 +1,184 @@
+package remotes
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/opencontainers/go-digest"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"gotest.tools/v3/assert"
+)
+
+// testCertificate is a certificate and its key, PEM encoded
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	// certPEM and keyPEM are the PEM encoded certificate and key
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCertificate creates a certificate signed by the parent, or a self signed CA if the parent is nil
+func newTestCertificate(t *testing.T, parent *testCertificate, template *x509.Certificate) *testCertificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NilError(t, err)
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	signerCert, signerKey := template, key
+	if parent != nil {
+		signerCert, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, &key.PublicKey, signerKey)
+	assert.NilError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NilError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NilError(t, err)
+	return &testCertificate{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func TestResolverClientCertificate(t *testing.T) {
+	ca := newTestCertificate(t, nil, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	})
+	serverCert := newTestCertificate(t, ca, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "registry"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	clientCert := newTestCertificate(t, ca, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "client"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	serverKeyPair, err := tls.X509KeyPair(serverCert.certPEM, serverCert.keyPEM)
+	assert.NilError(t, err)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/app/manifests/latest" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", ocischemav1.MediaTypeImageManifest)
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(manifest).String())
+		w.Header().Set("Content-Length", strconv.Itoa(len(manifest)))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(manifest)
+		}
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverKeyPair},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	server.StartTLS()
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	certsDir := t.TempDir()
+	hostDir := filepath.Join(certsDir, host)
+	assert.NilError(t, os.MkdirAll(hostDir, 0755))
+	assert.NilError(t, os.WriteFile(filepath.Join(hostDir, "ca.crt"), ca.certPEM, 0644))
+	assert.NilError(t, os.WriteFile(filepath.Join(hostDir, "client.cert"), clientCert.certPEM, 0644))
+	assert.NilError(t, os.WriteFile(filepath.Join(hostDir, "client.key"), clientCert.keyPEM, 0600))
+
+	// Without the client certificate, the TLS handshake fails
+	resolver, err := CreateResolverWithOptions(configfile.New(""), WithRegistryTLS(map[string]RegistryTLSConfig{
+		host: {CAFiles: []string{filepath.Join(hostDir, "ca.crt")}},
+	}))
+	assert.NilError(t, err)
+	_, _, err = resolver.Resolve(context.Background(), host+"/app:latest")
+	assert.ErrorContains(t, err, "")
+
+	resolver, err = CreateResolverWithOptions(configfile.New(""), WithCertsDir(certsDir))
+	assert.NilError(t, err)
+	_, desc, err := resolver.Resolve(context.Background(), host+"/app:latest")
+	assert.NilError(t, err)
+	assert.Equal(t, desc.Digest, digest.FromBytes(manifest))
+
+	// The certificates can also be given by a hosts.toml file, relative to its directory
+	assert.NilError(t, os.WriteFile(filepath.Join(hostDir, "hosts.toml"), []byte(`
+ca = "ca.crt"
+client = [["client.cert", "client.key"]]
+`), 0644))
+	resolver, err = CreateResolverWithOptions(configfile.New(""), WithHostsDir(certsDir))
+	assert.NilError(t, err)
+	_, desc, err = resolver.Resolve(context.Background(), host+"/app:latest")
+	assert.NilError(t, err)
+	assert.Equal(t, desc.Digest, digest.FromBytes(manifest))
+}
+
+func TestInsecureRegistryWithTLSConfigFallsBackToPlainHTTP(t *testing.T) {
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)
+	server := newTestRegistryServer("/v2/namespace/app/manifests/latest", manifest)
+	defer server.Close()
+
+	// The registry does not answer over HTTPS, so its TLS configuration is not used
+	resolver, err := CreateResolverWithOptions(configfile.New(""),
+		WithInsecureRegistries(server.host()),
+		WithRegistryTLS(map[string]RegistryTLSConfig{server.host(): {ServerName: "registry.example.com"}}))
+	assert.NilError(t, err)
+	_, desc, err := resolver.Resolve(context.Background(), server.host()+"/namespace/app:latest")
+	assert.NilError(t, err)
+	assert.Equal(t, desc.Digest, digest.FromBytes(manifest))
+}
+
+func TestLoadCertsDir(t *testing.T) {
+	dir := t.TempDir()
+	assert.NilError(t, os.MkdirAll(filepath.Join(dir, "my.registry:5000"), 0755))
+	for _, file := range []string{"b.crt", "a.crt", "client.cert", "client.key", "README"} {
+		assert.NilError(t, os.WriteFile(filepath.Join(dir, "my.registry:5000", file), nil, 0644))
+	}
+	configs, err := LoadCertsDir(dir)
+	assert.NilError(t, err)
+	hostDir := filepath.Join(dir, "my.registry:5000")
+	assert.DeepEqual(t, configs, map[string]RegistryTLSConfig{
+		"my.registry:5000": {
+			CAFiles:  []string{filepath.Join(hostDir, "a.crt"), filepath.Join(hostDir, "b.crt")},
+			CertFile: filepath.Join(hostDir, "client.cert"),
+			KeyFile:  filepath.Join(hostDir, "client.key"),
+		},
+	})
+
+	assert.NilError(t, os.WriteFile(filepath.Join(hostDir, "other.cert"), nil, 0644))
+	_, err = LoadCertsDir(dir)
+	assert.ErrorContains(t, err, "several client certificates")
+	assert.NilError(t, os.Remove(filepath.Join(hostDir, "other.cert")))
+	assert.NilError(t, os.Remove(filepath.Join(hostDir, "client.cert")))
+	_, err = LoadCertsDir(dir)
+	assert.ErrorContains(t, err, "missing client certificate client.cert")
+
+	_, err = CreateResolverWithOptions(configfile.New(""), WithRegistryTLS(map[string]RegistryTLSConfig{
+		"my.registry": {CAFiles: []string{filepath.Join(hostDir, "a.crt")}},
+	}))
+	assert.ErrorContains(t, err, `invalid TLS configuration of "my.registry": no PEM encoded certificate found`)
+}
@@ -0,0 +1,56 @@
+package remotes
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
+)
+
+// newTransport returns the transport sending the requests of the resolver, using the TLS configuration if any. The
+// base transport is cloned when it has to be customized, and wrapped by the middlewares.
+func (cfg resolverConfig) newTransport(tlsConfig *tls.Config) http.RoundTripper {
+	base := cfg.baseTransport()
+	if transport, ok := base.(*http.Transport); ok && (tlsConfig != nil || cfg.customProxy()) {
+		transport = transport.Clone()
+		if tlsConfig != nil {
+			transport.TLSClientConfig = tlsConfig
+		}
+		if cfg.customProxy() {
+			transport.Proxy = cfg.proxyFunc(transport.Proxy)
+		}
+		base = transport
+	}
+	for _, middleware := range cfg.middlewares {
+		base = middleware(base)
+	}
+	return base
+}
+
+func (cfg resolverConfig) baseTransport() http.RoundTripper {
+	if cfg.transport == nil {
+		return http.DefaultTransport
+	}
+	return cfg.transport
+}
+
+func (cfg resolverConfig) customProxy() bool {
+	return cfg.proxy != nil || len(cfg.registryProxies) > 0
+}
+
+// proxyFunc returns the proxy of the registry hosts, falling back to the proxy of the options, or the one of the base
+// transport
+func (cfg resolverConfig) proxyFunc(baseProxy func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	proxy := baseProxy
+	if cfg.proxy != nil {
+		proxy = cfg.proxy
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		if proxyURL, ok := cfg.registryProxies[req.URL.Host]; ok {
+			return proxyURL, nil
+		}
+		if proxy == nil {
+			return nil, nil
+		}
+		return proxy(req)
+	}
+}
@@ -0,0 +1,115 @@
+package remotes
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/opencontainers/go-digest"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"gotest.tools/v3/assert"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestResolverTransportMiddleware(t *testing.T) {
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)
+	var (
+		mu     sync.Mutex
+		tagged []string
+	)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		tagged = append(tagged, r.Header.Get("X-Request-Tag")+" "+r.URL.Path)
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/token":
+			_, _ = w.Write([]byte(`{"token":"secret"}`))
+		case "/v2/app/manifests/latest":
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry"`, server.URL))
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Content-Type", ocischemav1.MediaTypeImageManifest)
+			w.Header().Set("Docker-Content-Digest", digest.FromBytes(manifest).String())
+			w.Header().Set("Content-Length", strconv.Itoa(len(manifest)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	host := server.Listener.Addr().String()
+
+	resolver, err := CreateResolverWithOptions(configfile.New(""),
+		WithTransport(http.DefaultTransport.(*http.Transport).Clone()),
+		WithTransportMiddleware(func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				req = req.Clone(req.Context())
+				req.Header.Set("X-Request-Tag", "cnab-to-oci")
+				return next.RoundTrip(req)
+			})
+		}))
+	assert.NilError(t, err)
+	_, desc, err := resolver.Resolve(context.Background(), host+"/app:latest")
+	assert.NilError(t, err)
+	assert.Equal(t, desc.Digest, digest.FromBytes(manifest))
+
+	// The token requests of the authorizer also go through the middleware
+	mu.Lock()
+	defer mu.Unlock()
+	assert.DeepEqual(t, tagged, []string{
+		"cnab-to-oci /v2/app/manifests/latest",
+		"cnab-to-oci /token",
+		"cnab-to-oci /v2/app/manifests/latest",
+	})
+}
+
+func TestResolverRegistryProxies(t *testing.T) {
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)
+	proxy := newTestRegistryServer("/v2/app/manifests/latest", manifest)
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	assert.NilError(t, err)
+
+	resolver, err := CreateResolverWithOptions(configfile.New(""),
+		WithRegistryHosts(map[string]RegistryHostConfig{
+			"my.registry": {Server: &RegistryEndpoint{Host: "my.registry", Scheme: "http"}},
+		}),
+		WithProxy(func(*http.Request) (*url.URL, error) {
+			return nil, fmt.Errorf("unexpected proxy lookup")
+		}),
+		WithRegistryProxies(map[string]*url.URL{"my.registry": proxyURL}))
+	assert.NilError(t, err)
+	_, desc, err := resolver.Resolve(context.Background(), "my.registry/app:latest")
+	assert.NilError(t, err)
+	assert.Equal(t, desc.Digest, digest.FromBytes(manifest))
+	assert.DeepEqual(t, proxy.received(), []string{"HEAD /v2/app/manifests/latest"})
+
+	_, err = CreateResolverWithOptions(configfile.New(""), WithRegistryProxies(map[string]*url.URL{"my.registry": {Path: "/proxy"}}))
+	assert.ErrorContains(t, err, `invalid proxy "/proxy" of registry "my.registry": no host`)
+}
+
+func TestResolverCustomRoundTripper(t *testing.T) {
+	roundTripper := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, fmt.Errorf("not implemented")
+	})
+	_, err := CreateResolverWithOptions(configfile.New(""), WithTransport(roundTripper))
+	assert.NilError(t, err)
+
+	_, err = CreateResolverWithOptions(configfile.New(""), WithTransport(roundTripper), WithInsecureRegistries("my.registry"))
+	assert.ErrorContains(t, err, "require an *http.Transport, not remotes.roundTripperFunc")
+	_, err = CreateResolverWithOptions(configfile.New(""), WithTransport(roundTripper), WithProxyFromEnvironment())
+	assert.ErrorContains(t, err, "the proxy configuration requires an *http.Transport")
+}
@@ -0,0 +1,266 @@
+package remotes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/containerd/containerd/v2/core/remotes"
+	"github.com/containerd/errdefs"
+	"github.com/containerd/log"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	ocischemav1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// VerifyStatus is the result of the verification of a descriptor
+type VerifyStatus string
+
+const (
+	// VerifyStatusOK is set when the content is present with the expected size (and digest, for a manifest or a
+	// deep verification)
+	VerifyStatusOK = VerifyStatus("OK")
+	// VerifyStatusMissing is set when the content is not found in the repository
+	VerifyStatusMissing = VerifyStatus("Missing")
+	// VerifyStatusSizeMismatch is set when the content size differs from the descriptor size
+	VerifyStatusSizeMismatch = VerifyStatus("SizeMismatch")
+	// VerifyStatusDigestMismatch is set when the content does not hash to the descriptor digest
+	VerifyStatusDigestMismatch = VerifyStatus("DigestMismatch")
+	// VerifyStatusSkipped is set for foreign layers, which are not distributed by the registry
+	VerifyStatusSkipped = VerifyStatus("Skipped")
+	// VerifyStatusError is set when the content could not be checked, its children are not verified
+	VerifyStatusError = VerifyStatus("Error")
+)
+
+// VerifyReport is the result of the verification of a bundle pushed to a registry
+type VerifyReport struct {
+	Reference       string                 `json:"reference"`
+	IndexDescriptor ocischemav1.Descriptor `json:"indexDescriptor"`
+	// Descriptors are the verified descriptors of the bundle index, with their children
+	Descriptors []DescriptorVerification `json:"descriptors"`
+}
+
+// DescriptorVerification is the verification of a manifest or a blob, and of its children
+type DescriptorVerification struct {
+	ocischemav1.Descriptor
+	Status   VerifyStatus             `json:"status"`
+	Message  string                   `json:"message,omitempty"`
+	Children []DescriptorVerification `json:"children,omitempty"`
+}
+
+// Complete returns true if all the content referenced by the bundle is present in the repository
+func (r *VerifyReport) Complete() bool {
+	return len(r.Failures()) == 0
+}
+
+// Failures returns the verifications of the missing or invalid descriptors. The content shared between several
+// manifests is only returned once.
+func (r *VerifyReport) Failures() []DescriptorVerification {
+	return collectFailures(r.Descriptors, map[digest.Digest]struct{}{})
+}
+
+func collectFailures(verifications []DescriptorVerification, seen map[digest.Digest]struct{}) []DescriptorVerification {
+	var failures []DescriptorVerification
+	for _, v := range verifications {
+		if _, ok := seen[v.Digest]; ok {
+			continue
+		}
+		seen[v.Digest] = struct{}{}
+		if v.Status != VerifyStatusOK && v.Status != VerifyStatusSkipped {
+			failures = append(failures, v)
+		}
+		failures = append(failures, collectFailures(v.Children, seen)...)
+	}
+	return failures
+}
+
+// verifyConfig defines the input required for a Verify operation
+type verifyConfig struct {
+	deep        bool
+	retryPolicy RetryPolicy
+}
+
+// VerifyOption is a helper for configuring a Verify
+type VerifyOption func(*verifyConfig) error
+
+func newVerifyConfig(options ...VerifyOption) (verifyConfig, error) {
+	var cfg verifyConfig
+	for _, opt := range options {
+		if err := opt(&cfg); err != nil {
+			return verifyConfig{}, err
+		}
+	}
+	return cfg, nil
+}
+
+// WithDeepVerification downloads every blob to check its digest, instead of only checking its presence and size
+func WithDeepVerification() VerifyOption {
+	return func(cfg *verifyConfig) error {
+		cfg.deep = true
+		return nil
+	}
+}
+
+// WithVerifyRetryPolicy retries the registry operations failing with a transient error according to the given policy
+func WithVerifyRetryPolicy(policy RetryPolicy) VerifyOption {
+	return func(cfg *verifyConfig) error {
+		cfg.retryPolicy = policy
+		return nil
+	}
+}
+
+// Verify checks that a bundle pushed to a registry is complete: every descriptor of the bundle index, and
+// recursively every child manifest and blob, must be present in the repository with the expected size. The manifests
+// are always downloaded and hashed, the blobs only with WithDeepVerification.
+// An error is only returned if the bundle index itself cannot be fetched, the missing or invalid content is listed by
+// the report.
+func Verify(ctx context.Context, ref reference.Named, resolver remotes.Resolver, opts ...VerifyOption) (*VerifyReport, error) {
+	log.G(ctx).Debugf("Verifying CNAB Bundle %s", ref)
+	cfg, err := newVerifyConfig(opts...)
+	if err != nil {
+		return nil, err
+	}
+	ctx = withRetryPolicy(ctx, cfg.retryPolicy)
+	index, indexDescriptor, err := getIndex(ctx, ref, resolver)
+	if err != nil {
+		return nil, err
+	}
+	repoOnly, err := reference.ParseNormalizedNamed(ref.Name())
+	if err != nil {
+		return nil, fmt.Errorf("invalid bundle manifest reference name %q: %s", ref, err)
+	}
+	fetcher, err := resolver.Fetcher(ctx, repoOnly.Name())
+	if err != nil {
+		return nil, err
+	}
+	v := &verifier{
+		cfg:      cfg,
+		resolver: resolver,
+		fetcher:  fetcher,
+		repoOnly: repoOnly,
+		verified: map[digest.Digest]DescriptorVerification{},
+	}
+	report := &VerifyReport{Reference: ref.String(), IndexDescriptor: indexDescriptor}
+	for _, d := range index.Manifests {
+		report.Descriptors = append(report.Descriptors, v.verify(withMutedContext(ctx), d))
+	}
+	return report, nil
+}
+
+type verifier struct {
+	cfg      verifyConfig
+	resolver remotes.Resolver
+	fetcher  remotes.Fetcher
+	repoOnly reference.Named
+	// verified caches the verifications of the content shared between several manifests
+	verified map[digest.Digest]DescriptorVerification
+}
+
+func (v *verifier) verify(ctx context.Context, desc ocischemav1.Descriptor) DescriptorVerification {
+	if result, ok := v.verified[desc.Digest]; ok {
+		result.Descriptor = desc
+		return result
+	}
+	result := DescriptorVerification{Descriptor: desc}
+	switch {
+	case desc.Digest.Validate() != nil:
+		result.Status, result.Message = VerifyStatusError, fmt.Sprintf("invalid digest: %s", desc.Digest.Validate())
+	case len(desc.URLs) > 0:
+		result.Status, result.Message = VerifyStatusSkipped, "foreign layer"
+	case isManifest(desc.MediaType):
+		v.verifyManifest(ctx, &result)
+	default:
+		v.verifyBlob(ctx, &result)
+	}
+	v.verified[desc.Digest] = result
+	return result
+}
+
+func (v *verifier) verifyManifest(ctx context.Context, result *DescriptorVerification) {
+	payload, err := pullPayload(ctx, v.resolver, v.repoOnly.Name(), result.Descriptor)
+	if err != nil {
+		setVerifyError(result, err)
+		return
+	}
+	if !checkContent(result, int64(len(payload)), result.Digest.Algorithm().FromBytes(payload)) {
+		return
+	}
+	var manifest struct {
+		Manifests []ocischemav1.Descriptor `json:"manifests"`
+		Config    *ocischemav1.Descriptor  `json:"config"`
+		Layers    []ocischemav1.Descriptor `json:"layers"`
+	}
+	if err := json.Unmarshal(payload, &manifest); err != nil {
+		result.Status, result.Message = VerifyStatusError, fmt.Sprintf("invalid manifest: %s", err)
+		return
+	}
+	children := manifest.Manifests
+	if manifest.Config != nil {
+		children = append(children, *manifest.Config)
+	}
+	children = append(children, manifest.Layers...)
+	for _, c := range children {
+		result.Children = append(result.Children, v.verify(ctx, c))
+	}
+}
+
+func (v *verifier) verifyBlob(ctx context.Context, result *DescriptorVerification) {
+	if !v.cfg.deep {
+		ref, err := reference.WithDigest(v.repoOnly, result.Digest)
+		if err != nil {
+			setVerifyError(result, err)
+			return
+		}
+		_, desc, err := resolve(ctx, v.resolver, ref.String())
+		if err != nil {
+			setVerifyError(result, err)
+			return
+		}
+		checkContent(result, desc.Size, result.Digest)
+		return
+	}
+	var (
+		size     int64
+		computed digest.Digest
+	)
+	err := retry(ctx, fmt.Sprintf("fetch %s", result.Digest), func() error {
+		reader, err := v.fetcher.Fetch(ctx, result.Descriptor)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		digester := result.Digest.Algorithm().Digester()
+		size, err = io.Copy(digester.Hash(), reader)
+		computed = digester.Digest()
+		return err
+	})
+	if err != nil {
+		setVerifyError(result, err)
+		return
+	}
+	checkContent(result, size, computed)
+}
+
+// checkContent sets the verification status from the size and the digest of the content found in the repository
+func checkContent(result *DescriptorVerification, size int64, computed digest.Digest) bool {
+	switch {
+	case size != result.Size:
+		result.Status, result.Message = VerifyStatusSizeMismatch, fmt.Sprintf("expected %d bytes, found %d", result.Size, size)
+	case computed != result.Digest:
+		result.Status, result.Message = VerifyStatusDigestMismatch, fmt.Sprintf("content hashes to %s", computed)
+	default:
+		result.Status = VerifyStatusOK
+		return true
+	}
+	return false
+}
+
+func setVerifyError(result *DescriptorVerification, err error) {
+	if errors.Is(err, errdefs.ErrNotFound) {
+		result.Status = VerifyStatusMissing
+		return
+	}
+	result.Status, result.Message = VerifyStatusError, err.Error()
+}
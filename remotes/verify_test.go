@@ -0,0 +1,64 @@
+package remotes
+
+import (
+	"context"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"gotest.tools/v3/assert"
+)
+
+func TestVerify(t *testing.T) {
+	reg := newMemoryRegistry()
+	ref := mustParseNamed(t, "my.registry/namespace/my-app:0.1.0")
+	_, pushedDescriptor := pushTestBundle(t, reg, ref)
+
+	report, err := Verify(context.Background(), ref, reg, WithDeepVerification())
+	assert.NilError(t, err)
+	assert.Assert(t, report.Complete())
+	assert.Equal(t, report.IndexDescriptor.Digest, pushedDescriptor.Digest)
+	// Bundle config, invocation image and component image
+	assert.Equal(t, len(report.Descriptors), 3)
+	for _, d := range report.Descriptors {
+		assert.Equal(t, d.Status, VerifyStatusOK)
+		assert.Assert(t, len(d.Children) > 0, "children of %s are not verified", d.Digest)
+	}
+
+	// A blob is garbage collected, and another one is corrupted with the same size
+	invocationManifest := readTestManifest(t, reg, ref.Name(), report.Descriptors[1].Descriptor)
+	serviceManifest := readTestManifest(t, reg, ref.Name(), report.Descriptors[2].Descriptor)
+	repo := reg.repository(ref.Name())
+	delete(repo.content, invocationManifest.Layers[0].Digest)
+	delete(repo.descriptors, invocationManifest.Layers[0].Digest)
+	corrupted := make([]byte, serviceManifest.Layers[0].Size)
+	repo.content[serviceManifest.Layers[0].Digest] = corrupted
+
+	report, err = Verify(context.Background(), ref, reg)
+	assert.NilError(t, err)
+	assert.Assert(t, !report.Complete())
+	failures := report.Failures()
+	assert.Equal(t, len(failures), 1)
+	assert.Equal(t, failures[0].Digest, invocationManifest.Layers[0].Digest)
+	assert.Equal(t, failures[0].Status, VerifyStatusMissing)
+
+	report, err = Verify(context.Background(), ref, reg, WithDeepVerification())
+	assert.NilError(t, err)
+	failures = report.Failures()
+	assert.Equal(t, len(failures), 2)
+	assert.Equal(t, failures[0].Status, VerifyStatusMissing)
+	assert.Equal(t, failures[1].Digest, serviceManifest.Layers[0].Digest)
+	assert.Equal(t, failures[1].Status, VerifyStatusDigestMismatch)
+	assert.Equal(t, failures[1].Message, "content hashes to "+digest.FromBytes(corrupted).String())
+
+	// The config shared by both images is only reported once
+	delete(repo.content, invocationManifest.Config.Digest)
+	delete(repo.descriptors, invocationManifest.Config.Digest)
+	report, err = Verify(context.Background(), ref, reg)
+	assert.NilError(t, err)
+	assert.Equal(t, invocationManifest.Config.Digest, serviceManifest.Config.Digest)
+	failures = report.Failures()
+	assert.Equal(t, len(failures), 2)
+	assert.Equal(t, failures[0].Digest, invocationManifest.Config.Digest)
+	assert.Equal(t, failures[0].Status, VerifyStatusMissing)
+	assert.Equal(t, failures[1].Digest, invocationManifest.Layers[0].Digest)
+}